@@ -18,8 +18,13 @@ Usage:
 
 The commands are:
 
+	create            creates an action definition from a template
 	exec              executes an action to produce patches
-	scope-query       list the repositories matched by "scopeQuery" in action
+	resume            resumes an interrupted or partially failed action execution
+	scope-query       list the repositories in the scope of an action
+	validate          checks an action definition without executing it
+	cache             manages the cache of executed actions
+	convert-patches   converts produced patches between formats
 
 Use "src actions [command] -h" for more information about a command.
 `
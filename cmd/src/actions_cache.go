@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
+
+var actionsCacheCommands commander
+
+func init() {
+	usage := `'src actions cache' manages the cache of 'src actions exec'. It holds the results of executing actions in repositories and the "cacheDirs" volumes of docker and podman steps.
+
+EXPERIMENTAL: Actions are experimental functionality on Sourcegraph and in the 'src' tool.
+
+Usage:
+
+	src actions cache command [command options]
+
+The commands are:
+
+	serve             serves a cache that is shared by a team
+	ls                lists the entries of the cache
+	stats             prints the number and size of the entries of the cache
+	prune             evicts the least recently used entries
+	clear             removes the entries of a repository or an action
+
+Use "src actions cache [command] -h" for more information about a command.
+`
+
+	flagSet := flag.NewFlagSet("cache", flag.ExitOnError)
+	handler := func(args []string) error {
+		actionsCacheCommands.run(flagSet, "src actions cache", usage, args)
+		return nil
+	}
+
+	// Register the command.
+	actionsCommands = append(actionsCommands, &command{
+		flagSet: flagSet,
+		handler: handler,
+		usageFunc: func() {
+			fmt.Println(usage)
+		},
+	})
+}
+
+const (
+	defaultCacheMaxSize = "10GB"
+	defaultCacheMaxAge  = 30 * 24 * time.Hour
+)
+
+// cacheFlags are the flags for the locations of the cache, which all commands
+// that use it share.
+type cacheFlags struct {
+	resultsDir, displayResultsDir string
+	volumesDir, displayVolumesDir string
+
+	results *string
+	volumes *string
+}
+
+func newCacheFlags(flagSet *flag.FlagSet) *cacheFlags {
+	f := &cacheFlags{}
+	f.resultsDir, f.displayResultsDir = userCacheSubdir("action-exec")
+	f.volumesDir, f.displayVolumesDir = userCacheSubdir("action-exec-cache-dir")
+	f.results = flagSet.String("cache", f.displayResultsDir, "Directory for caching results.")
+	f.volumes = flagSet.String("cache-volumes", f.displayVolumesDir, `Directory for the volumes mounted at the "cacheDirs" of docker and podman steps.`)
+	return f
+}
+
+// manager returns the CacheManager for the flags. actionID is recorded with
+// new entries.
+func (f *cacheFlags) manager(actionID string) (campaigns.CacheManager, error) {
+	if *f.results == f.displayResultsDir {
+		*f.results = f.resultsDir
+	}
+	if *f.volumes == f.displayVolumesDir {
+		*f.volumes = f.volumesDir
+	}
+
+	if *f.results == "" {
+		// This can only happen if `userCacheDir()` fails or the user
+		// specifies a blank string.
+		return campaigns.CacheManager{}, errors.New("cache is not a valid path")
+	}
+	if *f.volumes == "" {
+		return campaigns.CacheManager{}, errors.New("cache-volumes is not a valid path")
+	}
+
+	return campaigns.CacheManager{
+		Results: campaigns.ExecutionDiskCache{Dir: *f.results, Action: actionID},
+		Volumes: campaigns.CacheDirVolumes{Dir: *f.volumes, Action: actionID},
+	}, nil
+}
+
+// cachePolicyFlags are the flags for the eviction policy of the cache.
+type cachePolicyFlags struct {
+	maxSize *string
+	maxAge  *time.Duration
+}
+
+func newCachePolicyFlags(flagSet *flag.FlagSet, prefix string) *cachePolicyFlags {
+	return &cachePolicyFlags{
+		maxSize: flagSet.String(prefix+"max-size", defaultCacheMaxSize, `The maximum size of the cache, e.g. "10GB". The least recently used entries are evicted when it is exceeded. 0 means no limit.`),
+		maxAge:  flagSet.Duration(prefix+"max-age", defaultCacheMaxAge, "The duration after which cache entries that weren't used are evicted. 0 means no limit."),
+	}
+}
+
+func (f *cachePolicyFlags) policy() (campaigns.CachePolicy, error) {
+	maxSize, err := humanize.ParseBytes(*f.maxSize)
+	if err != nil {
+		return campaigns.CachePolicy{}, &usageError{errors.Wrap(err, "invalid maximum cache size")}
+	}
+	return campaigns.CachePolicy{MaxSize: int64(maxSize), MaxAge: *f.maxAge}, nil
+}
+
+// executionCache returns the cache for the results of executing actions: the
+// local cache of the manager, in front of the remote cache at remoteURL or,
+// if it's empty, the one in the config.
+func executionCache(manager campaigns.CacheManager, remoteURL string) campaigns.ExecutionCache {
+	if remoteURL == "" {
+		remoteURL = cfg.RemoteCache.URL
+	}
+	if remoteURL == "" {
+		return manager.Results
+	}
+	return campaigns.TieredExecutionCache{
+		manager.Results,
+		campaigns.ExecutionHTTPCache{
+			URL:     remoteURL,
+			Headers: cfg.RemoteCache.Headers,
+			Action:  manager.Results.Action,
+			Client:  &http.Client{Timeout: remoteCacheTimeout},
+		},
+	}
+}
+
+const remoteCacheTimeout = 30 * time.Second
+
+// pruneCache evicts the entries of the cache that the policy doesn't allow
+// anymore. Failures are only logged, because the cache is not essential.
+func pruneCache(manager campaigns.CacheManager, policy campaigns.CachePolicy, logger *campaigns.ActionLogger) {
+	evicted, err := manager.Prune(policy, time.Now())
+	if err != nil {
+		logger.Warnf("Pruning the cache failed: %s\n", err)
+		return
+	}
+	if len(evicted) > 0 {
+		logger.Infof("Evicted %d entries from the cache.\n", len(evicted))
+	}
+}
+
+// cacheEntryFilter returns a function that matches the cache entries of the
+// given repository and action. The repository is a glob pattern for its name
+// and the action either an action file or an action ID as printed by
+// 'src actions cache ls'. Empty values match all entries.
+func cacheEntryFilter(repo, action string) (func(campaigns.CacheEntry) bool, error) {
+	if _, err := path.Match(repo, ""); err != nil {
+		return nil, &usageError{errors.Wrapf(err, "invalid repository pattern %q", repo)}
+	}
+
+	actionID := action
+	if _, err := os.Stat(action); action != "" && err == nil {
+		actionFile, err := ioutil.ReadFile(action)
+		if err != nil {
+			return nil, err
+		}
+		jsonActionFile, err := yaml.YAMLToJSONStrict(actionFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse action file")
+		}
+		jsonActionFile, err = campaigns.ResolveStepBundles(context.Background(), jsonActionFile, filepath.Dir(action))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to resolve step bundles")
+		}
+		var a campaigns.Action
+		if err := jsonxUnmarshal(string(jsonActionFile), &a); err != nil {
+			return nil, errors.Wrap(err, "invalid JSON action file")
+		}
+		if actionID, err = campaigns.ActionID(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(entry campaigns.CacheEntry) bool {
+		if repo != "" {
+			if ok, _ := path.Match(repo, entry.Repo); !ok {
+				return false
+			}
+		}
+		return actionID == "" || entry.Action == actionID
+	}, nil
+}
+
+// printCacheEntries prints the entries that were removed from the cache.
+func printCacheEntries(entries []campaigns.CacheEntry) {
+	var size int64
+	for _, entry := range entries {
+		repo := entry.Repo
+		if repo == "" {
+			repo = "-"
+		}
+		fmt.Printf("%s %s %s %s\n", entry.Kind, entry.Key, repo, humanize.Bytes(uint64(entry.Size)))
+		size += entry.Size
+	}
+	fmt.Printf("%d entries, %s\n", len(entries), humanize.Bytes(uint64(size)))
+}
+
+// filterCacheEntries returns the entries matched by the filter.
+func filterCacheEntries(entries []campaigns.CacheEntry, match func(campaigns.CacheEntry) bool) []campaigns.CacheEntry {
+	var filtered []campaigns.CacheEntry
+	for _, entry := range entries {
+		if match(entry) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
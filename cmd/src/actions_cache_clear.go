@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	usage := `
+Remove the entries of a repository or an action from the cache of 'src actions exec', both the cached results and the "cacheDirs" volumes of docker and podman steps. Use -repo and -action together to remove the entries of an action in a repository.
+
+Examples:
+
+  Remove all entries of a repository:
+
+		$ src actions cache clear -repo github.com/sourcegraph/src-cli
+
+  Remove all entries of the action in action.json:
+
+		$ src actions cache clear -action action.json
+
+  Remove all entries:
+
+		$ src actions cache clear -all
+
+`
+
+	flagSet := flag.NewFlagSet("clear", flag.ExitOnError)
+	usageFunc := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of 'src actions cache %s':\n", flagSet.Name())
+		flagSet.PrintDefaults()
+		fmt.Println(usage)
+	}
+
+	var (
+		repoFlag   = flagSet.String("repo", "", "Remove the entries of repositories whose name matches this glob pattern.")
+		actionFlag = flagSet.String("action", "", "Remove the entries of this action, given as action file or as the action ID printed by 'src actions cache ls'.")
+		allFlag    = flagSet.Bool("all", false, "Remove all entries.")
+		dryRunFlag = flagSet.Bool("dry-run", false, "Only list the entries that would be removed.")
+		cache      = newCacheFlags(flagSet)
+	)
+
+	handler := func(args []string) error {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return err
+		}
+
+		if *repoFlag == "" && *actionFlag == "" && !*allFlag {
+			return &usageError{errors.New("one of -repo, -action or -all must be given")}
+		}
+		if *allFlag && (*repoFlag != "" || *actionFlag != "") {
+			return &usageError{errors.New("-all can't be combined with -repo or -action")}
+		}
+
+		match, err := cacheEntryFilter(*repoFlag, *actionFlag)
+		if err != nil {
+			return err
+		}
+
+		manager, err := cache.manager("")
+		if err != nil {
+			return err
+		}
+		entries, err := manager.Entries()
+		if err != nil {
+			return err
+		}
+
+		entries = filterCacheEntries(entries, match)
+		printCacheEntries(entries)
+		if *dryRunFlag {
+			return nil
+		}
+		return manager.Remove(entries)
+	}
+
+	// Register the command.
+	actionsCacheCommands = append(actionsCacheCommands, &command{
+		flagSet:   flagSet,
+		handler:   handler,
+		usageFunc: usageFunc,
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+func init() {
+	usage := `
+List the entries of the cache of 'src actions exec', most recently used first. Results of executing an action in a repository have the kind "result", the "cacheDirs" volumes of docker and podman steps have the kind "volume".
+
+Examples:
+
+  List all entries:
+
+		$ src actions cache ls
+
+  List the entries of the repositories in the sourcegraph organization on GitHub:
+
+		$ src actions cache ls -repo 'github.com/sourcegraph/*'
+
+  List the entries of the action in action.json as JSON:
+
+		$ src actions cache ls -action action.json -f '{{.|json}}'
+
+`
+
+	flagSet := flag.NewFlagSet("ls", flag.ExitOnError)
+	usageFunc := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of 'src actions cache %s':\n", flagSet.Name())
+		flagSet.PrintDefaults()
+		fmt.Println(usage)
+	}
+
+	var (
+		repoFlag   = flagSet.String("repo", "", "Only list the entries of repositories whose name matches this glob pattern.")
+		actionFlag = flagSet.String("action", "", "Only list the entries of this action, given as action file or as the action ID printed by 'src actions cache ls'.")
+		formatFlag = flagSet.String("f", "{{.Kind}} {{.Key}} {{or .Repo \"-\"}} {{or .Action \"-\"}} {{humanizeBytes .Size}} {{humanizeTime .LastUsed}}", `Format for the output, using the syntax of Go package text/template. (e.g. "{{.Key}}: {{.Repo}}" or "{{.|json}}")`)
+		cache      = newCacheFlags(flagSet)
+	)
+
+	handler := func(args []string) error {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := parseTemplate(*formatFlag)
+		if err != nil {
+			return err
+		}
+
+		match, err := cacheEntryFilter(*repoFlag, *actionFlag)
+		if err != nil {
+			return err
+		}
+
+		manager, err := cache.manager("")
+		if err != nil {
+			return err
+		}
+		entries, err := manager.Entries()
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range filterCacheEntries(entries, match) {
+			if err := execTemplate(tmpl, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Register the command.
+	actionsCacheCommands = append(actionsCacheCommands, &command{
+		flagSet:   flagSet,
+		handler:   handler,
+		usageFunc: usageFunc,
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+func init() {
+	usage := `
+Evict the entries of the cache of 'src actions exec' that weren't used for longer than -max-age and, if the cache is still larger than -max-size, the least recently used entries until it fits. 'src actions exec' and 'src actions resume' do this automatically after every run, with the limits given by -cache-max-age and -cache-max-size.
+
+Don't prune the cache while an action is executed, because the "cacheDirs" volumes of the running steps might be evicted.
+
+Examples:
+
+  Evict the entries that weren't used in the last week:
+
+		$ src actions cache prune -max-age 168h
+
+  Shrink the cache to 1GB:
+
+		$ src actions cache prune -max-size 1GB
+
+`
+
+	flagSet := flag.NewFlagSet("prune", flag.ExitOnError)
+	usageFunc := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of 'src actions cache %s':\n", flagSet.Name())
+		flagSet.PrintDefaults()
+		fmt.Println(usage)
+	}
+
+	var (
+		dryRunFlag = flagSet.Bool("dry-run", false, "Only list the entries that would be evicted.")
+		cache      = newCacheFlags(flagSet)
+		policy     = newCachePolicyFlags(flagSet, "")
+	)
+
+	handler := func(args []string) error {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return err
+		}
+
+		p, err := policy.policy()
+		if err != nil {
+			return err
+		}
+		manager, err := cache.manager("")
+		if err != nil {
+			return err
+		}
+
+		if *dryRunFlag {
+			entries, err := manager.Entries()
+			if err != nil {
+				return err
+			}
+			printCacheEntries(p.Evict(entries, time.Now()))
+			return nil
+		}
+
+		evicted, err := manager.Prune(p, time.Now())
+		printCacheEntries(evicted)
+		return err
+	}
+
+	// Register the command.
+	actionsCacheCommands = append(actionsCacheCommands, &command{
+		flagSet:   flagSet,
+		handler:   handler,
+		usageFunc: usageFunc,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
+
+func init() {
+	usage := `
+Serve a cache of the results of 'src actions exec' over HTTP, so that a team can share them: an action is then executed only once in a repository at a revision, and everyone else gets the cached patch.
+
+Entries are stored in -dir like in the local cache of 'src actions exec', so 'src actions cache ls', 'prune' and 'clear' work with '-cache <dir>' on the server, too. Entries that weren't used for longer than -max-age and the least recently used entries, if the cache exceeds -max-size, are evicted every hour.
+
+Clients use the cache with 'src actions exec -remote-cache <url>', or by setting it in the "remoteCache" of their src config file together with the headers sent with every request:
+
+	{
+	  "remoteCache": {
+	    "url": "http://actions-cache.example.com:3435",
+	    "headers": {"Authorization": "token <token>"}
+	  }
+	}
+
+Examples:
+
+  Serve the cache on port 3435, only to clients that send the token in $CACHE_TOKEN:
+
+		$ src actions cache serve -addr :3435 -token "$CACHE_TOKEN"
+
+`
+
+	flagSet := flag.NewFlagSet("serve", flag.ExitOnError)
+	usageFunc := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of 'src actions cache %s':\n", flagSet.Name())
+		flagSet.PrintDefaults()
+		fmt.Println(usage)
+	}
+
+	dir, displayDir := userCacheSubdir("action-exec-shared")
+
+	var (
+		addrFlag  = flagSet.String("addr", ":3435", "Address on which to serve.")
+		dirFlag   = flagSet.String("dir", displayDir, "Directory in which the cached results are stored.")
+		tokenFlag = flagSet.String("token", "", `If given, clients must send it in an "Authorization: token <token>" header.`)
+		policy    = newCachePolicyFlags(flagSet, "")
+	)
+
+	handler := func(args []string) error {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return err
+		}
+
+		if *dirFlag == displayDir {
+			*dirFlag = dir
+		}
+		if *dirFlag == "" {
+			return errors.New("dir is not a valid path")
+		}
+		p, err := policy.policy()
+		if err != nil {
+			return err
+		}
+
+		logger := log.New(os.Stderr, "actions-cache: ", log.LstdFlags)
+
+		// The server has no cacheDirs volumes, so they are looked up in a
+		// directory that is never created.
+		manager := campaigns.CacheManager{
+			Results: campaigns.ExecutionDiskCache{Dir: *dirFlag},
+			Volumes: campaigns.CacheDirVolumes{Dir: filepath.Join(*dirFlag, "volumes")},
+		}
+		go func() {
+			for {
+				evicted, err := manager.Prune(p, time.Now())
+				if err != nil {
+					logger.Printf("pruning failed: %s", err)
+				} else if len(evicted) > 0 {
+					logger.Printf("evicted %d entries", len(evicted))
+				}
+				time.Sleep(time.Hour)
+			}
+		}()
+
+		var h http.Handler = &campaigns.ExecutionCacheHandler{Cache: manager.Results, Token: *tokenFlag}
+		if *verbose {
+			next := h
+			h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				logger.Printf("%s %s", r.Method, r.URL.Path)
+				next.ServeHTTP(w, r)
+			})
+		}
+
+		ln, err := net.Listen("tcp", *addrFlag)
+		if err != nil {
+			return err
+		}
+		logger.Printf("serving %s on http://%s", *dirFlag, ln.Addr())
+		return (&http.Server{Handler: h}).Serve(ln)
+	}
+
+	// Register the command.
+	actionsCacheCommands = append(actionsCacheCommands, &command{
+		flagSet:   flagSet,
+		handler:   handler,
+		usageFunc: usageFunc,
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
+
+func init() {
+	usage := `
+Print the number and the size of the entries in the cache of 'src actions exec', for the cached results and the "cacheDirs" volumes of docker and podman steps.
+
+Examples:
+
+  Print the statistics of the cache:
+
+		$ src actions cache stats
+
+`
+
+	flagSet := flag.NewFlagSet("stats", flag.ExitOnError)
+	usageFunc := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of 'src actions cache %s':\n", flagSet.Name())
+		flagSet.PrintDefaults()
+		fmt.Println(usage)
+	}
+
+	var (
+		cache = newCacheFlags(flagSet)
+	)
+
+	handler := func(args []string) error {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return err
+		}
+
+		manager, err := cache.manager("")
+		if err != nil {
+			return err
+		}
+		entries, err := manager.Entries()
+		if err != nil {
+			return err
+		}
+
+		var (
+			counts = map[string]int{}
+			sizes  = map[string]int64{}
+			total  int64
+		)
+		for _, entry := range entries {
+			counts[entry.Kind]++
+			sizes[entry.Kind] += entry.Size
+			total += entry.Size
+		}
+
+		fmt.Printf("Results:  %d entries, %s (%s)\n", counts[campaigns.CacheEntryResult], humanize.Bytes(uint64(sizes[campaigns.CacheEntryResult])), *cache.results)
+		fmt.Printf("Volumes:  %d entries, %s (%s)\n", counts[campaigns.CacheEntryVolume], humanize.Bytes(uint64(sizes[campaigns.CacheEntryVolume])), *cache.volumes)
+		fmt.Printf("Total:    %d entries, %s\n", len(entries), humanize.Bytes(uint64(total)))
+		if len(entries) > 0 {
+			fmt.Printf("Last used %s, least recently used %s.\n", humanize.Time(entries[0].LastUsed), humanize.Time(entries[len(entries)-1].LastUsed))
+		}
+		return nil
+	}
+
+	// Register the command.
+	actionsCacheCommands = append(actionsCacheCommands, &command{
+		flagSet:   flagSet,
+		handler:   handler,
+		usageFunc: usageFunc,
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
+
+func init() {
+	usage := `
+Convert the patches produced by 'src actions exec' from one format to another, e.g. to review them with standard git tools or to create a patch set from edited patches.
+
+Examples:
+
+  Convert patches.json to an mbox, which can be applied to a repository with 'git am -p0':
+
+		$ src actions convert-patches -i patches.json -output-format mbox -o patches.mbox
+
+  Write a <repository>/<name>.patch file for every repository to the directory 'patches':
+
+		$ src actions convert-patches -i patches.json -output-format dir -o patches
+
+  Convert an edited directory of patch files back to JSON:
+
+		$ src actions convert-patches -input-format dir -i patches -o patches.json
+
+`
+
+	flagSet := flag.NewFlagSet("convert-patches", flag.ExitOnError)
+	usageFunc := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of 'src actions %s':\n", flagSet.Name())
+		flagSet.PrintDefaults()
+		fmt.Println(usage)
+	}
+
+	var (
+		inputFlag        = flagSet.String("i", "-", `The file or, for the "dir" format, the directory the patches are read from. If not given or '-' standard input is used.`)
+		inputFormatFlag  = flagSet.String("input-format", campaigns.PatchFormatJSON, patchFormatUsage("The format of the patches that are read"))
+		outputFlag       = flagSet.String("o", "-", `The file or, for the "dir" format, the directory the patches are written to. If not given or '-' standard output is used.`)
+		outputFormatFlag = flagSet.String("output-format", campaigns.PatchFormatJSON, patchFormatUsage("The format the patches are written in"))
+	)
+
+	handler := func(args []string) error {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return err
+		}
+
+		if err := validatePatchFormat(*outputFormatFlag); err != nil {
+			return err
+		}
+		patches, err := readPatches(*inputFlag, *inputFormatFlag)
+		if err != nil {
+			return err
+		}
+
+		if *outputFormatFlag == campaigns.PatchFormatDir {
+			if *outputFlag == "-" {
+				return &usageError{errors.New("patches in the dir format can't be written to standard output, use -o")}
+			}
+			return campaigns.WritePatchDir(*outputFlag, patches)
+		}
+
+		out := os.Stdout
+		if *outputFlag != "-" {
+			f, err := os.Create(*outputFlag)
+			if err != nil {
+				return errors.Wrap(err, "creating output file")
+			}
+			defer f.Close()
+			out = f
+		}
+		return campaigns.WritePatches(out, *outputFormatFlag, patches)
+	}
+
+	// Register the command.
+	actionsCommands = append(actionsCommands, &command{
+		flagSet:   flagSet,
+		handler:   handler,
+		usageFunc: usageFunc,
+	})
+}
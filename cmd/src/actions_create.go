@@ -1,24 +1,32 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-)
-
-const actionDefinitionTemplate = `scopeQuery: ""
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
-steps:
-  - type: command
-    args:
-    - echo
-    - Hello world
-`
+	"github.com/ghodss/yaml"
+	"github.com/mattn/go-isatty"
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
 
 func init() {
+	templatesDir, displayTemplatesDir := userConfigSubdir("action-templates")
+
 	usage := `
-Create an empty action definition in action.yml (if no -o flag is given). This command is meant to help with creating action definitions to be used with 'src actions exec'.
+Create an action definition in action.yml (if no -o flag is given) from a template. This command is meant to help with creating action definitions to be used with 'src actions exec'.
+
+The built-in templates are go-mod-tidy, gofmt, hello-world, license-header, npm-upgrade, prettier and sed-replace. Templates in -templates-dir replace built-in templates with the same name. They are YAML or JSON files with a "description", "params", a list of parameters with a "name", "description" and optional "default", and the "definition" of the action, in which ${{ .Params.name }} is replaced by the value of the parameter.
+
+The values of the parameters are given with -param or, if standard input is a terminal, asked for. The created action definition is validated before it's written.
 
 Examples:
 
@@ -29,6 +37,14 @@ Examples:
   Create a new action definition in ~/Documents/my-action.yml:
 
 		$ src actions create -o ~/Documents/my-action.yml
+
+  List the available templates and their parameters:
+
+		$ src actions create -list-templates
+
+  Create an action that upgrades lodash in all npm packages:
+
+		$ src actions create -template npm-upgrade -param package=lodash -o upgrade-lodash.yml
 `
 
 	flagSet := flag.NewFlagSet("create", flag.ExitOnError)
@@ -39,8 +55,13 @@ Examples:
 	}
 
 	var (
-		fileFlag = flagSet.String("o", "action.yml", "The destination file name. Default value is 'action.yml'")
+		fileFlag          = flagSet.String("o", "action.yml", "The destination file name. Default value is 'action.yml'")
+		templateFlag      = flagSet.String("template", campaigns.DefaultActionTemplate, "The template the action definition is created from.")
+		listTemplatesFlag = flagSet.Bool("list-templates", false, "List the available templates and their parameters.")
+		templatesDirFlag  = flagSet.String("templates-dir", displayTemplatesDir, "Directory containing additional action templates.")
+		paramFlags        = make(templateParamsFlag)
 	)
+	flagSet.Var(paramFlags, "param", "The value of a parameter of the template, as name=value. Can be given multiple times.")
 
 	handler := func(args []string) error {
 		err := flagSet.Parse(args)
@@ -48,11 +69,57 @@ Examples:
 			return err
 		}
 
+		if *templatesDirFlag == displayTemplatesDir {
+			*templatesDirFlag = templatesDir
+		}
+		templates, err := campaigns.LoadActionTemplates(*templatesDirFlag)
+		if err != nil {
+			return err
+		}
+
+		if *listTemplatesFlag {
+			return listActionTemplates(templates)
+		}
+
+		var template *campaigns.ActionTemplate
+		for i := range templates {
+			if templates[i].Name == *templateFlag {
+				template = &templates[i]
+			}
+		}
+		if template == nil {
+			return &usageError{errors.Errorf("unknown template %q, use -list-templates to list the available templates", *templateFlag)}
+		}
+
 		if _, err := os.Stat(*fileFlag); !os.IsNotExist(err) {
 			return fmt.Errorf("file %q already exists", *fileFlag)
 		}
 
-		return ioutil.WriteFile(*fileFlag, []byte(actionDefinitionTemplate), 0644)
+		if isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+			if err := askForTemplateParams(template, paramFlags); err != nil {
+				return err
+			}
+		}
+		def, err := template.Render(paramFlags)
+		if err != nil {
+			return err
+		}
+
+		// Validate the created action definition like 'src actions exec'
+		// does, so that broken parameter values are reported now.
+		jsonDef, err := yaml.YAMLToJSONStrict(def)
+		if err != nil {
+			return errors.Wrapf(err, "template %q created an invalid action definition", template.Name)
+		}
+		jsonDef, err = campaigns.ResolveStepBundles(context.Background(), jsonDef, filepath.Dir(*fileFlag))
+		if err != nil {
+			return errors.Wrap(err, "unable to resolve step bundles")
+		}
+		if err := campaigns.ValidateActionDefinition(jsonDef); err != nil {
+			return errors.Wrapf(err, "template %q created an invalid action definition", template.Name)
+		}
+
+		return ioutil.WriteFile(*fileFlag, def, 0644)
 	}
 
 	// Register the command.
@@ -62,3 +129,74 @@ Examples:
 		usageFunc: usageFunc,
 	})
 }
+
+// templateParamsFlag holds the values of the -param flags.
+type templateParamsFlag map[string]string
+
+func (f templateParamsFlag) String() string {
+	params := make([]string, 0, len(f))
+	for name, value := range f {
+		params = append(params, name+"="+value)
+	}
+	sort.Strings(params)
+	return strings.Join(params, ",")
+}
+
+func (f templateParamsFlag) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return errors.Errorf("invalid parameter %q, must be name=value", s)
+	}
+	f[parts[0]] = parts[1]
+	return nil
+}
+
+// askForTemplateParams asks for the values of the parameters of the template
+// that aren't set in values. Empty answers keep the default.
+func askForTemplateParams(template *campaigns.ActionTemplate, values map[string]string) error {
+	reader := bufio.NewReader(os.Stdin)
+	for _, p := range template.Params {
+		if _, ok := values[p.Name]; ok {
+			continue
+		}
+
+		prompt := p.Name
+		if p.Description != "" {
+			prompt = fmt.Sprintf("%s (%s)", p.Description, p.Name)
+		}
+		if p.Default != nil {
+			prompt += fmt.Sprintf(" [%s]", *p.Default)
+		}
+		fmt.Fprintf(os.Stderr, "%s: ", prompt)
+
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if answer = strings.TrimSpace(answer); answer != "" {
+			values[p.Name] = answer
+		}
+	}
+	return nil
+}
+
+// listActionTemplates prints the names, descriptions and parameters of the
+// templates.
+func listActionTemplates(templates []campaigns.ActionTemplate) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, t := range templates {
+		description := t.Description
+		if t.Path != "" {
+			description += fmt.Sprintf(" (%s)", t.Path)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", t.Name, description)
+		for _, p := range t.Params {
+			value := "required"
+			if p.Default != nil {
+				value = fmt.Sprintf("default: %q", *p.Default)
+			}
+			fmt.Fprintf(w, "  -param %s=...\t%s (%s)\n", p.Name, p.Description, value)
+		}
+	}
+	return w.Flush()
+}
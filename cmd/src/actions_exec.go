@@ -3,17 +3,15 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"regexp"
-	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -25,7 +23,10 @@ import (
 	"github.com/sourcegraph/src-cli/internal/campaigns"
 )
 
-const defaultTimeout = 60 * time.Minute
+const (
+	defaultTimeout  = 60 * time.Minute
+	maxRetryBackoff = 5 * time.Minute
+)
 
 func init() {
 	usage := `
@@ -53,19 +54,35 @@ Examples:
 
 	$ src actions exec -f ~/run-gofmt.json -o patches.json 
 
+  Execute an action and write the patches it produced as a <repository>/<name>.patch file for every repository to the directory 'patches', to review them with git tools:
+
+	$ src actions exec -f ~/run-gofmt.json -output-format dir -o patches
+
+  Execute an action and write a JUnit XML report of the execution in every repository to 'report.xml':
+
+	$ src actions exec -f ~/run-gofmt.json -report report.xml -report-format junit
+
+  Execute an action and write every step, its output and the result in every repository as JSON lines to 'events.jsonl':
+
+	$ src actions exec -f ~/run-gofmt.json -events events.jsonl
+
   Read and execute an action definition from standard input:
 
 	$ cat ~/my-action.json | src actions exec -f -
 
+  Re-run only the repositories that failed or didn't finish in an earlier execution:
+
+	$ src actions resume 20200715-103012
+
 
 Format of the action JSON files:
 
 	An action JSON needs to specify:
 
-	- "scopeQuery" - a Sourcegraph search query to generate a list of repositories over which to run the action. Use 'src actions scope-query' to see which repositories are matched by the query
+	- "scopeQuery" - a Sourcegraph search query to generate a list of repositories over which to run the action. Use 'src actions scope-query' to see which repositories are matched by the query. Alternatively, "scope" can be given (see below)
 	- "steps" - a list of action steps to execute in each repository
 
-	A single "step" can either be a of type "command", which means the step is executed on the machine on which 'src actions exec' is executed, or it can be of type "docker" which then (optionally builds) and runs a container in which the repository is mounted.
+	A single "step" can either be a of type "command", which means the step is executed on the machine on which 'src actions exec' is executed, or it can be of type "docker" which then (optionally builds) and runs a container in which the repository is mounted. Steps of type "podman" run a container with podman instead, e.g. on CI hosts without a Docker daemon, and steps of type "script" execute an inline shell script on the machine on which 'src actions exec' is executed.
 
 	This action has a single step that produces a README.md file in repositories whose name starts with "go-" and that doesn't have a README.md file yet:
 
@@ -92,6 +109,189 @@ Format of the action JSON files:
 		  ]
 		}
 
+	By default, a ZIP archive of every repository is downloaded from Sourcegraph for every execution. With -workspace mirror, a bare git mirror of every repository is kept in -workspace-mirrors instead, which is fetched incrementally from the code host given with -workspace-remote, and a git worktree is created from it for every execution. This is faster for large repositories and produces the same patches:
+
+		$ src actions exec -f action.json -workspace mirror -workspace-remote 'git@${{ .Repository.Name }}.git'
+
+	The results of executing an action in a repository are cached in -cache, and the "cacheDirs" of container steps are kept in -cache-volumes. After every run, entries that weren't used for longer than -cache-max-age are evicted and, if the cache is larger than -cache-max-size, the least recently used entries. Use 'src actions cache' to list, prune and clear the cache:
+
+		$ src actions cache clear -action action.json
+
+	To share the results with a team, run 'src actions cache serve' and use it with -remote-cache:
+
+		$ src actions exec -f action.json -remote-cache http://actions-cache.example.com:3435
+
+	To review what an action would do before executing it, e.g. in the pull request that changes the action, use -plan. It prints the repositories the action would be executed in, which of them are cached or skipped, the images of the steps and the cost estimated from previous runs of the action, without executing any step. Use -plan-format json for a machine readable plan:
+
+		$ src actions exec -f action.json -plan
+
+	With many parallel jobs, -dashboard shows a full-screen view of the repositories the action is being executed in, with their current step, elapsed time and last log line, instead of the progress bar. Use the arrow keys to select a repository, enter to tail its log and c, twice, to cancel its execution without canceling the others. Canceled repositories are executed again by 'src actions resume':
+
+		$ src actions exec -f action.json -j 16 -dashboard
+
+	To develop an action without a Sourcegraph instance, execute it in local git repositories with -local, which is either a repository or a directory containing repositories. The action is executed at the commit that is checked out in each repository and the patches are written as usual:
+
+		$ src actions exec -f action.json -local ~/work/my-service
+
+	The containers of "docker" and "podman" steps can be limited with "cpus", "memory", "network" ("none", "bridge" or "host") and "user". The -container-cpus, -container-memory, -container-network and -container-user flags set defaults for all container steps that don't set these themselves. 'src actions resume' uses the values of the original run. This step runs an untrusted codemod without network access:
+
+		{
+		  "type": "docker",
+		  "image": "example/codemod",
+		  "cpus": 2,
+		  "memory": "2g",
+		  "network": "none",
+		  "user": "1000:1000"
+		}
+
+	Steps can set a "timeout", e.g. "5m", in addition to the -timeout flag, which limits the duration of all steps in a repository. Containers of steps that time out are removed and the repository is reported as timed out in the log and in the report written with -report:
+
+		{
+		  "type": "command",
+		  "args": ["npm", "install"],
+		  "timeout": "10m"
+		}
+
+	The "changeset" of an action describes the changesets created from its patches. Its "title", "body", "commitMessage" and "author" can contain templates like the args of steps, which are expanded for every repository and written to the patches, from which 'src campaigns patchset create-from-patches' passes them on to Sourcegraph:
+
+		{
+		  "scopeQuery": "repohasfile:go.mod",
+		  "steps": [ ... ],
+		  "changeset": {
+		    "title": "Run gofmt in ${{ .Repository.Name }}",
+		    "body": "This formats all Go files with gofmt.",
+		    "author": {"name": "Campaigns Bot", "email": "campaigns@example.com"}
+		  }
+		}
+
+	The "patchPolicy" of an action restricts the patches it produces. Changes to files that don't match "include" or match "exclude" are removed from the patch. The execution in a repository fails with an error if its patch is larger than "maxSize" or changes binary files while "allowBinary" is false:
+
+		{
+		  "scopeQuery": "repohasfile:go.mod",
+		  "steps": [ ... ],
+		  "patchPolicy": {
+		    "exclude": ["vendor/**"],
+		    "maxSize": "1MB",
+		    "allowBinary": false
+		  }
+		}
+
+	Steps that are shared between actions can be put in a step bundle, a YAML or JSON file with "params" and "steps", which a step uses with "uses" instead of "type". The step is replaced by the steps of the bundle, in which ${{ .Params.name }} is replaced by the value of the parameter given in "with" or its "default". Relative paths are relative to the file that contains the step, and bundles fetched from http(s) URLs must be pinned with the "sha256:" "checksum" of the file. Changing a bundle changes the cache key of the actions using it:
+
+		{
+		  "scopeQuery": "repohasfile:package.json",
+		  "steps": [
+		    {
+		      "uses": "https://example.com/bundles/v1/prettier.yml",
+		      "checksum": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		      "with": {"pattern": "src/**/*.ts"}
+		    }
+		  ]
+		}
+
+	The bundle prettier.yml declares the parameter and uses it in its steps:
+
+		params:
+		  pattern:
+		    description: The files to format.
+		    default: "**/*.js"
+		steps:
+		  - type: docker
+		    image: node:14
+		    args: ["npx", "prettier", "--write", "${{ .Params.pattern }}"]
+
+	This action runs a multi-line script, which gets "args" as its arguments:
+
+		{
+		  "scopeQuery": "repohasfile:package.json",
+		  "steps": [
+		    {
+		      "type": "script",
+		      "script": "set -e\nnpm install --package-lock-only\nnpm audit fix --package-lock-only --audit-level=\"$1\"",
+		      "args": ["high"]
+		    }
+		  ]
+		}
+
+	Environment variables can be set for the whole action and for single steps with "env". Values are either strings, or refer to an environment variable of 'src' with {"fromEnv": "NAME"} or to a secret in the file given with -secrets with {"fromSecret": "NAME"}. Secret values are redacted from the output and log files:
+
+		{
+		  "scopeQuery": "repohasfile:package.json",
+		  "env": {"NPM_TOKEN": {"fromSecret": "npmToken"}},
+		  "steps": [
+		    {
+		      "type": "docker",
+		      "image": "node:14",
+		      "args": ["sh", "-c", "yarn upgrade my-private-package"],
+		      "env": {"NPM_CONFIG_REGISTRY": "https://npm.example.com"}
+		    }
+		  ]
+		}
+
+	Steps can be executed conditionally with "if", and can capture their standard output with "output", which subsequent steps get in an environment variable of the same name. This action only runs "go mod tidy" in repositories that have a go.mod file, and only writes TIDY.md if "go mod tidy" changed something:
+
+		{
+		  "scopeQuery": "repohasfile:go.mod",
+		  "steps": [
+		    {
+		      "type": "command",
+		      "args": ["sh", "-c", "go mod tidy && go version"],
+		      "if": {"fileExists": "go.mod"},
+		      "output": "GO_VERSION"
+		    },
+		    {
+		      "type": "command",
+		      "args": ["sh", "-c", "echo \"Tidied with $GO_VERSION\" > TIDY.md"],
+		      "if": {"previousStepChangedFiles": true}
+		    }
+		  ]
+		}
+
+	The "args" and "image" of steps can contain templates, which are expanded for every repository. Templates are enclosed in ${{ and }} and can use the fields .Repository.ID, .Repository.Name, .Repository.Rev, .Repository.BaseRef and .Repository.FileMatches, which holds the paths of the files that matched the scope query, and the functions join, replace and split. This action adds the repository name to CHANGELOG.md in all repositories that have one:
+
+		{
+		  "scopeQuery": "file:^CHANGELOG.md$",
+		  "steps": [
+		    {
+		      "type": "command",
+		      "args": ["sh", "-c", "echo '## ${{ .Repository.Name }}' >> ${{ join .Repository.FileMatches \" \" }}"]
+		    }
+		  ]
+		}
+
+	The files and lines that matched the scope query are written to a file outside of the repository, whose path is in the SRC_MATCHES_FILE environment variable of every step (/src-matches.json in containers). Steps that read it must refer to $SRC_MATCHES_FILE in their "args" or "script", because the matches are only part of the cache key then. It's a JSON array of objects with "path" and "lineMatches" properties, where every line match has a "lineNumber" and a "preview". In templates, .Repository.Matches holds the same list. This action runs gofmt only on the Go files that matched:
+
+		{
+		  "scopeQuery": "lang:go fmt.Sprintf",
+		  "steps": [
+		    {
+		      "type": "command",
+		      "args": ["sh", "-c", "gofmt -w ${{ join .Repository.FileMatches \" \" }}"]
+		    }
+		  ]
+		}
+
+	Instead of "scopeQuery", an action can define its "scope" with several search "queries", whose results are combined as their "union" (the default) or "intersection" given in "combine", and explicitly listed "repositories", which are always in scope. Repositories are listed as "name", "name@branch" or "name@<full commit SHA>" (repositories listed with an abbreviated SHA are skipped), or as an object with a "name", "branch" and "rev", to execute the action on a branch other than the default branch or at a specific revision, also if the repository is matched by a query. More repositories can be listed in a "repositoriesFile", one per line:
+
+		{
+		  "scope": {
+		    "queries": ["repohasfile:go.mod", "repohasfile:Dockerfile"],
+		    "combine": "intersection",
+		    "repositories": [
+		      "github.com/sourcegraph/src-cli@release-3.18",
+		      {"name": "github.com/sourcegraph/go-diff", "rev": "2a6c7e7a0b6c9e0ef1a27c1d0b5d6b2e8d0e1f3a"}
+		    ],
+		    "repositoriesFile": "more-repos.txt"
+		  },
+		  "steps": [
+		    {
+		      "type": "docker",
+		      "image": "golang:1.14-alpine",
+		      "args": ["sh", "-c", "cd /work && go mod tidy"]
+		    }
+		  ]
+		}
+
 `
 
 	flagSet := flag.NewFlagSet("exec", flag.ExitOnError)
@@ -101,27 +301,25 @@ Format of the action JSON files:
 		fmt.Println(usage)
 	}
 
-	cacheDir, _ := campaigns.UserCacheDir()
-	if cacheDir != "" {
-		cacheDir = filepath.Join(cacheDir, "action-exec")
-	}
-
-	displayUserCacheDir := strings.Replace(cacheDir, os.Getenv("HOME"), "$HOME", 1)
+	execFlags := newExecutionFlags(flagSet, "Directory for the run journals used by 'src actions resume'.")
 
 	var (
-		fileFlag        = flagSet.String("f", "-", "The action file. If not given or '-' standard input is used. (Required)")
-		outputFlag      = flagSet.String("o", "patches.json", "The output file. Will be used as the destination for patches unless the command is being piped in which case patches are piped to stdout")
-		parallelismFlag = flagSet.Int("j", runtime.GOMAXPROCS(0), "The number of parallel jobs.")
-
-		cacheDirFlag   = flagSet.String("cache", displayUserCacheDir, "Directory for caching results.")
+		fileFlag       = flagSet.String("f", "-", "The action file. If not given or '-' standard input is used. (Required)")
 		clearCacheFlag = flagSet.Bool("clear-cache", false, "Remove possibly cached results for an action before executing it.")
 
-		keepLogsFlag = flagSet.Bool("keep-logs", false, "Do not remove execution log files when done.")
-		timeoutFlag  = flagSet.Duration("timeout", defaultTimeout, "The maximum duration a single action run can take.")
+		containerCPUsFlag    = flagSet.Float64("container-cpus", 0, "The default number of CPUs the container of a docker or podman step can use. Steps can override it with \"cpus\".")
+		containerMemoryFlag  = flagSet.String("container-memory", "", `The default maximum amount of memory the container of a docker or podman step can use, e.g. "2g". Steps can override it with "memory".`)
+		containerNetworkFlag = flagSet.String("container-network", "", `The default network the container of a docker or podman step is connected to: "none", "bridge" or "host". Steps can override it with "network".`)
+		containerUserFlag    = flagSet.String("container-user", "", `The default user the container of a docker or podman step runs as, e.g. "1000:1000". Steps can override it with "user".`)
 
 		createPatchSetFlag      = flagSet.Bool("create-patchset", false, "Create a patch set from the produced set of patches. When the execution of the action fails in a single repository a prompt will ask to confirm or reject the patch set creation.")
 		forceCreatePatchSetFlag = flagSet.Bool("force-create-patchset", false, "Force creation of patch set from the produced set of patches, without asking for confirmation even when the execution of the action failed for a subset of repositories.")
 
+		planFlag       = flagSet.Bool("plan", false, "Print which repositories the action would be executed in, which are cached and the estimated cost, without executing any step.")
+		planFormatFlag = flagSet.String("plan-format", "text", `The format of the plan printed with -plan: "text" or "json".`)
+
+		localFlag = flagSet.String("local", "", "A local git repository, or a directory containing git repositories, to execute the action in instead of the repositories matched by the scopeQuery. The action is executed at the commit checked out in each repository, without a Sourcegraph instance.")
+
 		includeUnsupportedFlag = flagSet.Bool("include-unsupported", false, "When specified, also repos from unsupported codehosts are processed. Those can be created once the integration is done.")
 
 		apiFlags = api.NewFlags(flagSet)
@@ -133,18 +331,37 @@ Format of the action JSON files:
 			return err
 		}
 
-		if !isGitAvailable() {
-			return errors.New("Could not find git in $PATH. 'src actions exec' requires git to be available.")
+		if err := execFlags.validate(); err != nil {
+			return err
+		}
+		workspaces, err := execFlags.workspaceCreator()
+		if err != nil {
+			return err
+		}
+
+		if *planFormatFlag != "text" && *planFormatFlag != "json" {
+			return &usageError{fmt.Errorf("unknown plan format %q", *planFormatFlag)}
+		}
+		if *planFlag && (*createPatchSetFlag || *forceCreatePatchSetFlag) {
+			return &usageError{errors.New("-plan can't be combined with -create-patchset")}
+		}
+
+		if *localFlag != "" && (*createPatchSetFlag || *forceCreatePatchSetFlag) {
+			return &usageError{errors.New("patch sets can't be created from patches of local repositories")}
 		}
 
-		if *cacheDirFlag == displayUserCacheDir {
-			*cacheDirFlag = cacheDir
+		containerDefaults := campaigns.ContainerOpts{
+			CPUs:    *containerCPUsFlag,
+			Memory:  *containerMemoryFlag,
+			Network: *containerNetworkFlag,
+			User:    *containerUserFlag,
+		}
+		if err := containerDefaults.Validate(); err != nil {
+			return &usageError{err}
 		}
 
-		if *cacheDirFlag == "" {
-			// This can only happen if `userCacheDir()` fails or the user
-			// specifies a blank string.
-			return errors.New("cache is not a valid path")
+		if !isGitAvailable() {
+			return errors.New("Could not find git in $PATH. 'src actions exec' requires git to be available.")
 		}
 
 		// Read action file content.
@@ -158,33 +375,29 @@ Format of the action JSON files:
 			return err
 		}
 
-		var outputWriter io.Writer
-		if !*createPatchSetFlag && !*forceCreatePatchSetFlag {
-			// If stdout is a pipe, write to pipe, otherwise
-			// write to output file
-			fi, err := os.Stdout.Stat()
+		var outputWriter *os.File
+		if !*planFlag && !*createPatchSetFlag && !*forceCreatePatchSetFlag {
+			outputWriter, err = execFlags.outputWriter(flagSet)
 			if err != nil {
 				return err
 			}
-			isPipe := fi.Mode()&os.ModeCharDevice == 0
-
-			if isPipe {
-				outputWriter = os.Stdout
-			} else {
-				f, err := os.Create(*outputFlag)
-				if err != nil {
-					return errors.Wrap(err, "creating output file")
-				}
-				defer f.Close()
-				outputWriter = f
+			if outputWriter != nil && outputWriter != os.Stdout {
+				defer outputWriter.Close()
 			}
 		}
 
+		ctx, cancel := interruptibleContext()
+		defer cancel()
+
 		// Convert action file to JSON.
 		jsonActionFile, err := yaml.YAMLToJSONStrict(actionFile)
 		if err != nil {
 			return errors.Wrap(err, "unable to parse action file")
 		}
+		jsonActionFile, err = campaigns.ResolveStepBundles(ctx, jsonActionFile, actionFileDir(*fileFlag))
+		if err != nil {
+			return errors.Wrap(err, "unable to resolve step bundles")
+		}
 
 		err = campaigns.ValidateActionDefinition(jsonActionFile)
 		if err != nil {
@@ -196,97 +409,121 @@ Format of the action JSON files:
 			return errors.Wrap(err, "invalid JSON action file")
 		}
 
-		ctx, cancel := context.WithCancel(context.Background())
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		defer func() {
-			signal.Stop(c)
-			cancel()
-		}()
-		go func() {
-			select {
-			case <-c:
-				cancel()
-			case <-ctx.Done():
-			}
-			<-c // If user hits Ctrl-C second time, we do a hard exit
-			os.Exit(2)
-		}()
+		// The ID is computed before the action is modified below, so that
+		// 'src actions cache clear -action' finds its entries.
+		actionID, err := campaigns.ActionID(action)
+		if err != nil {
+			return err
+		}
+		cacheManager, err := execFlags.cache.manager(actionID)
+		if err != nil {
+			return err
+		}
 
 		client := cfg.apiClient(apiFlags, flagSet.Output())
-		logger := campaigns.NewActionLogger(*verbose, *keepLogsFlag)
+		logger, closeEvents, err := execFlags.logger()
+		if err != nil {
+			return err
+		}
+		defer closeEvents()
+
+		campaigns.ApplyContainerDefaults(action, containerDefaults)
 
 		// Fetch Docker images etc.
-		err = campaigns.PrepareAction(ctx, action, logger)
+		err = campaigns.PrepareAction(ctx, action, execFlags.retryPolicy(), logger)
 		if err != nil {
 			return errors.Wrap(err, "Failed to prepare action")
 		}
 
-		opts := campaigns.ExecutorOpts{
-			Endpoint:          cfg.Endpoint,
-			AccessToken:       cfg.AccessToken,
-			AdditionalHeaders: cfg.AdditionalHeaders,
-			Timeout:           *timeoutFlag,
-			KeepLogs:          *keepLogsFlag,
-			ClearCache:        *clearCacheFlag,
-			Cache:             campaigns.ExecutionDiskCache{Dir: *cacheDirFlag},
+		opts, err := execFlags.executorOpts(action, cacheManager, workspaces, logger)
+		if err != nil {
+			return err
+		}
+		opts.ClearCache = *clearCacheFlag
+
+		var (
+			repos                []campaigns.ActionRepo
+			skipped, unsupported []string
+		)
+		if *localFlag != "" {
+			repos, err = campaigns.LocalRepos(ctx, *localFlag)
+			if err != nil {
+				return err
+			}
+			logger.Infof("Executing action in %d local repositories in %s.\n\n", len(repos), *localFlag)
+		} else {
+			// Query repos over which to run action
+			if action.ScopeQuery != "" {
+				logger.Infof("Querying %s for repositories matching '%s'...\n", cfg.Endpoint, action.ScopeQuery)
+			} else {
+				logger.Infof("Querying %s for the repositories in the scope of the action...\n", cfg.Endpoint)
+			}
+			repos, skipped, unsupported, err = actionRepos(ctx, client, &action, actionFileDir(*fileFlag), *includeUnsupportedFlag, logger)
+			if err != nil {
+				return err
+			}
+			logger.Infof("Use 'src actions scope-query' for help with scoping.\n\n")
+		}
+
+		if *planFlag {
+			executor := campaigns.NewExecutor(action, *execFlags.parallelism, logger, opts)
+			plan, err := executor.Plan(ctx, repos)
+			if err != nil {
+				return err
+			}
+			plan.ActionID = actionID
+			plan.AddUnmatched(skipped, unsupported)
+
+			repoDuration, samples, err := campaigns.EstimateRepoDuration(*execFlags.journal, actionID)
+			if err != nil {
+				return errors.Wrap(err, "estimating durations")
+			}
+			plan.EstimateDurations(repoDuration, samples, *execFlags.parallelism)
+
+			if *planFormatFlag == "json" {
+				return plan.WriteJSON(os.Stdout)
+			}
+			return plan.WriteText(os.Stdout)
 		}
 
-		// Query repos over which to run action
-		logger.Infof("Querying %s for repositories matching '%s'...\n", cfg.Endpoint, action.ScopeQuery)
-		repos, err := actionRepos(ctx, client, action.ScopeQuery, *includeUnsupportedFlag, logger)
+		runID := campaigns.NewRunID()
+		journal, err := campaigns.CreateExecutionJournal(*execFlags.journal, runID, action, actionID, repos)
 		if err != nil {
 			return err
 		}
-		logger.Infof("Use 'src actions scope-query' for help with scoping.\n\n")
+		defer journal.Close()
+		opts.Journal = journal
+		logger.Infof("Recording run %s in %s.\n", runID, *execFlags.journal)
 
 		totalSteps := len(repos) * len(action.Steps)
 		logger.Start(totalSteps)
 
-		executor := campaigns.NewExecutor(action, *parallelismFlag, logger, opts)
+		executor := campaigns.NewExecutor(action, *execFlags.parallelism, logger, opts)
 		for _, repo := range repos {
 			executor.EnqueueRepo(repo)
 		}
 
-		go executor.Start(ctx)
-		err = executor.Wait()
+		err = execFlags.execute(ctx, executor, action, cacheManager, logger)
 
 		patches := executor.AllPatches()
 		if len(patches) == 0 {
 			// We call os.Exit because we don't want to return the error
 			// and have it printed.
-			logger.ActionFailed(err, patches)
+			actionFailed(logger, err, patches, runID)
 			os.Exit(1)
 		}
 
 		if !*createPatchSetFlag && !*forceCreatePatchSetFlag {
 			if err != nil {
-				logger.ActionFailed(err, patches)
+				actionFailed(logger, err, patches, runID)
 				os.Exit(1)
 			}
 
-			err = json.NewEncoder(outputWriter).Encode(patches)
-			if err != nil {
-				return errors.Wrap(err, "writing patches")
-			}
-
-			logger.ActionSuccess(patches)
-
-			if out, ok := outputWriter.(*os.File); ok && out == os.Stdout {
-				// Don't print instructions when piping
-				return nil
-			}
-
-			// Print instructions when we've written patches to a file, even when not in verbose mode
-			fmt.Fprintf(os.Stderr, "\n\nPatches saved to %s, to create a patch set on your Sourcegraph instance please do the following:\n", *outputFlag)
-			fmt.Fprintln(os.Stderr, "\n ", color.HiCyanString("▶"), fmt.Sprintf("src campaign patchset create-from-patches < %s", *outputFlag))
-			fmt.Fprintln(os.Stderr)
-
-			return nil
+			return writePatches(outputWriter, *execFlags.output, *execFlags.outputFormat, patches, logger)
 		}
 
 		if err != nil {
-			logger.ActionFailed(err, patches)
+			actionFailed(logger, err, patches, runID)
 
 			if len(patches) == 0 {
 				os.Exit(1)
@@ -323,10 +560,89 @@ Format of the action JSON files:
 	})
 }
 
-func actionRepos(ctx context.Context, client api.Client, scopeQuery string, includeUnsupported bool, logger *campaigns.ActionLogger) ([]campaigns.ActionRepo, error) {
+// actionRepos returns the repositories in the scope of the action, and the
+// names of the repositories that are skipped because they or their default
+// branch or pinned revision are unknown, or their code host is unsupported.
+func actionRepos(ctx context.Context, client api.Client, action *campaigns.Action, dir string, includeUnsupported bool, logger *campaigns.ActionLogger) (repos []campaigns.ActionRepo, skipped, unsupported []string, err error) {
+	scoped, alerts, err := actionScopeRepos(ctx, client, action, dir, includeUnsupported)
+	if err != nil || scoped == nil {
+		return nil, nil, nil, err
+	}
+
+	skipped = []string{}
+	unsupported = []string{}
+	for _, repo := range scoped {
+		switch repo.SkipReason {
+		case "":
+			repos = append(repos, repo.ActionRepo)
+		case skipDuplicate:
+		case unsupportedCodeHostReason(repo.CodeHost):
+			unsupported = append(unsupported, repo.Name)
+		default:
+			skipped = append(skipped, repo.Name)
+		}
+	}
+	logger.RepoMatches(len(repos), skipped, unsupported)
+
+	for _, alert := range alerts {
+		printSearchAlert(alert)
+	}
+
+	return repos, skipped, unsupported, nil
+}
+
+// Reasons why a repository matched by the scope query is skipped. Repositories
+// on unsupported code hosts are skipped with the reason returned by
+// unsupportedCodeHostReason.
+const (
+	skipNoDefaultBranch    = "no default branch"
+	skipEmptyDefaultBranch = "default branch has no commits"
+	skipDuplicate          = "duplicate search result"
+)
+
+// scopeRepo is a repository matched by the scope query of an action.
+type scopeRepo struct {
+	campaigns.ActionRepo
+
+	// CodeHost is the type of the code host of the repository, e.g.
+	// "github".
+	CodeHost string
+
+	// SkipReason is why the repository isn't in scope, or empty if it is.
+	SkipReason string
+}
+
+type scopeQueryRepository struct {
+	ID, Name           string
+	ExternalRepository struct {
+		ServiceType string
+	}
+	DefaultBranch *struct {
+		Name   string
+		Target struct{ OID string }
+	}
+}
+
+type scopeQueryResult struct {
+	Typename string `json:"__typename"`
+	scopeQueryRepository
+	File        struct{ Path string } `json:"file"`
+	LineMatches []struct {
+		Preview    string
+		LineNumber int
+	} `json:"lineMatches"`
+	Repository scopeQueryRepository `json:"repository"`
+}
+
+// queryScopeRepos returns the repositories matched by the scope query, sorted
+// by name, including the skipped ones, and the alert returned by the search.
+// If the response contains errors, which are printed by the client, nil is
+// returned.
+func queryScopeRepos(ctx context.Context, client api.Client, scopeQuery string, includeUnsupported bool) ([]scopeRepo, searchResultsAlert, error) {
+	var alert searchResultsAlert
 	hasCount, err := regexp.MatchString(`count:\d+`, scopeQuery)
 	if err != nil {
-		return nil, err
+		return nil, alert, err
 	}
 
 	if !hasCount {
@@ -343,6 +659,13 @@ query ActionRepos($query: String!) {
 					...repositoryFields
 				}
 				... on FileMatch {
+					file {
+						path
+					}
+					lineMatches {
+						preview
+						lineNumber
+					}
 					repository {
 						...repositoryFields
 					}
@@ -368,33 +691,12 @@ fragment repositoryFields on Repository {
 }
 ` + searchResultsAlertFragment
 
-	type Repository struct {
-		ID, Name           string
-		ExternalRepository struct {
-			ServiceType string
-		}
-		DefaultBranch *struct {
-			Name   string
-			Target struct{ OID string }
-		}
-	}
 	var result struct {
 		Data struct {
 			Search struct {
 				Results struct {
-					Results []struct {
-						Typename           string `json:"__typename"`
-						ID, Name           string
-						ExternalRepository struct {
-							ServiceType string
-						}
-						DefaultBranch *struct {
-							Name   string
-							Target struct{ OID string }
-						}
-						Repository Repository `json:"repository"`
-					}
-					Alert searchResultsAlert
+					Results []scopeQueryResult
+					Alert   searchResultsAlert
 				}
 			}
 		} `json:"data,omitempty"`
@@ -409,77 +711,342 @@ fragment repositoryFields on Repository {
 		"query": scopeQuery,
 	}).DoRaw(ctx, &result)
 	if err != nil {
-		return nil, err
+		return nil, alert, err
 	} else if !ok {
-		return nil, nil
+		return nil, alert, nil
 	}
 
-	skipped := []string{}
-	unsupported := []string{}
-	reposByID := map[string]campaigns.ActionRepo{}
-	for _, searchResult := range result.Data.Search.Results.Results {
+	isSupported := func(kind string) (bool, error) {
+		if includeUnsupported {
+			return true, nil
+		}
+		return isCodeHostSupportedForCampaigns(ctx, client, kind)
+	}
+	repos, err := scopeRepos(result.Data.Search.Results.Results, isSupported)
+	if err != nil {
+		return nil, alert, err
+	}
+	return repos, result.Data.Search.Results.Alert, nil
+}
 
-		var repo Repository
+// scopeRepos merges the search results by repository. File matches are added
+// to the repository they're in; repository results for a repository that was
+// already returned as a repository result are reported as skipped duplicates.
+func scopeRepos(results []scopeQueryResult, isSupported func(kind string) (bool, error)) ([]scopeRepo, error) {
+	repos := []scopeRepo{}
+	indexByID := map[string]int{}
+	repoResults := map[string]bool{}
+	for _, searchResult := range results {
+		repo := searchResult.scopeQueryRepository
 		if searchResult.Repository.ID != "" {
 			repo = searchResult.Repository
-		} else {
-			repo = Repository{
-				ID:                 searchResult.ID,
-				Name:               searchResult.Name,
-				ExternalRepository: searchResult.ExternalRepository,
-				DefaultBranch:      searchResult.DefaultBranch,
-			}
 		}
-
-		// Skip repos from unsupported code hosts but don't report them explicitly.
-		if !includeUnsupported {
-			ok, err := isCodeHostSupportedForCampaigns(ctx, client, repo.ExternalRepository.ServiceType)
-			if err != nil {
-				return nil, errors.Wrap(err, "failed code host check")
-			}
-			if !ok {
-				unsupported = append(unsupported, repo.Name)
-				continue
-			}
+		isFileMatch := searchResult.Typename == "FileMatch"
+		duplicate := !isFileMatch && repoResults[repo.ID]
+		if !isFileMatch {
+			repoResults[repo.ID] = true
 		}
 
-		if repo.DefaultBranch == nil || repo.DefaultBranch.Name == "" {
-			skipped = append(skipped, repo.Name)
+		if i, ok := indexByID[repo.ID]; ok {
+			if isFileMatch && repos[i].SkipReason == "" {
+				repos[i].FileMatches = append(repos[i].FileMatches, fileMatch(searchResult))
+			} else if duplicate {
+				repos = append(repos, scopeRepo{
+					ActionRepo: campaigns.ActionRepo{ID: repo.ID, Name: repo.Name},
+					CodeHost:   strings.ToLower(repo.ExternalRepository.ServiceType),
+					SkipReason: skipDuplicate,
+				})
+			}
 			continue
 		}
 
-		if repo.DefaultBranch.Target.OID == "" {
-			skipped = append(skipped, repo.Name)
-			continue
+		sr := scopeRepo{
+			ActionRepo: campaigns.ActionRepo{ID: repo.ID, Name: repo.Name},
+			CodeHost:   strings.ToLower(repo.ExternalRepository.ServiceType),
+		}
+		if repo.DefaultBranch != nil {
+			sr.BaseRef = repo.DefaultBranch.Name
+			sr.Rev = repo.DefaultBranch.Target.OID
 		}
 
-		if _, ok := reposByID[repo.ID]; !ok {
-			reposByID[repo.ID] = campaigns.ActionRepo{
-				ID:      repo.ID,
-				Name:    repo.Name,
-				Rev:     repo.DefaultBranch.Target.OID,
-				BaseRef: repo.DefaultBranch.Name,
-			}
+		ok, err := isSupported(repo.ExternalRepository.ServiceType)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed code host check")
+		}
+		switch {
+		case !ok:
+			sr.SkipReason = unsupportedCodeHostReason(repo.ExternalRepository.ServiceType)
+		case sr.BaseRef == "":
+			sr.SkipReason = skipNoDefaultBranch
+		case sr.Rev == "":
+			sr.SkipReason = skipEmptyDefaultBranch
+		case isFileMatch:
+			sr.FileMatches = append(sr.FileMatches, fileMatch(searchResult))
 		}
+
+		indexByID[repo.ID] = len(repos)
+		repos = append(repos, sr)
 	}
 
-	repos := make([]campaigns.ActionRepo, 0, len(reposByID))
-	for _, repo := range reposByID {
-		repos = append(repos, repo)
+	sort.SliceStable(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos, nil
+}
+
+func fileMatch(searchResult scopeQueryResult) campaigns.FileMatch {
+	match := campaigns.FileMatch{Path: searchResult.File.Path}
+	for _, lm := range searchResult.LineMatches {
+		// Line numbers returned by the API are 0-based.
+		match.LineMatches = append(match.LineMatches, campaigns.LineMatch{
+			LineNumber: lm.LineNumber + 1,
+			Preview:    lm.Preview,
+		})
 	}
-	logger.RepoMatches(len(repos), skipped, unsupported)
+	return match
+}
 
-	if content, err := result.Data.Search.Results.Alert.Render(); err != nil {
+// unsupportedCodeHostReason returns why repositories on the code host of the
+// given kind are skipped.
+func unsupportedCodeHostReason(kind string) string {
+	kind = strings.ToLower(kind)
+	if mvd := codeHostCampaignVersions[kind]; mvd != nil {
+		return fmt.Sprintf("code host %s requires Sourcegraph %s or later", kind, mvd.version)
+	}
+	return fmt.Sprintf("unsupported code host %s", kind)
+}
+
+func printSearchAlert(alert searchResultsAlert) {
+	if content, err := alert.Render(); err != nil {
 		yellow.Fprint(os.Stderr, err)
 	} else {
 		os.Stderr.WriteString(content)
 	}
-
-	return repos, nil
 }
 
 var yellow = color.New(color.FgYellow)
 
+// actionFileDir returns the directory that the paths of the step bundles used
+// by the action file are relative to.
+func actionFileDir(path string) string {
+	if path == "-" {
+		return "."
+	}
+	return filepath.Dir(path)
+}
+
+// userCacheSubdir returns the given directory in the user cache directory and
+// the way it is displayed in usage messages.
+func userCacheSubdir(name string) (dir, display string) {
+	dir, _ = campaigns.UserCacheDir()
+	if dir != "" {
+		dir = filepath.Join(dir, name)
+	}
+	return dir, strings.Replace(dir, os.Getenv("HOME"), "$HOME", 1)
+}
+
+// userConfigSubdir returns the given directory in the user config directory
+// and the way it is displayed in usage messages.
+func userConfigSubdir(name string) (dir, display string) {
+	dir, _ = campaigns.UserConfigDir()
+	if dir != "" {
+		dir = filepath.Join(dir, name)
+	}
+	return dir, strings.Replace(dir, os.Getenv("HOME"), "$HOME", 1)
+}
+
+const defaultWorkspaceRemote = "https://${{ .Repository.Name }}.git"
+
+// workspaceCreator returns the WorkspaceCreator selected with -workspace.
+func workspaceCreator(kind, mirrorsDir, remote string) (campaigns.WorkspaceCreator, error) {
+	switch kind {
+	case "zip":
+		return campaigns.NewZipWorkspaceCreator(cfg.Endpoint, cfg.AccessToken, cfg.AdditionalHeaders), nil
+	case "mirror":
+		if mirrorsDir == "" {
+			return nil, errors.New("workspace-mirrors is not a valid path")
+		}
+		workspaces, err := campaigns.NewMirrorWorkspaceCreator(mirrorsDir, remote)
+		if err != nil {
+			return nil, &usageError{err}
+		}
+		return workspaces, nil
+	default:
+		return nil, &usageError{fmt.Errorf("unknown workspace %q", kind)}
+	}
+}
+
+// patchesOutputFile returns the file the patches are written to in the given
+// format: output if -o was given, and a default for the format otherwise.
+func patchesOutputFile(flagSet *flag.FlagSet, output, format string) string {
+	set := false
+	flagSet.Visit(func(f *flag.Flag) {
+		set = set || f.Name == "o"
+	})
+	if set {
+		return output
+	}
+	switch format {
+	case campaigns.PatchFormatDir:
+		return "patches"
+	case campaigns.PatchFormatMbox:
+		return "patches.mbox"
+	case campaigns.PatchFormatDiff:
+		return "patches.diff"
+	default:
+		return output
+	}
+}
+
+// patchesOutputWriter returns stdout if it is a pipe that isn't taken by other
+// output, or the newly created output file otherwise. Patches in the dir
+// format are written to a directory, so it returns nil for them.
+func patchesOutputWriter(outputFile, format string, stdoutTaken bool) (*os.File, error) {
+	if format == campaigns.PatchFormatDir {
+		return nil, nil
+	}
+
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if isPipe := fi.Mode()&os.ModeCharDevice == 0; isPipe && !stdoutTaken {
+		return os.Stdout, nil
+	}
+
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating output file")
+	}
+	return f, nil
+}
+
+// writePatches writes the produced patches in the given format to out, or to
+// the directory outputFile for the dir format, and tells the user how to
+// create a patch set from them.
+func writePatches(out *os.File, outputFile, format string, patches []campaigns.PatchInput, logger *campaigns.ActionLogger) error {
+	if format == campaigns.PatchFormatDir {
+		if err := campaigns.WritePatchDir(outputFile, patches); err != nil {
+			return errors.Wrap(err, "writing patches")
+		}
+	} else if err := campaigns.WritePatches(out, format, patches); err != nil {
+		return errors.Wrap(err, "writing patches")
+	}
+
+	logger.ActionSuccess(patches)
+
+	if out == os.Stdout {
+		// Don't print instructions when piping
+		return nil
+	}
+
+	for _, p := range patches {
+		if campaigns.IsLocalRepositoryID(p.Repository) {
+			fmt.Fprintf(os.Stderr, "\n\nPatches saved to %s. They were produced in local repositories, so no patch set can be created from them.\n\n", outputFile)
+			return nil
+		}
+	}
+
+	createCmd := fmt.Sprintf("src campaign patchset create-from-patches < %s", outputFile)
+	switch format {
+	case campaigns.PatchFormatDir:
+		createCmd = fmt.Sprintf("src campaign patchset create-from-patches -input-format dir -i %s", outputFile)
+	case campaigns.PatchFormatMbox, campaigns.PatchFormatDiff:
+		createCmd = fmt.Sprintf("src campaign patchset create-from-patches -input-format %s < %s", format, outputFile)
+	}
+
+	// Print instructions when we've written patches to a file, even when not in verbose mode
+	fmt.Fprintf(os.Stderr, "\n\nPatches saved to %s, to create a patch set on your Sourcegraph instance please do the following:\n", outputFile)
+	fmt.Fprintln(os.Stderr, "\n ", color.HiCyanString("▶"), createCmd)
+	fmt.Fprintln(os.Stderr)
+
+	return nil
+}
+
+// loadSecrets reads the secrets file at path. If path is empty, no secrets
+// are defined.
+func loadSecrets(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading secrets file")
+	}
+
+	var secrets map[string]string
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		return nil, errors.Wrap(err, "parsing secrets file")
+	}
+	return secrets, nil
+}
+
+// writeExecutionReport writes the report of an action execution in the given
+// format to path.
+func writeExecutionReport(path, format string, action campaigns.Action, executor *campaigns.Executor) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	report := campaigns.NewExecutionReport(action, executor.Repos(), executor.RepoStatuses())
+	if format == "junit" {
+		return report.WriteJUnit(f)
+	}
+	return report.WriteJSON(f)
+}
+
+// addEventsSink makes the logger write its events as JSON lines to path, or
+// to stdout if path is "-". The returned function closes the file.
+func addEventsSink(logger *campaigns.ActionLogger, path string) (func() error, error) {
+	if path == "-" {
+		logger.AddSink(campaigns.NewJSONLinesSink(os.Stdout))
+		return func() error { return nil }, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating events file")
+	}
+	logger.AddSink(campaigns.NewJSONLinesSink(f))
+	return f.Close, nil
+}
+
+// actionFailed reports the failed action and, if some repositories failed,
+// how to re-run them.
+func actionFailed(logger *campaigns.ActionLogger, err error, patches []campaigns.PatchInput, runID string) {
+	logger.ActionFailed(err, patches)
+	if err == nil {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "To re-run only the failed and unfinished repositories, run:")
+	fmt.Fprintln(os.Stderr, "\n ", color.HiCyanString("▶"), fmt.Sprintf("src actions resume %s", runID))
+	fmt.Fprintln(os.Stderr)
+}
+
+// interruptibleContext returns a context that is canceled when the user hits
+// Ctrl-C. Hitting Ctrl-C a second time exits immediately.
+func interruptibleContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	go func() {
+		select {
+		case <-c:
+			cancel()
+		case <-ctx.Done():
+		}
+		<-c // If user hits Ctrl-C second time, we do a hard exit
+		os.Exit(2)
+	}()
+
+	return ctx, func() {
+		signal.Stop(c)
+		cancel()
+	}
+}
+
 func isGitAvailable() bool {
 	cmd := exec.Command("git", "version")
 	if err := cmd.Run(); err != nil {
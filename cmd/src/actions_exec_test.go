@@ -1,9 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/errors"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
 )
 
 func TestCodeHostSupported(t *testing.T) {
@@ -81,3 +84,43 @@ func TestCodeHostSupported(t *testing.T) {
 		}
 	})
 }
+
+func TestScopeRepos(t *testing.T) {
+	var results []scopeQueryResult
+	if err := json.Unmarshal([]byte(`[
+		{"__typename": "FileMatch", "file": {"path": "a.go"}, "lineMatches": [{"preview": "x", "lineNumber": 0}],
+		 "repository": {"id": "1", "name": "github.com/a/a", "externalRepository": {"serviceType": "github"}, "defaultBranch": {"name": "main", "target": {"oid": "aaa"}}}},
+		{"__typename": "Repository", "id": "1", "name": "github.com/a/a", "externalRepository": {"serviceType": "github"}, "defaultBranch": {"name": "main", "target": {"oid": "aaa"}}},
+		{"__typename": "FileMatch", "file": {"path": "b.go"},
+		 "repository": {"id": "1", "name": "github.com/a/a", "externalRepository": {"serviceType": "github"}, "defaultBranch": {"name": "main", "target": {"oid": "aaa"}}}},
+		{"__typename": "Repository", "id": "1", "name": "github.com/a/a", "externalRepository": {"serviceType": "github"}, "defaultBranch": {"name": "main", "target": {"oid": "aaa"}}},
+		{"__typename": "Repository", "id": "2", "name": "github.com/a/empty", "externalRepository": {"serviceType": "github"}, "defaultBranch": {"name": "main", "target": {"oid": ""}}},
+		{"__typename": "Repository", "id": "3", "name": "gitlab.com/a/a", "externalRepository": {"serviceType": "gitlab"}, "defaultBranch": {"name": "master", "target": {"oid": "ccc"}}},
+		{"__typename": "Repository", "id": "4", "name": "github.com/a/bare", "externalRepository": {"serviceType": "github"}},
+		{"__typename": "Repository", "id": "5", "name": "phabricator.example.com/a", "externalRepository": {"serviceType": "phabricator"}}
+	]`), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := scopeRepos(results, func(kind string) (bool, error) { return kind == "github", nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scopeRepo{
+		{
+			ActionRepo: campaigns.ActionRepo{ID: "1", Name: "github.com/a/a", BaseRef: "main", Rev: "aaa", FileMatches: []campaigns.FileMatch{
+				{Path: "a.go", LineMatches: []campaigns.LineMatch{{LineNumber: 1, Preview: "x"}}},
+				{Path: "b.go"},
+			}},
+			CodeHost: "github",
+		},
+		{ActionRepo: campaigns.ActionRepo{ID: "1", Name: "github.com/a/a"}, CodeHost: "github", SkipReason: skipDuplicate},
+		{ActionRepo: campaigns.ActionRepo{ID: "4", Name: "github.com/a/bare"}, CodeHost: "github", SkipReason: skipNoDefaultBranch},
+		{ActionRepo: campaigns.ActionRepo{ID: "2", Name: "github.com/a/empty", BaseRef: "main"}, CodeHost: "github", SkipReason: skipEmptyDefaultBranch},
+		{ActionRepo: campaigns.ActionRepo{ID: "3", Name: "gitlab.com/a/a", BaseRef: "master", Rev: "ccc"}, CodeHost: "gitlab", SkipReason: "code host gitlab requires Sourcegraph 3.18.0 or later"},
+		{ActionRepo: campaigns.ActionRepo{ID: "5", Name: "phabricator.example.com/a"}, CodeHost: "phabricator", SkipReason: "unsupported code host phabricator"},
+	}
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("wrong repos (-want +got):\n%s", diff)
+	}
+}
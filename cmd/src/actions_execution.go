@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
+
+// executionFlags are the flags for executing an action in repositories, which
+// 'src actions exec' and 'src actions resume' share.
+type executionFlags struct {
+	journalDir, displayJournalDir string
+	mirrorsDir, displayMirrorsDir string
+
+	output       *string
+	outputFormat *string
+	parallelism  *int
+
+	cache       *cacheFlags
+	cachePolicy *cachePolicyFlags
+	remoteCache *string
+	journal     *string
+
+	report       *string
+	reportFormat *string
+	events       *string
+
+	secrets *string
+
+	workspace        *string
+	workspaceMirrors *string
+	workspaceRemote  *string
+
+	keepLogs  *bool
+	dashboard *bool
+	timeout   *time.Duration
+
+	maxAttempts  *int
+	retryBackoff *time.Duration
+
+	// policy is the eviction policy of the cache, set by validate.
+	policy campaigns.CachePolicy
+}
+
+// newExecutionFlags registers the execution flags. journalUsage is the usage
+// of -journal, which differs between the commands.
+func newExecutionFlags(flagSet *flag.FlagSet, journalUsage string) *executionFlags {
+	f := &executionFlags{}
+	f.journalDir, f.displayJournalDir = userCacheSubdir("action-runs")
+	f.mirrorsDir, f.displayMirrorsDir = userCacheSubdir("action-mirrors")
+
+	f.output = flagSet.String("o", "patches.json", "The output file. Will be used as the destination for patches unless the command is being piped in which case patches are piped to stdout")
+	f.outputFormat = flagSet.String("output-format", campaigns.PatchFormatJSON, patchFormatUsage("The format the patches are written in")+` With "dir", -o is the directory and defaults to "patches".`)
+	f.parallelism = flagSet.Int("j", runtime.GOMAXPROCS(0), "The number of parallel jobs.")
+
+	f.cache = newCacheFlags(flagSet)
+	f.cachePolicy = newCachePolicyFlags(flagSet, "cache-")
+	f.remoteCache = flagSet.String("remote-cache", "", "The URL of a cache shared with others, which is used in addition to -cache. If not given, the \"remoteCache\" of the config is used. See 'src actions cache serve -h'.")
+	f.journal = flagSet.String("journal", f.displayJournalDir, journalUsage)
+
+	f.report = flagSet.String("report", "", "If given, a report of the execution in every repository is written to this file.")
+	f.reportFormat = flagSet.String("report-format", "json", `The format of the report written with -report: "json" or "junit".`)
+	f.events = flagSet.String("events", "", `If given, the events of the execution, e.g. started steps and their output, are written as JSON lines to this file, or to stdout if it's "-". The patches are then written to -o.`)
+
+	f.secrets = flagSet.String("secrets", "", "A YAML or JSON file mapping secret names to values, which environment variables of steps can refer to with \"fromSecret\". Secret values are redacted from the output and log files.")
+
+	f.workspace = flagSet.String("workspace", "zip", `How the repositories are checked out: "zip" downloads a ZIP archive of every repository from Sourcegraph, "mirror" keeps a bare git mirror of every repository in -workspace-mirrors, which is fetched from -workspace-remote, and creates a git worktree for every execution.`)
+	f.workspaceMirrors = flagSet.String("workspace-mirrors", f.displayMirrorsDir, "Directory for the git mirrors used with -workspace mirror.")
+	f.workspaceRemote = flagSet.String("workspace-remote", defaultWorkspaceRemote, "The URL the git mirrors used with -workspace mirror are fetched from. Can contain templates like the args of steps.")
+
+	f.keepLogs = flagSet.Bool("keep-logs", false, "Do not remove execution log files when done.")
+	f.dashboard = flagSet.Bool("dashboard", false, "Show a full-screen dashboard of the repositories the action is being executed in instead of the progress bar. It can tail the log of a single repository and cancel its execution.")
+	f.timeout = flagSet.Duration("timeout", defaultTimeout, "The maximum duration a single action run can take.")
+
+	f.maxAttempts = flagSet.Int("max-attempts", 3, "The maximum number of times an action is executed in a repository when it fails while fetching the repository, unpacking it or running Docker. Failing steps are never retried.")
+	f.retryBackoff = flagSet.Duration("retry-backoff", 5*time.Second, "The delay before retrying an action in a repository. It is doubled for every subsequent retry.")
+	return f
+}
+
+// validate checks the parsed flags and replaces the displayed defaults of the
+// directories with the actual ones.
+func (f *executionFlags) validate() error {
+	if *f.reportFormat != "json" && *f.reportFormat != "junit" {
+		return &usageError{fmt.Errorf("unknown report format %q", *f.reportFormat)}
+	}
+	if err := validatePatchFormat(*f.outputFormat); err != nil {
+		return err
+	}
+
+	policy, err := f.cachePolicy.policy()
+	if err != nil {
+		return err
+	}
+	f.policy = policy
+
+	if *f.journal == f.displayJournalDir {
+		*f.journal = f.journalDir
+	}
+	if *f.journal == "" {
+		return errors.New("journal is not a valid path")
+	}
+	if *f.workspaceMirrors == f.displayMirrorsDir {
+		*f.workspaceMirrors = f.mirrorsDir
+	}
+	return nil
+}
+
+func (f *executionFlags) workspaceCreator() (campaigns.WorkspaceCreator, error) {
+	return workspaceCreator(*f.workspace, *f.workspaceMirrors, *f.workspaceRemote)
+}
+
+// outputWriter returns the writer for the patches, see patchesOutputWriter,
+// and sets -o to the default for the output format if it wasn't given.
+func (f *executionFlags) outputWriter(flagSet *flag.FlagSet) (*os.File, error) {
+	*f.output = patchesOutputFile(flagSet, *f.output, *f.outputFormat)
+	return patchesOutputWriter(*f.output, *f.outputFormat, *f.events == "-")
+}
+
+// logger returns the logger of the execution, which writes the events to
+// -events if it's given. The returned function closes the events file.
+func (f *executionFlags) logger() (*campaigns.ActionLogger, func() error, error) {
+	logger := campaigns.NewActionLogger(*verbose, *f.keepLogs)
+	if *f.events == "" {
+		return logger, func() error { return nil }, nil
+	}
+	closeEvents, err := addEventsSink(logger, *f.events)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logger, closeEvents, nil
+}
+
+func (f *executionFlags) retryPolicy() campaigns.RetryPolicy {
+	return campaigns.RetryPolicy{
+		MaxAttempts:    *f.maxAttempts,
+		InitialBackoff: *f.retryBackoff,
+		MaxBackoff:     maxRetryBackoff,
+	}
+}
+
+// executorOpts loads the secrets, checks that the environment variables of
+// the action can be resolved and returns the options of its executor. The
+// journal isn't set.
+func (f *executionFlags) executorOpts(action campaigns.Action, cacheManager campaigns.CacheManager, workspaces campaigns.WorkspaceCreator, logger *campaigns.ActionLogger) (campaigns.ExecutorOpts, error) {
+	secrets, err := loadSecrets(*f.secrets)
+	if err != nil {
+		return campaigns.ExecutorOpts{}, err
+	}
+	if err := campaigns.ValidateActionEnv(action, secrets); err != nil {
+		return campaigns.ExecutorOpts{}, err
+	}
+	logger.RedactSecrets(campaigns.SecretValues(action, secrets))
+
+	return campaigns.ExecutorOpts{
+		Endpoint:          cfg.Endpoint,
+		AccessToken:       cfg.AccessToken,
+		AdditionalHeaders: cfg.AdditionalHeaders,
+		Timeout:           *f.timeout,
+		Retry:             f.retryPolicy(),
+		Secrets:           secrets,
+		KeepLogs:          *f.keepLogs,
+		Cache:             executionCache(cacheManager, *f.remoteCache),
+		Volumes:           cacheManager.Volumes,
+		Workspaces:        workspaces,
+	}, nil
+}
+
+// execute runs the executor, with the dashboard if it's enabled, until all
+// enqueued repositories are done, prunes the cache and writes the report.
+// It returns the error of the executor.
+func (f *executionFlags) execute(ctx context.Context, executor *campaigns.Executor, action campaigns.Action, cacheManager campaigns.CacheManager, logger *campaigns.ActionLogger) error {
+	if *f.dashboard {
+		dashboard, err := logger.ShowDashboard(ctx, executor.CancelRepo)
+		if err != nil {
+			return err
+		}
+		defer dashboard.Close()
+	}
+
+	go executor.Start(ctx)
+	err := executor.Wait()
+	pruneCache(cacheManager, f.policy, logger)
+
+	if *f.report != "" {
+		if err := writeExecutionReport(*f.report, *f.reportFormat, action, executor); err != nil {
+			yellow.Fprintf(os.Stderr, "Writing execution report failed: %s\n", err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
+
+func init() {
+	usage := `
+Resume an interrupted or partially failed run of 'src actions exec'. Only the repositories in which the action failed or didn't finish are executed again. The patches of all repositories are written to the output, just like 'src actions exec' does.
+
+The ID of a run is printed by 'src actions exec' when the action fails and, in verbose mode, when the run starts.
+
+Examples:
+
+  Resume the run with ID 20200715-103012 and save the patches to 'patches.json':
+
+	$ src actions resume 20200715-103012
+
+  Resume a run and pipe the patches to 'src campaign patchset create-from-patches':
+
+	$ src actions resume 20200715-103012 | src campaign patchset create-from-patches
+
+`
+
+	flagSet := flag.NewFlagSet("resume", flag.ExitOnError)
+	usageFunc := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of 'src actions %s':\n", flagSet.Name())
+		flagSet.PrintDefaults()
+		fmt.Println(usage)
+	}
+
+	execFlags := newExecutionFlags(flagSet, "Directory containing the run journals written by 'src actions exec'.")
+
+	handler := func(args []string) error {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return err
+		}
+
+		runID := flagSet.Arg(0)
+		if runID == "" {
+			return &usageError{errors.New("the ID of the run to resume must be given")}
+		}
+
+		if err := execFlags.validate(); err != nil {
+			return err
+		}
+
+		if !isGitAvailable() {
+			return errors.New("Could not find git in $PATH. 'src actions resume' requires git to be available.")
+		}
+
+		workspaces, err := execFlags.workspaceCreator()
+		if err != nil {
+			return err
+		}
+
+		run, err := campaigns.LoadJournaledRun(*execFlags.journal, runID)
+		if err != nil {
+			return err
+		}
+		cacheManager, err := execFlags.cache.manager(run.ActionID)
+		if err != nil {
+			return err
+		}
+
+		outputWriter, err := execFlags.outputWriter(flagSet)
+		if err != nil {
+			return err
+		}
+		if outputWriter != nil && outputWriter != os.Stdout {
+			defer outputWriter.Close()
+		}
+
+		ctx, cancel := interruptibleContext()
+		defer cancel()
+
+		logger, closeEvents, err := execFlags.logger()
+		if err != nil {
+			return err
+		}
+		defer closeEvents()
+
+		// Fetch Docker images etc.
+		err = campaigns.PrepareAction(ctx, run.Action, execFlags.retryPolicy(), logger)
+		if err != nil {
+			return errors.Wrap(err, "Failed to prepare action")
+		}
+
+		opts, err := execFlags.executorOpts(run.Action, cacheManager, workspaces, logger)
+		if err != nil {
+			return err
+		}
+
+		journal, err := campaigns.OpenExecutionJournal(*execFlags.journal, runID)
+		if err != nil {
+			return err
+		}
+		defer journal.Close()
+		opts.Journal = journal
+
+		executor := campaigns.NewExecutor(run.Action, *execFlags.parallelism, logger, opts)
+		pending := 0
+		for _, repo := range run.Repos {
+			if run.Done(repo) {
+				executor.RestoreRepo(repo, run.Statuses[repo.ID])
+				continue
+			}
+			executor.EnqueueRepo(repo)
+			pending++
+		}
+		logger.Infof("Resuming run %s: %d of %d repositories left to execute.\n", runID, pending, len(run.Repos))
+
+		logger.Start(pending * len(run.Action.Steps))
+
+		err = execFlags.execute(ctx, executor, run.Action, cacheManager, logger)
+
+		patches := executor.AllPatches()
+		if len(patches) == 0 || err != nil {
+			// We call os.Exit because we don't want to return the error
+			// and have it printed.
+			actionFailed(logger, err, patches, runID)
+			os.Exit(1)
+		}
+
+		return writePatches(outputWriter, *execFlags.output, *execFlags.outputFormat, patches, logger)
+	}
+
+	// Register the command.
+	actionsCommands = append(actionsCommands, &command{
+		flagSet:   flagSet,
+		handler:   handler,
+		usageFunc: usageFunc,
+	})
+}
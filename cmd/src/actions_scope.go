@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/src-cli/internal/api"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
+
+// Reasons why an explicitly listed repository is skipped.
+const (
+	skipRepoNotFound   = "repository not found"
+	skipRevNotFound    = "revision not found"
+	skipAbbreviatedRev = "abbreviated commit SHA, use the full SHA"
+)
+
+// actionScopeRepos returns the repositories in the scope of the action, sorted
+// by name, including the skipped ones, and the alerts returned by the
+// searches. Relative paths in the scope are resolved against dir. If a
+// response contains errors, which are printed by the client, nil is returned.
+func actionScopeRepos(ctx context.Context, client api.Client, action *campaigns.Action, dir string, includeUnsupported bool) ([]scopeRepo, []searchResultsAlert, error) {
+	explicit, err := action.ScopeRepositories(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		results [][]scopeRepo
+		alerts  []searchResultsAlert
+	)
+	for _, query := range action.ScopeQueries() {
+		repos, alert, err := queryScopeRepos(ctx, client, query, includeUnsupported)
+		if err != nil || repos == nil {
+			return nil, nil, err
+		}
+		results = append(results, repos)
+		alerts = append(alerts, alert)
+	}
+
+	combine := campaigns.ScopeUnion
+	if action.Scope != nil && action.Scope.Combine != "" {
+		combine = action.Scope.Combine
+	}
+	repos := combineScopeRepos(results, combine)
+
+	if len(explicit) > 0 {
+		isSupported := func(kind string) (bool, error) {
+			if includeUnsupported {
+				return true, nil
+			}
+			return isCodeHostSupportedForCampaigns(ctx, client, kind)
+		}
+		listed, err := lookupScopeRepositories(ctx, client, explicit, isSupported)
+		if err != nil || listed == nil {
+			return nil, nil, err
+		}
+		repos = addScopeRepositories(repos, listed)
+	}
+
+	return repos, alerts, nil
+}
+
+// combineScopeRepos combines the repositories matched by several queries.
+// With campaigns.ScopeIntersection, only the repositories matched by all
+// queries are kept. The file matches of a repository are merged, see
+// mergeFileMatches.
+func combineScopeRepos(results [][]scopeRepo, combine string) []scopeRepo {
+	repos := []scopeRepo{}
+	indexByID := map[string]int{}
+	queriesByID := map[string]int{}
+	for _, result := range results {
+		for _, repo := range result {
+			if repo.SkipReason == skipDuplicate {
+				repos = append(repos, repo)
+				continue
+			}
+			queriesByID[repo.ID]++
+			if i, ok := indexByID[repo.ID]; ok {
+				if repos[i].SkipReason == "" {
+					repos[i].FileMatches = mergeFileMatches(repos[i].FileMatches, repo.FileMatches)
+				}
+				continue
+			}
+			indexByID[repo.ID] = len(repos)
+			repos = append(repos, repo)
+		}
+	}
+
+	if combine == campaigns.ScopeIntersection {
+		kept := repos[:0]
+		for _, repo := range repos {
+			if queriesByID[repo.ID] == len(results) {
+				kept = append(kept, repo)
+			}
+		}
+		repos = kept
+	}
+
+	sort.SliceStable(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos
+}
+
+// mergeFileMatches adds the file matches in b to the ones in a. A file that is
+// in both is kept once, with the line matches of both.
+func mergeFileMatches(a, b []campaigns.FileMatch) []campaigns.FileMatch {
+	indexByPath := make(map[string]int, len(a))
+	for i, m := range a {
+		indexByPath[m.Path] = i
+	}
+	for _, m := range b {
+		i, ok := indexByPath[m.Path]
+		if !ok {
+			indexByPath[m.Path] = len(a)
+			a = append(a, m)
+			continue
+		}
+		lines := map[int]bool{}
+		for _, l := range a[i].LineMatches {
+			lines[l.LineNumber] = true
+		}
+		for _, l := range m.LineMatches {
+			if !lines[l.LineNumber] {
+				a[i].LineMatches = append(a[i].LineMatches, l)
+			}
+		}
+	}
+	return a
+}
+
+// addScopeRepositories adds the explicitly listed repositories to the
+// repositories matched by the queries. Listed repositories that were matched
+// replace the matched ones, but keep their file matches.
+func addScopeRepositories(repos, listed []scopeRepo) []scopeRepo {
+	indexByName := map[string]int{}
+	for i, repo := range repos {
+		if repo.SkipReason != skipDuplicate {
+			indexByName[repo.Name] = i
+		}
+	}
+
+	for _, repo := range listed {
+		i, ok := indexByName[repo.Name]
+		if !ok {
+			repos = append(repos, repo)
+			continue
+		}
+		if repo.SkipReason == "" {
+			repo.FileMatches = repos[i].FileMatches
+		}
+		repos[i] = repo
+	}
+
+	sort.SliceStable(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos
+}
+
+// scopeRepositoryLookup is a repository looked up by name, with the commit of
+// the branch or revision it's pinned to, if any.
+type scopeRepositoryLookup struct {
+	scopeQueryRepository
+	Commit *struct{ OID string }
+}
+
+// scopeRepositoryLookupBatchSize is the number of repositories that are
+// looked up in one request.
+const scopeRepositoryLookupBatchSize = 100
+
+// lookupScopeRepositories looks up the explicitly listed repositories, in
+// batches. If a response contains errors, which are printed by the client,
+// nil is returned.
+func lookupScopeRepositories(ctx context.Context, client api.Client, listed []campaigns.ScopeRepository, isSupported func(kind string) (bool, error)) ([]scopeRepo, error) {
+	repos := []scopeRepo{}
+	for start := 0; start < len(listed); start += scopeRepositoryLookupBatchSize {
+		end := start + scopeRepositoryLookupBatchSize
+		if end > len(listed) {
+			end = len(listed)
+		}
+		batch := listed[start:end]
+
+		var (
+			params []string
+			fields []string
+			vars   = map[string]interface{}{}
+		)
+		for i, r := range batch {
+			params = append(params, fmt.Sprintf("$name%d: String!", i))
+			vars[fmt.Sprintf("name%d", i)] = r.Name
+			commit := ""
+			if rev := scopeRepositoryRev(r); rev != "" {
+				params = append(params, fmt.Sprintf("$rev%d: String!", i))
+				vars[fmt.Sprintf("rev%d", i)] = rev
+				commit = fmt.Sprintf("\n\t\tcommit(rev: $rev%d) {\n\t\t\toid\n\t\t}", i)
+			}
+			fields = append(fields, fmt.Sprintf("\trepo%d: repository(name: $name%d) {\n\t\t...repositoryFields%s\n\t}", i, i, commit))
+		}
+
+		query := fmt.Sprintf(`
+query ScopeRepositories(%s) {
+%s
+}
+
+fragment repositoryFields on Repository {
+	id
+	name
+	externalRepository {
+		serviceType
+	}
+	defaultBranch {
+		name
+		target {
+			oid
+		}
+	}
+}
+`, strings.Join(params, ", "), strings.Join(fields, "\n"))
+
+		var result map[string]*scopeRepositoryLookup
+		if ok, err := client.NewRequest(query, vars).Do(ctx, &result); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, nil
+		}
+
+		for i, r := range batch {
+			repo, err := listedScopeRepo(r, result[fmt.Sprintf("repo%d", i)], isSupported)
+			if err != nil {
+				return nil, err
+			}
+			repos = append(repos, repo)
+		}
+	}
+	return repos, nil
+}
+
+// scopeRepositoryRev returns the revision the listed repository is pinned to,
+// or "" if it uses the default branch.
+func scopeRepositoryRev(r campaigns.ScopeRepository) string {
+	if r.Rev != "" {
+		return r.Rev
+	}
+	return r.Branch
+}
+
+var abbreviatedSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,39}$`)
+
+// isAbbreviatedSHA returns whether the branch of a listed repository is
+// actually an abbreviated SHA of the commit it resolved to. Only full SHAs are
+// used as revisions, anything else is a branch, which would be used as the
+// base ref of the changeset.
+func isAbbreviatedSHA(branch, commit string) bool {
+	return abbreviatedSHAPattern.MatchString(branch) && strings.HasPrefix(strings.ToLower(commit), strings.ToLower(branch))
+}
+
+// listedScopeRepo returns the repository in scope for the explicitly listed
+// repository, given what was looked up, which is nil if it doesn't exist.
+func listedScopeRepo(r campaigns.ScopeRepository, found *scopeRepositoryLookup, isSupported func(kind string) (bool, error)) (scopeRepo, error) {
+	repo := scopeRepo{ActionRepo: campaigns.ActionRepo{Name: r.Name}}
+	if found == nil {
+		repo.SkipReason = skipRepoNotFound
+		return repo, nil
+	}
+
+	repo.ID = found.ID
+	repo.Name = found.Name
+	repo.CodeHost = strings.ToLower(found.ExternalRepository.ServiceType)
+	if found.DefaultBranch != nil {
+		repo.BaseRef = found.DefaultBranch.Name
+		repo.Rev = found.DefaultBranch.Target.OID
+	}
+	if r.Branch != "" {
+		repo.BaseRef = r.BaseRef()
+	}
+	if scopeRepositoryRev(r) != "" {
+		repo.Rev = ""
+		if found.Commit != nil {
+			repo.Rev = found.Commit.OID
+		}
+	}
+
+	ok, err := isSupported(found.ExternalRepository.ServiceType)
+	if err != nil {
+		return repo, errors.Wrap(err, "failed code host check")
+	}
+	switch {
+	case !ok:
+		repo.SkipReason = unsupportedCodeHostReason(found.ExternalRepository.ServiceType)
+	case isAbbreviatedSHA(r.Branch, repo.Rev):
+		repo.SkipReason = fmt.Sprintf("%s: %s", skipAbbreviatedRev, r.Branch)
+	case repo.BaseRef == "":
+		repo.SkipReason = skipNoDefaultBranch
+	case repo.Rev == "" && scopeRepositoryRev(r) != "":
+		repo.SkipReason = fmt.Sprintf("%s: %s", skipRevNotFound, scopeRepositoryRev(r))
+	case repo.Rev == "":
+		repo.SkipReason = skipEmptyDefaultBranch
+	}
+	return repo, nil
+}
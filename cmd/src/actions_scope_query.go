@@ -1,12 +1,14 @@
 package main
 
 import (
-	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"text/tabwriter"
 
 	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
@@ -16,7 +18,11 @@ import (
 
 func init() {
 	usage := `
-List the repositories that are matched by the "scopeQuery" in an action definition. This command is meant to help with creating action definitions to be used with 'src actions exec'.
+List the repositories that are matched by the "scopeQuery", or are in the "scope", of an action definition. This command is meant to help with creating action definitions to be used with 'src actions exec'.
+
+With -format table or json, all matched repositories are listed with the default branch and revision 'src actions exec' would use, their code host, the number of matched files and why they're skipped, if they are: because they have no default branch, their code host is unsupported, they were returned more than once or, if they're listed in the "scope", they or their branch or revision don't exist.
+
+With -diff-against, only the repositories that entered or left the scope since the output of an earlier 'src actions scope-query -format json' are listed.
 
 Examples:
 
@@ -24,6 +30,15 @@ Examples:
 
 		$ src actions scope-query -f ~/run-gofmt-in-dockerfile.json
 
+  List the repositories with their default branch, revision, code host and number of matched files, including the skipped ones:
+
+		$ src actions scope-query -f ~/run-gofmt-in-dockerfile.json -format table
+
+  Save the scope as JSON and later show which repositories entered or left it:
+
+		$ src actions scope-query -f ~/run-gofmt-in-dockerfile.json -format json > scope.json
+		$ src actions scope-query -f ~/run-gofmt-in-dockerfile.json -diff-against scope.json
+
 `
 
 	flagSet := flag.NewFlagSet("scope-query", flag.ExitOnError)
@@ -36,6 +51,8 @@ Examples:
 	var (
 		fileFlag               = flagSet.String("f", "-", "The action file. If not given or '-' standard input is used. (Required)")
 		includeUnsupportedFlag = flagSet.Bool("include-unsupported", false, "When specified, also repos from unsupported codehosts are processed. Those can be created once the integration is done.")
+		formatFlag             = flagSet.String("format", "names", `The output format: "names" of the repositories in scope, a "table" or "json" of all matched repositories.`)
+		diffAgainstFlag        = flagSet.String("diff-against", "", "Only list the repositories that entered or left the scope since the JSON output of an earlier run in the given file.")
 		apiFlags               = api.NewFlags(flagSet)
 	)
 
@@ -45,6 +62,19 @@ Examples:
 			return err
 		}
 
+		switch *formatFlag {
+		case "names", "table", "json":
+		default:
+			return &usageError{errors.Errorf("invalid -format %q: must be names, table or json", *formatFlag)}
+		}
+
+		var previous *scopePreview
+		if *diffAgainstFlag != "" {
+			if previous, err = readScopePreview(*diffAgainstFlag); err != nil {
+				return err
+			}
+		}
+
 		// Read action file content.
 		var actionFile []byte
 		if *fileFlag == "-" {
@@ -56,11 +86,18 @@ Examples:
 			return err
 		}
 
+		ctx, cancel := interruptibleContext()
+		defer cancel()
+
 		// Convert action file to JSON, if it was yaml.
 		jsonActionFile, err := yaml.YAMLToJSONStrict(actionFile)
 		if err != nil {
 			return errors.Wrap(err, "unable to parse action file")
 		}
+		jsonActionFile, err = campaigns.ResolveStepBundles(ctx, jsonActionFile, actionFileDir(*fileFlag))
+		if err != nil {
+			return errors.Wrap(err, "unable to resolve step bundles")
+		}
 
 		err = campaigns.ValidateActionDefinition(jsonActionFile)
 		if err != nil {
@@ -72,27 +109,31 @@ Examples:
 			return errors.Wrap(err, "invalid JSON action file")
 		}
 
-		ctx := context.Background()
 		client := cfg.apiClient(apiFlags, flagSet.Output())
 
 		if *verbose {
-			log.Printf("# scopeQuery in action definition: %s\n", action.ScopeQuery)
+			for _, query := range action.ScopeQueries() {
+				log.Printf("# scope query in action definition: %s\n", query)
+			}
 
 			if *includeUnsupportedFlag {
 				log.Printf("# Including repositories on unsupported codehost.\n")
 			}
 		}
 
-		logger := campaigns.NewActionLogger(*verbose, false)
-		repos, err := actionRepos(ctx, client, action.ScopeQuery, *includeUnsupportedFlag, logger)
-		if err != nil {
+		repos, alerts, err := actionScopeRepos(ctx, client, &action, actionFileDir(*fileFlag), *includeUnsupportedFlag)
+		if err != nil || repos == nil {
 			return err
 		}
-		for _, repo := range repos {
-			fmt.Println(repo.Name)
+		for _, alert := range alerts {
+			printSearchAlert(alert)
 		}
 
-		return nil
+		current := newScopePreview(action.ScopeQuery, repos)
+		if previous != nil {
+			return printScopeDiff(os.Stdout, diffScopePreviews(previous, current), *formatFlag)
+		}
+		return printScopePreview(os.Stdout, current, *formatFlag)
 	}
 
 	// Register the command.
@@ -102,3 +143,158 @@ Examples:
 		usageFunc: usageFunc,
 	})
 }
+
+// scopePreview is the output of 'src actions scope-query -format json', which
+// -diff-against reads.
+type scopePreview struct {
+	ScopeQuery   string             `json:"scopeQuery,omitempty"`
+	Repositories []scopePreviewRepo `json:"repositories"`
+}
+
+type scopePreviewRepo struct {
+	Name        string `json:"name"`
+	ID          string `json:"id"`
+	CodeHost    string `json:"codeHost"`
+	BaseRef     string `json:"baseRef,omitempty"`
+	Rev         string `json:"rev,omitempty"`
+	FileMatches int    `json:"fileMatches"`
+
+	// Skipped is why the repository isn't in scope, or empty if it is.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+func newScopePreview(scopeQuery string, repos []scopeRepo) *scopePreview {
+	preview := &scopePreview{ScopeQuery: scopeQuery, Repositories: []scopePreviewRepo{}}
+	for _, repo := range repos {
+		preview.Repositories = append(preview.Repositories, scopePreviewRepo{
+			Name:        repo.Name,
+			ID:          repo.ID,
+			CodeHost:    repo.CodeHost,
+			BaseRef:     repo.BaseRef,
+			Rev:         repo.Rev,
+			FileMatches: len(repo.FileMatches),
+			Skipped:     repo.SkipReason,
+		})
+	}
+	return preview
+}
+
+func readScopePreview(path string) (*scopePreview, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var preview scopePreview
+	if err := json.Unmarshal(data, &preview); err != nil {
+		return nil, errors.Wrapf(err, "%s is not the JSON output of 'src actions scope-query -format json'", path)
+	}
+	return &preview, nil
+}
+
+// inScope returns the repositories of the preview that are in scope, by name.
+func (p *scopePreview) inScope() map[string]scopePreviewRepo {
+	repos := map[string]scopePreviewRepo{}
+	for _, repo := range p.Repositories {
+		if repo.Skipped == "" {
+			repos[repo.Name] = repo
+		}
+	}
+	return repos
+}
+
+func printScopePreview(w io.Writer, preview *scopePreview, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(preview)
+
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "REPOSITORY\tCODE HOST\tBRANCH\tREV\tMATCHES\tSKIPPED")
+		skipped := 0
+		for _, repo := range preview.Repositories {
+			if repo.Skipped != "" {
+				skipped++
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", repo.Name, repo.CodeHost, repo.BaseRef, repo.Rev, repo.FileMatches, repo.Skipped)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "\n%d repositories in scope, %d skipped.\n", len(preview.Repositories)-skipped, skipped)
+		return err
+
+	default:
+		for _, repo := range preview.Repositories {
+			if repo.Skipped == "" {
+				fmt.Fprintln(w, repo.Name)
+			}
+		}
+		return nil
+	}
+}
+
+// scopeDiff holds the repositories that entered and left the scope between
+// two runs.
+type scopeDiff struct {
+	Entering []scopePreviewRepo `json:"entering"`
+
+	// Leaving holds the repositories that are no longer in scope. If they're
+	// still matched but skipped, they're listed as matched now, with the
+	// reason they're skipped.
+	Leaving []scopePreviewRepo `json:"leaving"`
+}
+
+func diffScopePreviews(previous, current *scopePreview) scopeDiff {
+	diff := scopeDiff{Entering: []scopePreviewRepo{}, Leaving: []scopePreviewRepo{}}
+
+	before, after := previous.inScope(), current.inScope()
+	for _, repo := range current.Repositories {
+		if _, ok := before[repo.Name]; !ok && repo.Skipped == "" {
+			diff.Entering = append(diff.Entering, repo)
+		}
+	}
+
+	matched := map[string]scopePreviewRepo{}
+	for _, repo := range current.Repositories {
+		if _, ok := matched[repo.Name]; !ok {
+			matched[repo.Name] = repo
+		}
+	}
+	for _, repo := range previous.Repositories {
+		if _, ok := after[repo.Name]; ok || repo.Skipped != "" {
+			continue
+		}
+		if now, ok := matched[repo.Name]; ok {
+			repo = now
+		}
+		diff.Leaving = append(diff.Leaving, repo)
+	}
+	return diff
+}
+
+func printScopeDiff(w io.Writer, diff scopeDiff, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, repo := range diff.Entering {
+		fmt.Fprintf(tw, "+ %s\t%s@%s, %d file matches\n", repo.Name, repo.BaseRef, repo.Rev, repo.FileMatches)
+	}
+	for _, repo := range diff.Leaving {
+		reason := "no longer matched"
+		if repo.Skipped != "" {
+			reason = "skipped: " + repo.Skipped
+		}
+		fmt.Fprintf(tw, "- %s\t%s\n", repo.Name, reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d repositories entered and %d left the scope.\n", len(diff.Entering), len(diff.Leaving))
+	return err
+}
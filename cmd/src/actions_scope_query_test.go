@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffScopePreviews(t *testing.T) {
+	previous := &scopePreview{Repositories: []scopePreviewRepo{
+		{Name: "github.com/a/kept", Rev: "aaa"},
+		{Name: "github.com/a/removed", Rev: "bbb"},
+		{Name: "github.com/a/emptied", Rev: "ccc"},
+		{Name: "github.com/a/fixed", Skipped: skipNoDefaultBranch},
+	}}
+	current := &scopePreview{Repositories: []scopePreviewRepo{
+		{Name: "github.com/a/kept", Rev: "abc"},
+		{Name: "github.com/a/emptied", BaseRef: "main", Skipped: skipEmptyDefaultBranch},
+		{Name: "github.com/a/fixed", BaseRef: "main", Rev: "ddd"},
+		{Name: "github.com/a/new", BaseRef: "main", Rev: "eee", FileMatches: 2},
+	}}
+
+	want := scopeDiff{
+		Entering: []scopePreviewRepo{
+			{Name: "github.com/a/fixed", BaseRef: "main", Rev: "ddd"},
+			{Name: "github.com/a/new", BaseRef: "main", Rev: "eee", FileMatches: 2},
+		},
+		Leaving: []scopePreviewRepo{
+			{Name: "github.com/a/removed", Rev: "bbb"},
+			{Name: "github.com/a/emptied", BaseRef: "main", Skipped: skipEmptyDefaultBranch},
+		},
+	}
+	if diff := cmp.Diff(want, diffScopePreviews(previous, current)); diff != "" {
+		t.Errorf("wrong diff (-want +got):\n%s", diff)
+	}
+}
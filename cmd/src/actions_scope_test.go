@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
+
+func TestCombineScopeRepos(t *testing.T) {
+	repo := func(id, name string, files ...string) scopeRepo {
+		r := scopeRepo{ActionRepo: campaigns.ActionRepo{ID: id, Name: name, BaseRef: "refs/heads/main", Rev: "rev-" + id}, CodeHost: "github"}
+		for _, f := range files {
+			r.FileMatches = append(r.FileMatches, campaigns.FileMatch{Path: f})
+		}
+		return r
+	}
+	skippedRepo := scopeRepo{ActionRepo: campaigns.ActionRepo{ID: "4", Name: "d"}, CodeHost: "github", SkipReason: skipNoDefaultBranch}
+	results := [][]scopeRepo{
+		{repo("1", "a", "go.mod"), repo("2", "b", "go.mod"), skippedRepo},
+		{repo("2", "b", "Dockerfile", "go.mod"), repo("3", "c", "Dockerfile"), skippedRepo},
+	}
+
+	union := combineScopeRepos(results, campaigns.ScopeUnion)
+	if diff := cmp.Diff([]scopeRepo{repo("1", "a", "go.mod"), repo("2", "b", "go.mod", "Dockerfile"), repo("3", "c", "Dockerfile"), skippedRepo}, union); diff != "" {
+		t.Errorf("wrong union (-want +got):\n%s", diff)
+	}
+
+	intersection := combineScopeRepos(results, campaigns.ScopeIntersection)
+	if diff := cmp.Diff([]scopeRepo{repo("2", "b", "go.mod", "Dockerfile"), skippedRepo}, intersection); diff != "" {
+		t.Errorf("wrong intersection (-want +got):\n%s", diff)
+	}
+}
+
+func TestMergeFileMatches(t *testing.T) {
+	line := func(n int) campaigns.LineMatch { return campaigns.LineMatch{LineNumber: n, Preview: "line"} }
+	a := []campaigns.FileMatch{{Path: "go.mod", LineMatches: []campaigns.LineMatch{line(1), line(3)}}}
+	b := []campaigns.FileMatch{{Path: "go.sum"}, {Path: "go.mod", LineMatches: []campaigns.LineMatch{line(3), line(5)}}}
+
+	want := []campaigns.FileMatch{{Path: "go.mod", LineMatches: []campaigns.LineMatch{line(1), line(3), line(5)}}, {Path: "go.sum"}}
+	if diff := cmp.Diff(want, mergeFileMatches(a, b)); diff != "" {
+		t.Errorf("wrong file matches (-want +got):\n%s", diff)
+	}
+}
+
+func TestListedScopeRepos(t *testing.T) {
+	found := func(oid string) *scopeRepositoryLookup {
+		var l scopeRepositoryLookup
+		l.ID = "1"
+		l.Name = "github.com/a/a"
+		l.ExternalRepository.ServiceType = "github"
+		l.DefaultBranch = &struct {
+			Name   string
+			Target struct{ OID string }
+		}{Name: "refs/heads/main"}
+		l.DefaultBranch.Target.OID = "head"
+		if oid != "" {
+			l.Commit = &struct{ OID string }{OID: oid}
+		}
+		return &l
+	}
+	supported := func(kind string) (bool, error) { return kind == "github", nil }
+
+	tests := map[string]struct {
+		listed campaigns.ScopeRepository
+		found  *scopeRepositoryLookup
+		want   scopeRepo
+	}{
+		"default branch": {
+			listed: campaigns.ScopeRepository{Name: "github.com/a/a"},
+			found:  found(""),
+			want:   scopeRepo{ActionRepo: campaigns.ActionRepo{ID: "1", Name: "github.com/a/a", BaseRef: "refs/heads/main", Rev: "head"}, CodeHost: "github"},
+		},
+		"branch": {
+			listed: campaigns.ScopeRepository{Name: "github.com/a/a", Branch: "release"},
+			found:  found("release-head"),
+			want:   scopeRepo{ActionRepo: campaigns.ActionRepo{ID: "1", Name: "github.com/a/a", BaseRef: "refs/heads/release", Rev: "release-head"}, CodeHost: "github"},
+		},
+		"rev": {
+			listed: campaigns.ScopeRepository{Name: "github.com/a/a", Rev: "abc"},
+			found:  found("abcdef"),
+			want:   scopeRepo{ActionRepo: campaigns.ActionRepo{ID: "1", Name: "github.com/a/a", BaseRef: "refs/heads/main", Rev: "abcdef"}, CodeHost: "github"},
+		},
+		"abbreviated SHA": {
+			listed: campaigns.ScopeRepository{Name: "github.com/a/a", Branch: "abc1234"},
+			found:  found("abc1234def"),
+			want:   scopeRepo{ActionRepo: campaigns.ActionRepo{ID: "1", Name: "github.com/a/a", BaseRef: "refs/heads/abc1234", Rev: "abc1234def"}, CodeHost: "github", SkipReason: "abbreviated commit SHA, use the full SHA: abc1234"},
+		},
+		"unknown rev": {
+			listed: campaigns.ScopeRepository{Name: "github.com/a/a", Rev: "abc"},
+			found:  found(""),
+			want:   scopeRepo{ActionRepo: campaigns.ActionRepo{ID: "1", Name: "github.com/a/a", BaseRef: "refs/heads/main"}, CodeHost: "github", SkipReason: "revision not found: abc"},
+		},
+		"unknown repository": {
+			listed: campaigns.ScopeRepository{Name: "github.com/a/missing"},
+			want:   scopeRepo{ActionRepo: campaigns.ActionRepo{Name: "github.com/a/missing"}, SkipReason: skipRepoNotFound},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			have, err := listedScopeRepo(tc.listed, tc.found, supported)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, have); diff != "" {
+				t.Errorf("wrong repo (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAddScopeRepositories(t *testing.T) {
+	matched := []scopeRepo{
+		{ActionRepo: campaigns.ActionRepo{ID: "1", Name: "a", BaseRef: "refs/heads/main", Rev: "head", FileMatches: []campaigns.FileMatch{{Path: "go.mod"}}}},
+		{ActionRepo: campaigns.ActionRepo{ID: "3", Name: "c", BaseRef: "refs/heads/main", Rev: "head"}},
+	}
+	listed := []scopeRepo{
+		{ActionRepo: campaigns.ActionRepo{ID: "1", Name: "a", BaseRef: "refs/heads/release", Rev: "release-head"}},
+		{ActionRepo: campaigns.ActionRepo{ID: "2", Name: "b", BaseRef: "refs/heads/main", Rev: "head"}},
+	}
+
+	want := []scopeRepo{
+		{ActionRepo: campaigns.ActionRepo{ID: "1", Name: "a", BaseRef: "refs/heads/release", Rev: "release-head", FileMatches: []campaigns.FileMatch{{Path: "go.mod"}}}},
+		{ActionRepo: campaigns.ActionRepo{ID: "2", Name: "b", BaseRef: "refs/heads/main", Rev: "head"}},
+		{ActionRepo: campaigns.ActionRepo{ID: "3", Name: "c", BaseRef: "refs/heads/main", Rev: "head"}},
+	}
+	if diff := cmp.Diff(want, addScopeRepositories(matched, listed)); diff != "" {
+		t.Errorf("wrong repos (-want +got):\n%s", diff)
+	}
+}
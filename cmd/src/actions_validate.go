@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+	"github.com/sourcegraph/src-cli/internal/api"
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
+
+func init() {
+	usage := `
+Check an action definition without executing it. In addition to the checks 'src actions exec' does, it checks that the images and command binaries of the steps are available, that the "cacheDirs" are absolute paths and, optionally, that the "scopeQuery" is a valid search query. It also warns about risky patterns in the steps, like 'git push'.
+
+Every problem is reported with its position in the action file. The exit code is 1 if any of them is an error.
+
+Examples:
+
+  Check the action defined in ~/run-gofmt.yml:
+
+		$ src actions validate -f ~/run-gofmt.yml
+
+  Check the syntax of its scope query too, and print the problems as JSON, e.g. for an editor integration:
+
+		$ src actions validate -f ~/run-gofmt.yml -check-scope-query -format json
+
+`
+
+	flagSet := flag.NewFlagSet("validate", flag.ExitOnError)
+	usageFunc := func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of 'src actions %s':\n", flagSet.Name())
+		flagSet.PrintDefaults()
+		fmt.Println(usage)
+	}
+
+	var (
+		fileFlag             = flagSet.String("f", "-", "The action file. If not given or '-' standard input is used. (Required)")
+		formatFlag           = flagSet.String("format", "text", `The format of the reported problems: "text" or "json".`)
+		checkEnvironmentFlag = flagSet.Bool("check-environment", true, "Check that the images and command binaries of the steps are available on this machine.")
+		checkScopeQueryFlag  = flagSet.Bool("check-scope-query", false, "Check the syntax of the scope query by sending it to the Sourcegraph instance.")
+		apiFlags             = api.NewFlags(flagSet)
+	)
+
+	handler := func(args []string) error {
+		err := flagSet.Parse(args)
+		if err != nil {
+			return err
+		}
+
+		if *formatFlag != "text" && *formatFlag != "json" {
+			return &usageError{errors.Errorf("invalid -format %q: must be text or json", *formatFlag)}
+		}
+
+		// Read action file content.
+		var actionFile []byte
+		filename := *fileFlag
+		if *fileFlag == "-" {
+			actionFile, err = ioutil.ReadAll(os.Stdin)
+			filename = "<stdin>"
+		} else {
+			actionFile, err = ioutil.ReadFile(*fileFlag)
+		}
+		if err != nil {
+			return err
+		}
+
+		opts := campaigns.LintOptions{
+			CheckEnvironment: *checkEnvironmentFlag,
+			Dir:              actionFileDir(*fileFlag),
+		}
+		if *checkScopeQueryFlag {
+			client := cfg.apiClient(apiFlags, flagSet.Output())
+			opts.CheckScopeQuery = func(ctx context.Context, query string) error {
+				return checkScopeQuery(ctx, client, query)
+			}
+		}
+
+		ctx, cancel := interruptibleContext()
+		defer cancel()
+
+		diags := campaigns.LintActionDefinition(ctx, actionFile, opts)
+
+		if *formatFlag == "json" {
+			if diags == nil {
+				diags = []campaigns.LintDiagnostic{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err := enc.Encode(struct {
+				File        string                     `json:"file"`
+				Valid       bool                       `json:"valid"`
+				Diagnostics []campaigns.LintDiagnostic `json:"diagnostics"`
+			}{filename, !campaigns.HasLintErrors(diags), diags})
+			if err != nil {
+				return err
+			}
+		} else {
+			for _, d := range diags {
+				c := yellow
+				if d.Severity == campaigns.LintError {
+					c = color.New(color.FgRed)
+				}
+				position := filename
+				if d.Line > 0 {
+					position = fmt.Sprintf("%s:%d:%d", filename, d.Line, d.Column)
+				}
+				c.Printf("%s: %s\n", position, d)
+			}
+			if len(diags) == 0 {
+				fmt.Printf("%s: action definition is valid\n", filename)
+			}
+		}
+
+		if campaigns.HasLintErrors(diags) {
+			return &exitCodeError{exitCode: 1}
+		}
+		return nil
+	}
+
+	// Register the command.
+	actionsCommands = append(actionsCommands, &command{
+		flagSet:   flagSet,
+		handler:   handler,
+		usageFunc: usageFunc,
+	})
+}
+
+// checkScopeQuery returns an error if Sourcegraph rejects the scope query or
+// returns an alert for it.
+func checkScopeQuery(ctx context.Context, client api.Client, scopeQuery string) error {
+	if ok, _ := regexp.MatchString(`count:\d+`, scopeQuery); !ok {
+		scopeQuery = scopeQuery + " count:1"
+	}
+
+	query := `
+query CheckScopeQuery($query: String!) {
+	search(query: $query, version: V2) {
+		results {
+			...SearchResultsAlertFields
+		}
+	}
+}
+` + searchResultsAlertFragment
+
+	var result struct {
+		Search struct {
+			Results struct {
+				Alert searchResultsAlert
+			}
+		}
+	}
+	if ok, err := client.NewRequest(query, map[string]interface{}{
+		"query": scopeQuery,
+	}).Do(ctx, &result); err != nil || !ok {
+		return err
+	}
+
+	if alert := result.Search.Results.Alert; alert.Title != "" {
+		if alert.Description != "" {
+			return errors.Errorf("%s: %s", alert.Title, alert.Description)
+		}
+		return errors.New(alert.Title)
+	}
+	return nil
+}
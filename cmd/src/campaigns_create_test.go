@@ -1,6 +1,12 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/src-cli/internal/campaigns"
+)
 
 func TestSourcegraphVersionCheck(t *testing.T) {
 	for _, tc := range []struct {
@@ -72,3 +78,15 @@ func TestSourcegraphVersionCheck(t *testing.T) {
 		}
 	}
 }
+
+func TestCreatePatchSetFromLocalPatches(t *testing.T) {
+	patches := []campaigns.PatchInput{
+		{Repository: "UmVwb3NpdG9yeTox", RepositoryName: "github.com/sourcegraph/src-cli"},
+		{Repository: campaigns.LocalRepositoryIDPrefix + "/home/alice/src-cli", RepositoryName: "github.com/sourcegraph/src-cli"},
+	}
+	// The patches are rejected before the client is used.
+	err := createPatchSetFromPatches(context.Background(), nil, patches, nil, 100)
+	if err == nil || !strings.Contains(err.Error(), "local repositories: /home/alice/src-cli") {
+		t.Errorf("wrong error: %v", err)
+	}
+}
@@ -85,6 +85,12 @@ func parseTemplate(text string) (*template.Template, error) {
 			return humanize.Time(t), nil
 		},
 
+		// `src actions cache ls`
+		"humanizeBytes": func(n int64) string {
+			return humanize.Bytes(uint64(n))
+		},
+		"humanizeTime": humanize.Time,
+
 		// Register search-specific template functions
 		"searchSequentialLineNumber":        searchTemplateFuncs["searchSequentialLineNumber"],
 		"searchHighlightMatch":              searchTemplateFuncs["searchHighlightMatch"],
@@ -76,6 +76,15 @@ type config struct {
 	Endpoint          string            `json:"endpoint"`
 	AccessToken       string            `json:"accessToken"`
 	AdditionalHeaders map[string]string `json:"additionalHeaders"`
+
+	// RemoteCache is the shared cache of 'src actions exec', see 'src
+	// actions cache serve'.
+	RemoteCache remoteCacheConfig `json:"remoteCache"`
+}
+
+type remoteCacheConfig struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
 }
 
 // apiClient returns an api.Client built from the configuration.
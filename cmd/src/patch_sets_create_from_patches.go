@@ -2,11 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"text/template"
 
 	"github.com/mattn/go-isatty"
@@ -19,7 +19,11 @@ func init() {
 	usage := `
 Create a patch set from a set of patches (in unified diff format) to repository branches.
 
-Standard input is expected to be a JSON array of {repository: string, baseRef: string, baseRevision: string, patch: string}. The repository value is the repository's GraphQL ID (which you can look up given a repository name using 'src repos get -name=...').
+By default, standard input is expected to be a JSON array of {repository: string, baseRef: string, baseRevision: string, patch: string}. The repository value is the repository's GraphQL ID (which you can look up given a repository name using 'src repos get -name=...').
+
+Patches produced by 'src actions exec' for an action with a "changeset" also contain {changeset: {title: string, body: string, commitMessage: string, author: {name: string, email: string}}}. It's passed on to Sourcegraph instances that accept it and ignored otherwise.
+
+With -input-format, the patches can also be read in the other formats that 'src actions exec -output-format' writes, e.g. after reviewing and editing them with git tools. See 'src actions convert-patches -h'.
 
 Examples:
 
@@ -35,6 +39,14 @@ Examples:
 		$ src actions exec -f action.json > patches.json
 		$ src campaigns patchset create-from-patches < patches.json
 
+  Create a patch set from patches that were written as an mbox with 'src actions exec -output-format mbox' and edited:
+
+		$ src campaigns patchset create-from-patches -input-format mbox < patches.mbox
+
+  Create a patch set from a directory of patch files written with 'src actions exec -output-format dir':
+
+		$ src campaigns patchset create-from-patches -input-format dir -i patches
+
   Create a patch set by piping output of 'src actions exec' into 'src patchset create-from-patches':
 
 		$ src actions exec -f action.json | src patchset create-from-patches < patches.json
@@ -48,9 +60,11 @@ Examples:
 		fmt.Println(usage)
 	}
 	var (
-		patchesFlag = flagSet.Int("patches", 1000, "Returns the first n patches in the patch set.")
-		formatFlag  = flagSet.String("f", "{{friendlyPatchSetCreatedMessage .}}", `Format for the output, using the syntax of Go package text/template. (e.g. "{{.ID}}: {{len .Patches}} patches") or "{{.|json}}")`)
-		apiFlags    = api.NewFlags(flagSet)
+		inputFlag       = flagSet.String("i", "-", `The file or, for the "dir" format, the directory the patches are read from. If not given or '-' standard input is used.`)
+		inputFormatFlag = flagSet.String("input-format", campaigns.PatchFormatJSON, patchFormatUsage("The format of the patches"))
+		patchesFlag     = flagSet.Int("patches", 1000, "Returns the first n patches in the patch set.")
+		formatFlag      = flagSet.String("f", "{{friendlyPatchSetCreatedMessage .}}", `Format for the output, using the syntax of Go package text/template. (e.g. "{{.ID}}: {{len .Patches}} patches") or "{{.|json}}")`)
+		apiFlags        = api.NewFlags(flagSet)
 	)
 
 	handler := func(args []string) error {
@@ -61,13 +75,9 @@ Examples:
 			return err
 		}
 
-		if isatty.IsTerminal(os.Stdin.Fd()) {
-			log.Println("# Waiting for JSON patches input on stdin...")
-		}
-
-		var patches []campaigns.PatchInput
-		if err := json.NewDecoder(os.Stdin).Decode(&patches); err != nil {
-			return errors.Wrap(err, "invalid JSON patches input")
+		patches, err := readPatches(*inputFlag, *inputFormatFlag)
+		if err != nil {
+			return err
 		}
 
 		ctx := context.Background()
@@ -99,6 +109,16 @@ func createPatchSetFromPatches(
 	tmpl *template.Template,
 	numChangesets int,
 ) error {
+	var local []string
+	for _, p := range patches {
+		if campaigns.IsLocalRepositoryID(p.Repository) {
+			local = append(local, strings.TrimPrefix(p.Repository, campaigns.LocalRepositoryIDPrefix))
+		}
+	}
+	if len(local) > 0 {
+		return errors.Errorf("patch sets can't be created from patches of local repositories: %s", strings.Join(local, ", "))
+	}
+
 	query := createPatchSetMutation + patchSetFragment(numChangesets)
 
 	var result struct {
@@ -131,6 +151,28 @@ func createPatchSetFromPatches(
 		patches = patchesWithoutBaseRef
 	}
 
+	// Only send the changesets of the patches if the server accepts them.
+	sendChangesets := false
+	if hasChangesets(patches) {
+		sendChangesets, err = patchInputSupportsChangeset(ctx, client)
+		if err != nil {
+			return err
+		}
+		if !sendChangesets {
+			yellow.Fprintln(os.Stderr, "The Sourcegraph instance does not accept changesets in patches yet, the changesets of the patches are ignored.")
+		}
+	}
+	// The names of the repositories are only used by src.
+	patchesToSend := make([]campaigns.PatchInput, len(patches))
+	for i, p := range patches {
+		p.RepositoryName = ""
+		if !sendChangesets {
+			p.Changeset = nil
+		}
+		patchesToSend[i] = p
+	}
+	patches = patchesToSend
+
 	if ok, err := client.NewRequest(query, map[string]interface{}{
 		"patches": patches,
 	}).Do(ctx, &result); err != nil || !ok {
@@ -139,3 +181,93 @@ func createPatchSetFromPatches(
 
 	return execTemplate(tmpl, result.CreatePatchSetFromPatches)
 }
+
+// patchFormatUsage returns the usage of a flag for the format of patches.
+func patchFormatUsage(prefix string) string {
+	return prefix + `: "json" is a JSON array of patches, "dir" a directory containing a <repository>/<name>.patch file for every repository, "mbox" an mbox of 'git format-patch' style emails and "diff" a single unified diff with comment lines naming the repositories.`
+}
+
+// validatePatchFormat returns a usageError if format is not a patch format.
+func validatePatchFormat(format string) error {
+	for _, f := range campaigns.PatchFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return &usageError{fmt.Errorf("unknown patch format %q", format)}
+}
+
+// readPatches reads the patches in the given format from path, or from
+// standard input if path is "-".
+func readPatches(path, format string) ([]campaigns.PatchInput, error) {
+	if err := validatePatchFormat(format); err != nil {
+		return nil, err
+	}
+	if format == campaigns.PatchFormatDir {
+		if path == "-" {
+			return nil, &usageError{errors.New("patches in the dir format can't be read from standard input, use -i")}
+		}
+		return campaigns.ReadPatchDir(path)
+	}
+
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	} else if isatty.IsTerminal(os.Stdin.Fd()) {
+		log.Printf("# Waiting for %s patches input on stdin...", format)
+	}
+
+	patches, err := campaigns.ReadPatches(in, format)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s patches input", format)
+	}
+	return patches, nil
+}
+
+func hasChangesets(patches []campaigns.PatchInput) bool {
+	for _, p := range patches {
+		if p.Changeset != nil {
+			return true
+		}
+	}
+	return false
+}
+
+const patchInputFieldsQuery = `
+query PatchInputFields {
+  __type(name: "PatchInput") {
+    inputFields {
+      name
+    }
+  }
+}
+`
+
+// patchInputSupportsChangeset returns whether the PatchInput type of the
+// GraphQL API of the Sourcegraph instance has a changeset field.
+func patchInputSupportsChangeset(ctx context.Context, client api.Client) (bool, error) {
+	var result struct {
+		Type *struct {
+			InputFields []struct {
+				Name string
+			}
+		} `json:"__type"`
+	}
+	if ok, err := client.NewQuery(patchInputFieldsQuery).Do(ctx, &result); err != nil || !ok {
+		return false, err
+	}
+	if result.Type == nil {
+		return false, nil
+	}
+	for _, f := range result.Type.InputFields {
+		if f.Name == "changeset" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -1,11 +1,11 @@
 package campaigns
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
@@ -15,25 +15,84 @@ import (
 )
 
 type Action struct {
-	ScopeQuery string        `json:"scopeQuery,omitempty"`
-	Steps      []*ActionStep `json:"steps"`
+	ScopeQuery string              `json:"scopeQuery,omitempty"`
+	Env        map[string]EnvValue `json:"env,omitempty"`
+	Steps      []*ActionStep       `json:"steps"`
+
+	// Scope, if set instead of ScopeQuery, combines several queries and
+	// explicitly listed repositories.
+	Scope *ActionScope `json:"scope,omitempty"`
+
+	// PatchPolicy, if set, restricts the patches the action produces.
+	PatchPolicy *PatchPolicy `json:"patchPolicy,omitempty"`
+
+	// Changeset, if set, describes the changesets created from the patches.
+	// It's expanded for every repository and added to its patch.
+	Changeset *Changeset `json:"changeset,omitempty"`
 }
 
 type ActionStep struct {
-	Type      string   `json:"type"`            // "command"
-	Image     string   `json:"image,omitempty"` // Docker image
+	Type      string   `json:"type"`            // "command", "script", "docker", "podman", or any other registered StepRunner
+	Image     string   `json:"image,omitempty"` // Container image
 	CacheDirs []string `json:"cacheDirs,omitempty"`
 	Args      []string `json:"args,omitempty"`
 
+	// Script is the shell script that is executed by "script" steps.
+	Script string `json:"script,omitempty"`
+
+	// Timeout is the maximum duration of the step, e.g. "5m". If empty, only
+	// the timeout of the whole execution in a repository applies.
+	Timeout string `json:"timeout,omitempty"`
+
+	// ContainerOpts are the resource limits, network and user of the
+	// container of "docker" and "podman" steps.
+	ContainerOpts
+
+	// Env holds the environment variables that are set for the step, in
+	// addition to the ones defined for the whole action.
+	Env map[string]EnvValue `json:"env,omitempty"`
+
+	// If is the condition under which the step is executed. If nil, the
+	// step is always executed.
+	If *StepCondition `json:"if,omitempty"`
+
+	// Output is the name under which the standard output of the step is
+	// captured. Subsequent steps get it in an environment variable of the
+	// same name.
+	Output string `json:"output,omitempty"`
+
 	// ImageContentDigest is an internal field that should not be set by users.
 	ImageContentDigest string
 }
 
+// timeout returns the parsed Timeout of the step, or 0 if it has none.
+func (s *ActionStep) timeout() (time.Duration, error) {
+	if s.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s.Timeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid timeout")
+	}
+	if d <= 0 {
+		return 0, errors.Errorf("invalid timeout %q: must be positive", s.Timeout)
+	}
+	return d, nil
+}
+
 type PatchInput struct {
 	Repository   string `json:"repository"`
 	BaseRevision string `json:"baseRevision"`
 	BaseRef      string `json:"baseRef"`
 	Patch        string `json:"patch"`
+
+	// Changeset is the changeset of the action expanded for the repository,
+	// if the action defines one.
+	Changeset *Changeset `json:"changeset,omitempty"`
+
+	// RepositoryName is the name of the repository. Unlike the other fields,
+	// it's not sent to Sourcegraph.
+	RepositoryName string `json:"repositoryName,omitempty"`
 }
 
 type ActionRepo struct {
@@ -41,23 +100,62 @@ type ActionRepo struct {
 	Name    string
 	Rev     string
 	BaseRef string
+
+	// FileMatches holds the files in the repository that matched the scope
+	// query, if it searched for file contents or paths.
+	FileMatches []FileMatch `json:",omitempty"`
+
+	// LocalPath is the path of the local git repository that the action is
+	// executed in, instead of fetching the repository from Sourcegraph.
+	LocalPath string `json:",omitempty"`
 }
 
-func ValidateActionDefinition(def []byte) error {
-	sl := gojsonschema.NewSchemaLoader()
-	sc, err := sl.Compile(gojsonschema.NewStringLoader(schema.ActionSchemaJSON))
-	if err != nil {
-		return errors.Wrapf(err, "failed to compile actions schema")
+// FileMatch is a file that matched the scope query of an action.
+type FileMatch struct {
+	Path string `json:"path"`
+
+	// LineMatches holds the lines in the file that matched, if the scope
+	// query searched for file contents.
+	LineMatches []LineMatch `json:"lineMatches,omitempty"`
+}
+
+// LineMatch is a line in a file that matched the scope query of an action.
+type LineMatch struct {
+	// LineNumber is the 1-based number of the line.
+	LineNumber int    `json:"lineNumber"`
+	Preview    string `json:"preview"`
+}
+
+// actionSchema returns the JSON schema of action definitions, in which the
+// allowed step types are the ones with a registered StepRunner.
+func actionSchema() (gojsonschema.JSONLoader, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal([]byte(schema.ActionSchemaJSON), &root); err != nil {
+		return nil, err
+	}
+
+	typ := root
+	for _, key := range []string{"properties", "steps", "items", "properties", "type"} {
+		next, ok := typ[key].(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("actions schema has no %q", key)
+		}
+		typ = next
 	}
+	typ["enum"] = StepTypes()
 
+	return gojsonschema.NewGoLoader(root), nil
+}
+
+func ValidateActionDefinition(def []byte) error {
 	normalized, err := jsonxToJSON(string(def))
 	if err != nil {
 		return err
 	}
 
-	res, err := sc.Validate(gojsonschema.NewBytesLoader(normalized))
+	res, err := validateActionSchema(normalized)
 	if err != nil {
-		return errors.Wrap(err, "failed to validate config against schema")
+		return err
 	}
 
 	errs := &multierror.Error{ErrorFormat: formatValidationErrs}
@@ -68,10 +166,73 @@ func ValidateActionDefinition(def []byte) error {
 		e = strings.TrimPrefix(e, "(root): ")
 		errs = multierror.Append(errs, errors.New(e))
 	}
+	if errs.ErrorOrNil() != nil {
+		return errs
+	}
+
+	var action Action
+	if err := json.Unmarshal(normalized, &action); err != nil {
+		return errors.Wrap(err, "failed to parse action definition")
+	}
+	for _, e := range checkActionDefinition(&action) {
+		errs = multierror.Append(errs, errors.Wrap(e.err, e.path))
+	}
 
 	return errs.ErrorOrNil()
 }
 
+// validateActionSchema validates the action definition, which must be plain
+// JSON, against the actions schema.
+func validateActionSchema(def []byte) (*gojsonschema.Result, error) {
+	loader, err := actionSchema()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load actions schema")
+	}
+	sl := gojsonschema.NewSchemaLoader()
+	sc, err := sl.Compile(loader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to compile actions schema")
+	}
+
+	res, err := sc.Validate(gojsonschema.NewBytesLoader(def))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate config against schema")
+	}
+	return res, nil
+}
+
+// definitionError is an error in the value at path in an action definition,
+// e.g. "steps.0".
+type definitionError struct {
+	path string
+	err  error
+}
+
+// checkActionDefinition checks the requirements of the step runners, which
+// depend on the type of the step and can't be expressed in the schema, and
+// the values the schema can't check, like timeouts.
+func checkActionDefinition(action *Action) []definitionError {
+	var errs []definitionError
+	for i, step := range action.Steps {
+		path := fmt.Sprintf("steps.%d", i)
+		runner, err := stepRunner(step.Type)
+		if err != nil {
+			errs = append(errs, definitionError{path, err})
+			continue
+		}
+		if err := runner.Validate(step); err != nil {
+			errs = append(errs, definitionError{path, err})
+		}
+		if _, err := step.timeout(); err != nil {
+			errs = append(errs, definitionError{path, err})
+		}
+	}
+	if err := action.PatchPolicy.validate(); err != nil {
+		errs = append(errs, definitionError{"patchPolicy", err})
+	}
+	return errs
+}
+
 func formatValidationErrs(es []error) string {
 	points := make([]string, len(es))
 	for i, err := range es {
@@ -83,65 +244,31 @@ func formatValidationErrs(es []error) string {
 		strings.Join(points, "\n"))
 }
 
-func PrepareAction(ctx context.Context, action Action, logger *ActionLogger) error {
-	// Build any Docker images.
-	for _, step := range action.Steps {
-		if step.Type == "docker" {
-			// Set digests for Docker images so we don't cache action runs in 2 different images with
-			// the same tag.
-			var err error
-			step.ImageContentDigest, err = getDockerImageContentDigest(ctx, step.Image, logger)
-			if err != nil {
-				return errors.Wrap(err, "Failed to get Docker image content digest")
-			}
-		}
-	}
+func PrepareAction(ctx context.Context, action Action, retry RetryPolicy, logger *ActionLogger) error {
+	// Add the action's environment variables to the steps, so that they are
+	// part of the cache key.
+	mergeActionEnv(action)
 
-	return nil
-}
-
-// getDockerImageContentDigest gets the content digest for the image. Note that this
-// is different from the "distribution digest" (which is what you can use to specify
-// an image to `docker run`, as in `my/image@sha256:xxx`). We need to use the
-// content digest because the distribution digest is only computed for images that
-// have been pulled from or pushed to a registry. See
-// https://windsock.io/explaining-docker-image-ids/ under "A Final Twist" for a good
-// explanation.
-func getDockerImageContentDigest(ctx context.Context, image string, logger *ActionLogger) (string, error) {
-	// TODO!(sqs): is image id the right thing to use here? it is NOT the
-	// digest. but the digest is not calculated for all images (unless they are
-	// pulled/pushed from/to a registry), see
-	// https://github.com/moby/moby/issues/32016.
-	out, err := exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", "--", image).CombinedOutput()
-	if err != nil {
-		if !strings.Contains(string(out), "No such image") {
-			return "", fmt.Errorf("error inspecting docker image %q: %s", image, bytes.TrimSpace(out))
-		}
-		logger.Infof("Pulling Docker image %q...\n", image)
-		pullCmd := exec.CommandContext(ctx, "docker", "image", "pull", image)
-		prefix := fmt.Sprintf("docker image pull %s", image)
-		pullCmd.Stdout = logger.InfoPipe(prefix)
-		pullCmd.Stderr = logger.ErrorPipe(prefix)
+	// Check that the templates in the steps can be expanded before running
+	// the action in any repository.
+	if err := validateStepTemplates(action.Steps); err != nil {
+		return errors.Wrap(err, "invalid template")
+	}
+	if err := validateChangesetTemplates(action.Changeset); err != nil {
+		return errors.Wrap(err, "invalid template")
+	}
 
-		err = pullCmd.Start()
+	for i, step := range action.Steps {
+		runner, err := stepRunner(step.Type)
 		if err != nil {
-			return "", fmt.Errorf("error pulling docker image %q: %s", image, err)
+			return errors.Wrapf(err, "step %d", i)
 		}
-		err = pullCmd.Wait()
-		if err != nil {
-			return "", fmt.Errorf("error pulling docker image %q: %s", image, err)
+		if err := runner.Prepare(ctx, step, retry, logger); err != nil {
+			return err
 		}
 	}
-	out, err = exec.CommandContext(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", "--", image).CombinedOutput()
-	// This time, the image MUST be present, so the issue must be something else.
-	if err != nil {
-		return "", fmt.Errorf("error inspecting docker image %q: %s", image, bytes.TrimSpace(out))
-	}
-	id := string(bytes.TrimSpace(out))
-	if id == "" {
-		return "", fmt.Errorf("unexpected empty docker image content ID for %q", image)
-	}
-	return id, nil
+
+	return nil
 }
 
 // jsonxToJSON converts jsonx to plain JSON.
@@ -0,0 +1,330 @@
+package campaigns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// ActionTemplate is a template of an action definition, from which
+// 'src actions create' scaffolds action definitions.
+type ActionTemplate struct {
+	// Name is the name of the template. For templates loaded from files,
+	// it's the name of the file without its extension.
+	Name string `json:"-"`
+
+	Description string `json:"description,omitempty"`
+
+	// Params are the parameters of the template, which are asked for when
+	// an action definition is created from it. The definition refers to
+	// them as `${{ .Params.name }}`, like step bundles.
+	Params []ActionTemplateParam `json:"params,omitempty"`
+
+	// Definition is the YAML or JSON action definition.
+	Definition string `json:"definition"`
+
+	// Path is the file the template was loaded from, or empty if it's built
+	// in.
+	Path string `json:"-"`
+}
+
+// ActionTemplateParam is a parameter of an ActionTemplate.
+type ActionTemplateParam struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// Default is the value of the parameter if none is given. Parameters
+	// without a default are required.
+	Default *string `json:"default,omitempty"`
+}
+
+// UserConfigDir returns the directory in the user's config directory that
+// contains the configuration of src, like the action templates.
+func UserConfigDir() (string, error) {
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userConfigDir, "sourcegraph-src"), nil
+}
+
+// DefaultActionTemplate is the name of the template that is used if none is
+// given.
+const DefaultActionTemplate = "hello-world"
+
+// LoadActionTemplates returns the built-in templates and the templates in the
+// *.yml, *.yaml and *.json files in dir, which replace built-in templates
+// with the same name, sorted by name. If dir is empty or doesn't exist, only
+// the built-in templates are returned.
+func LoadActionTemplates(dir string) ([]ActionTemplate, error) {
+	byName := make(map[string]ActionTemplate, len(builtinActionTemplates))
+	for _, t := range builtinActionTemplates {
+		byName[t.Name] = t
+	}
+
+	if dir != "" {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "reading action templates")
+		}
+		for _, f := range files {
+			ext := filepath.Ext(f.Name())
+			if f.IsDir() || ext != ".yml" && ext != ".yaml" && ext != ".json" {
+				continue
+			}
+			t, err := loadActionTemplate(filepath.Join(dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			byName[t.Name] = t
+		}
+	}
+
+	templates := make([]ActionTemplate, 0, len(byName))
+	for _, t := range byName {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+func loadActionTemplate(path string) (ActionTemplate, error) {
+	var t ActionTemplate
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return t, errors.Wrap(err, "reading action template")
+	}
+	jsonData, err := yaml.YAMLToJSONStrict(data)
+	if err != nil {
+		return t, errors.Wrapf(err, "unable to parse action template %s", path)
+	}
+	if err := json.Unmarshal(jsonData, &t); err != nil {
+		return t, errors.Wrapf(err, "invalid action template %s", path)
+	}
+	if t.Definition == "" {
+		return t, errors.Errorf("action template %s has no definition", path)
+	}
+	t.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	t.Path = path
+	return t, nil
+}
+
+// Render returns the action definition of the template in which the
+// parameters are replaced by the given values or their defaults. Other
+// templates, like `${{ .Repository.Name }}`, are left for the execution.
+func (t ActionTemplate) Render(values map[string]string) ([]byte, error) {
+	declared := make(map[string]StepBundleParam, len(t.Params))
+	for _, p := range t.Params {
+		declared[p.Name] = StepBundleParam{Description: p.Description, Default: p.Default}
+	}
+	params, err := bundleParams(StepBundle{Params: declared}, values)
+	if err != nil {
+		return nil, errors.Wrapf(err, "action template %s", t.Name)
+	}
+
+	def, err := expandBundleParams(t.Definition, params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "action template %s", t.Name)
+	}
+
+	// The values are pasted into the definition as they are, to keep its
+	// formatting, unless that changes its structure, e.g. because a value
+	// contains quotes or ": ". Then the parameters are replaced in the parsed
+	// definition, which is marshaled again.
+	parsed, err := parseYAMLValue([]byte(t.Definition))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse action template %s", t.Name)
+	}
+	want, err := expandBundleParams(parsed, params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "action template %s", t.Name)
+	}
+	if have, err := parseYAMLValue([]byte(def.(string))); err == nil && reflect.DeepEqual(have, want) {
+		return []byte(def.(string)), nil
+	}
+	return yaml.Marshal(want)
+}
+
+func parseYAMLValue(data []byte) (interface{}, error) {
+	jsonData, err := yaml.YAMLToJSONStrict(data)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	return v, json.Unmarshal(jsonData, &v)
+}
+
+func stringPtr(s string) *string { return &s }
+
+var builtinActionTemplates = []ActionTemplate{
+	{
+		Name:        "go-mod-tidy",
+		Description: "Run 'go mod tidy' in all Go modules",
+		Params: []ActionTemplateParam{
+			{Name: "goVersion", Description: "The version of Go", Default: stringPtr("1.14")},
+		},
+		Definition: `scopeQuery: repohasfile:(^|/)go\.mod$
+
+steps:
+  - type: docker
+    image: golang:${{ .Params.goVersion }}
+    args:
+      - sh
+      - -c
+      - for mod in $(find . -name go.mod -not -path './vendor/*'); do (cd "$(dirname "$mod")" && go mod tidy); done
+    cacheDirs:
+      - /go/pkg/mod
+
+changeset:
+  title: Run go mod tidy
+  body: This removes unused dependencies from go.mod and go.sum with 'go mod tidy'.
+`,
+	},
+	{
+		Name:        "gofmt",
+		Description: "Format all Go files with gofmt",
+		Params: []ActionTemplateParam{
+			{Name: "goVersion", Description: "The version of Go", Default: stringPtr("1.14")},
+		},
+		Definition: `scopeQuery: lang:go
+
+steps:
+  - type: docker
+    image: golang:${{ .Params.goVersion }}-alpine
+    args: ["sh", "-c", "gofmt -s -w $(find . -name '*.go' -not -path './vendor/*')"]
+
+changeset:
+  title: Format Go files with gofmt
+  body: This formats all Go files with 'gofmt -s'.
+`,
+	},
+	{
+		Name:        DefaultActionTemplate,
+		Description: "Run a command that prints 'Hello world'",
+		Params: []ActionTemplateParam{
+			{Name: "scopeQuery", Description: "The search query that matches the repositories", Default: stringPtr("repohasfile:README.md")},
+		},
+		Definition: `scopeQuery: ${{ .Params.scopeQuery }}
+
+steps:
+  - type: command
+    args:
+    - echo
+    - Hello world
+`,
+	},
+	{
+		Name:        "license-header",
+		Description: "Add a license header to all source files that don't have one",
+		Params: []ActionTemplateParam{
+			{Name: "header", Description: "The license header, e.g. 'Copyright 2020 Example Inc. All rights reserved.'"},
+			{Name: "extension", Description: "The extension of the source files", Default: stringPtr("go")},
+			{Name: "comment", Description: "The line comment prefix of the source files", Default: stringPtr("//")},
+		},
+		Definition: `scopeQuery: file:\.${{ .Params.extension }}$ -content:"${{ .Params.header }}"
+
+steps:
+  - type: docker
+    image: alpine:3
+    env:
+      HEADER: ${{ .Params.header }}
+      EXTENSION: ${{ .Params.extension }}
+      COMMENT: ${{ .Params.comment }}
+    args:
+      - sh
+      - -c
+      - |
+        find . -name "*.$EXTENSION" -not -path './vendor/*' -not -path './node_modules/*' | while read -r f; do
+          grep -qF -e "$HEADER" "$f" && continue
+          { printf '%s %s\n\n' "$COMMENT" "$HEADER"; cat "$f"; } > "$f.tmp" && mv "$f.tmp" "$f"
+        done
+
+changeset:
+  title: Add license header
+  body: This adds the license header to all source files that don't have one.
+`,
+	},
+	{
+		Name:        "npm-upgrade",
+		Description: "Upgrade an npm package in all packages that depend on it",
+		Params: []ActionTemplateParam{
+			{Name: "package", Description: "The npm package to upgrade"},
+			{Name: "version", Description: "The version to upgrade to", Default: stringPtr("latest")},
+			{Name: "nodeVersion", Description: "The version of Node.js", Default: stringPtr("14")},
+		},
+		Definition: `scopeQuery: file:(^|/)package\.json$ "${{ .Params.package }}"
+
+steps:
+  - type: docker
+    image: node:${{ .Params.nodeVersion }}
+    env:
+      PACKAGE: ${{ .Params.package }}
+      VERSION: ${{ .Params.version }}
+    args:
+      - sh
+      - -c
+      - for dir in $(dirname ${{ join .Repository.FileMatches " " }} | sort -u); do (cd "$dir" && npm install --package-lock-only --ignore-scripts "$PACKAGE@$VERSION"); done
+    cacheDirs:
+      - /root/.npm
+
+changeset:
+  title: Upgrade ${{ .Params.package }} to ${{ .Params.version }}
+  body: This upgrades ${{ .Params.package }} to ${{ .Params.version }} in all packages that depend on it.
+`,
+	},
+	{
+		Name:        "prettier",
+		Description: "Format files with prettier",
+		Params: []ActionTemplateParam{
+			{Name: "pattern", Description: "The glob pattern of the files to format", Default: stringPtr("**/*.{js,jsx,ts,tsx}")},
+			{Name: "nodeVersion", Description: "The version of Node.js", Default: stringPtr("14")},
+		},
+		Definition: `scopeQuery: repohasfile:(^|/)package\.json$
+
+steps:
+  - type: docker
+    image: node:${{ .Params.nodeVersion }}
+    args: ["npx", "prettier@2", "--write", "${{ .Params.pattern }}", "!**/node_modules/**"]
+    cacheDirs:
+      - /root/.npm
+
+changeset:
+  title: Format files with prettier
+  body: This formats the files matching '${{ .Params.pattern }}' with prettier.
+`,
+	},
+	{
+		Name:        "sed-replace",
+		Description: "Replace a regular expression in all files that match it",
+		Params: []ActionTemplateParam{
+			{Name: "pattern", Description: "The extended regular expression to replace, e.g. 'oldName\\('"},
+			{Name: "replacement", Description: "The replacement, which can refer to groups in the pattern as \\1, \\2, ..."},
+		},
+		Definition: `scopeQuery: patterntype:regexp ${{ .Params.pattern }}
+
+steps:
+  - type: docker
+    image: alpine:3
+    env:
+      PATTERN: ${{ .Params.pattern }}
+      REPLACEMENT: ${{ .Params.replacement }}
+    args:
+      - sh
+      - -c
+      - |
+        d=$(printf '\001')
+        sed -i -E "s$d$PATTERN$d$REPLACEMENT${d}g" ${{ join .Repository.FileMatches " " }}
+
+changeset:
+  title: Replace ${{ .Params.pattern }}
+  body: This replaces '${{ .Params.pattern }}' with '${{ .Params.replacement }}'.
+`,
+	},
+}
@@ -0,0 +1,237 @@
+package campaigns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+func TestBuiltinActionTemplates(t *testing.T) {
+	templates, err := LoadActionTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]string{
+		"header":      "Copyright 2020 Example Inc.",
+		"package":     "lodash",
+		"pattern":     `oldName\(`,
+		"replacement": "newName(",
+	}
+	names := make([]string, 0, len(templates))
+	for _, tmpl := range templates {
+		names = append(names, tmpl.Name)
+		t.Run(tmpl.Name, func(t *testing.T) {
+			given := map[string]string{}
+			for _, p := range tmpl.Params {
+				if v, ok := values[p.Name]; ok && p.Default == nil {
+					given[p.Name] = v
+				}
+			}
+			def, err := tmpl.Render(given)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(def), ".Params.") {
+				t.Errorf("parameters not replaced:\n%s", def)
+			}
+
+			jsonDef, err := yaml.YAMLToJSONStrict(def)
+			if err != nil {
+				t.Fatalf("invalid YAML: %s\n%s", err, def)
+			}
+			if err := ValidateActionDefinition(jsonDef); err != nil {
+				t.Fatalf("invalid action definition: %s\n%s", err, def)
+			}
+		})
+	}
+
+	if have, want := strings.Join(names, " "), "go-mod-tidy gofmt hello-world license-header npm-upgrade prettier sed-replace"; have != want {
+		t.Errorf("wrong templates: have %q; want %q", have, want)
+	}
+}
+
+func TestBuiltinActionTemplatesQuoting(t *testing.T) {
+	values := map[string]string{
+		"header":      `Copyright 2020 O'Reilly "Media": all/rights # reserved`,
+		"comment":     "#",
+		"package":     "@example/it's",
+		"version":     "1.0.0: $(false)",
+		"pattern":     `a/b'c`,
+		"replacement": `x/"y" & $HOME`,
+	}
+
+	render := func(t *testing.T, name string) Action {
+		templates, err := LoadActionTemplates("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tmpl := range templates {
+			if tmpl.Name != name {
+				continue
+			}
+			given := map[string]string{}
+			for _, p := range tmpl.Params {
+				if v, ok := values[p.Name]; ok {
+					given[p.Name] = v
+				}
+			}
+			def, err := tmpl.Render(given)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jsonDef, err := yaml.YAMLToJSONStrict(def)
+			if err != nil {
+				t.Fatalf("invalid YAML: %s\n%s", err, def)
+			}
+			if err := ValidateActionDefinition(jsonDef); err != nil {
+				t.Fatalf("invalid action definition: %s\n%s", err, def)
+			}
+			var action Action
+			if err := json.Unmarshal(jsonDef, &action); err != nil {
+				t.Fatal(err)
+			}
+			return action
+		}
+		t.Fatalf("template %s not found", name)
+		return Action{}
+	}
+
+	// runScript runs the "sh -c" script of the first step of the action in
+	// dir, with the environment variables of the step.
+	runScript := func(t *testing.T, action Action, dir string, files ...string) {
+		step := action.Steps[0]
+		script := strings.Replace(step.Args[2], `${{ join .Repository.FileMatches " " }}`, strings.Join(files, " "), -1)
+		cmd := exec.Command("sh", "-c", script)
+		cmd.Dir = dir
+		cmd.Env = os.Environ()
+		for name, value := range step.Env {
+			cmd.Env = append(cmd.Env, name+"="+value.Value)
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("script failed: %s\n%s", err, out)
+		}
+	}
+
+	for _, name := range []string{"npm-upgrade", "prettier", "hello-world", "go-mod-tidy", "gofmt"} {
+		t.Run(name, func(t *testing.T) {
+			action := render(t, name)
+			for _, step := range action.Steps {
+				for _, arg := range step.Args {
+					if strings.Contains(arg, "'s") || strings.Contains(arg, "$(false)") {
+						t.Errorf("parameter pasted into arguments: %q", arg)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("license-header", func(t *testing.T) {
+		action := render(t, "license-header")
+		dir, err := ioutil.TempDir("", "license-header")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			runScript(t, action, dir)
+		}
+		have, err := ioutil.ReadFile(filepath.Join(dir, "main.go"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "# " + values["header"] + "\n\npackage main\n"; string(have) != want {
+			t.Errorf("wrong file:\nhave %q\nwant %q", have, want)
+		}
+	})
+
+	t.Run("sed-replace", func(t *testing.T) {
+		action := render(t, "sed-replace")
+		dir, err := ioutil.TempDir("", "sed-replace")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("1 a/b'c 2\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		runScript(t, action, dir, "file.txt")
+		have, err := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "1 x/\"y\" a/b'c $HOME 2\n"; string(have) != want {
+			t.Errorf("wrong file:\nhave %q\nwant %q", have, want)
+		}
+	})
+}
+
+func TestLoadActionTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "action-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"gofmt.yml": `description: Our gofmt
+definition: |
+  scopeQuery: lang:go repo:^github\.com/example/
+  steps:
+    - type: command
+      args: [gofmt, -w, .]
+`,
+		"rename.json": `{
+  "description": "Rename an identifier",
+  "params": [{"name": "from"}, {"name": "to", "default": "newName"}],
+  "definition": "{\"scopeQuery\": \"${{ .Params.from }}\", \"steps\": [{\"type\": \"command\", \"args\": [\"gofmt\", \"-r\", \"${{ .Params.from }} -> ${{ .Params.to }}\", \"-w\", \".\"]}]}"
+}`,
+		"README.md": "Not a template.",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templates, err := LoadActionTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]ActionTemplate{}
+	for _, tmpl := range templates {
+		byName[tmpl.Name] = tmpl
+	}
+	if len(templates) != len(builtinActionTemplates)+1 {
+		t.Errorf("wrong number of templates: have %d; want %d", len(templates), len(builtinActionTemplates)+1)
+	}
+	if have := byName["gofmt"]; have.Description != "Our gofmt" || have.Path != filepath.Join(dir, "gofmt.yml") {
+		t.Errorf("built-in template not replaced: %+v", have)
+	}
+
+	def, err := byName["rename"].Render(map[string]string{"from": "oldName"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"args": ["gofmt", "-r", "oldName -> newName", "-w", "."]`; !strings.Contains(string(def), want) {
+		t.Errorf("wrong definition: %s does not contain %s", def, want)
+	}
+	if _, err := byName["rename"].Render(nil); err == nil || !strings.Contains(err.Error(), "missing required parameters: from") {
+		t.Errorf("wrong error for missing parameter: %v", err)
+	}
+
+	if _, err := LoadActionTemplates(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("unexpected error for missing dir: %s", err)
+	}
+}
@@ -0,0 +1,287 @@
+package campaigns
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ActionID returns the identifier of the action that the entries of the
+// execution cache and the cacheDirs volumes it creates are recorded with. It
+// must be computed from the action as it is defined, before
+// ApplyContainerDefaults and PrepareAction modify it.
+func ActionID(action Action) (string, error) {
+	data, err := json.Marshal(action)
+	if err != nil {
+		return "", err
+	}
+	b := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(b[:9]), nil
+}
+
+// CacheDirVolumes holds the host directories that are mounted at the
+// "cacheDirs" of container steps. They persist across runs of an action in a
+// repository, which is useful for (e.g.) yarn and npm caches.
+type CacheDirVolumes struct {
+	// Dir is the directory the volumes are stored in. If empty,
+	// "action-exec-cache-dir" in UserCacheDir is used.
+	Dir string
+
+	// Action is the ActionID recorded with the volumes.
+	Action string
+}
+
+type volumeMeta struct {
+	Repo   string `json:"repo"`
+	Rev    string `json:"rev"`
+	Image  string `json:"image"`
+	Action string `json:"action,omitempty"`
+}
+
+func (v CacheDirVolumes) dir() (string, error) {
+	if v.Dir != "" {
+		return v.Dir, nil
+	}
+	baseCacheDir, err := UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(baseCacheDir, "action-exec-cache-dir"), nil
+}
+
+// hostDir returns the host directory that is mounted at cacheDir in the
+// container of the given image. It records the volume's repository, image
+// and action next to it, which also marks it as used.
+func (v CacheDirVolumes) hostDir(image, repoName, rev, cacheDir string) (string, error) {
+	dir, err := v.dir()
+	if err != nil {
+		return "", err
+	}
+	b := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%s", image, repoName, rev)))
+	key := base64.RawURLEncoding.EncodeToString(b[:16])
+
+	hostDir := filepath.Join(dir, key, strings.TrimPrefix(cacheDir, string(os.PathSeparator)))
+	if err := os.MkdirAll(hostDir, 0700); err != nil {
+		return "", err
+	}
+
+	// The metadata is stored next to the volume, not in it, so that it
+	// can't clash with a cache dir.
+	data, err := json.Marshal(volumeMeta{Repo: repoName, Rev: rev, Image: image, Action: v.Action})
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, key+".json"), data, 0600); err != nil {
+		return "", err
+	}
+	return hostDir, nil
+}
+
+// Kinds of CacheEntry.
+const (
+	CacheEntryResult = "result"
+	CacheEntryVolume = "volume"
+)
+
+// CacheEntry is an entry of the execution cache: either the cached result of
+// executing an action in a repository or the cacheDirs volume of a container
+// step.
+type CacheEntry struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+
+	// Repo, Action and Image are empty for entries that were written by
+	// older versions of src.
+	Repo   string `json:"repo,omitempty"`
+	Action string `json:"action,omitempty"`
+	Image  string `json:"image,omitempty"`
+
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"lastUsed"`
+
+	paths []string
+}
+
+// CachePolicy is the eviction policy of the execution cache. Zero values
+// mean no limit.
+type CachePolicy struct {
+	// MaxAge is the duration after which entries that haven't been used
+	// are evicted.
+	MaxAge time.Duration
+
+	// MaxSize is the maximum total size in bytes of all entries. The least
+	// recently used entries are evicted until the cache fits.
+	MaxSize int64
+}
+
+// CacheManager lists and evicts the entries of an ExecutionDiskCache and of
+// CacheDirVolumes.
+type CacheManager struct {
+	Results ExecutionDiskCache
+	Volumes CacheDirVolumes
+}
+
+// Entries returns all entries, most recently used first.
+func (m CacheManager) Entries() ([]CacheEntry, error) {
+	results, err := m.resultEntries()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing cached results")
+	}
+	volumes, err := m.volumeEntries()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing cacheDirs volumes")
+	}
+
+	entries := append(results, volumes...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+	return entries, nil
+}
+
+func (m CacheManager) resultEntries() ([]CacheEntry, error) {
+	infos, err := readDirIfExists(m.Results.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CacheEntry
+	for _, fi := range infos {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(m.Results.Dir, fi.Name())
+		entry := CacheEntry{
+			Kind:     CacheEntryResult,
+			Key:      strings.TrimSuffix(fi.Name(), ".json"),
+			Size:     fi.Size(),
+			LastUsed: fi.ModTime(),
+			paths:    []string{path},
+		}
+		if data, err := ioutil.ReadFile(path); err == nil {
+			var e diskCacheEntry
+			if json.Unmarshal(data, &e) == nil {
+				entry.Repo, entry.Action = e.Repo, e.Action
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (m CacheManager) volumeEntries() ([]CacheEntry, error) {
+	dir, err := m.Volumes.dir()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := readDirIfExists(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []CacheEntry
+	for _, fi := range infos {
+		if !fi.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		size, err := dirSize(path)
+		if err != nil {
+			return nil, err
+		}
+		entry := CacheEntry{
+			Kind:     CacheEntryVolume,
+			Key:      fi.Name(),
+			Size:     size,
+			LastUsed: fi.ModTime(),
+			paths:    []string{path},
+		}
+		metaPath := path + ".json"
+		if mfi, err := os.Stat(metaPath); err == nil {
+			entry.LastUsed = mfi.ModTime()
+			entry.paths = append(entry.paths, metaPath)
+			if data, err := ioutil.ReadFile(metaPath); err == nil {
+				var meta volumeMeta
+				if json.Unmarshal(data, &meta) == nil {
+					entry.Repo, entry.Action, entry.Image = meta.Repo, meta.Action, meta.Image
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Remove removes the given entries from the cache.
+func (m CacheManager) Remove(entries []CacheEntry) error {
+	for _, entry := range entries {
+		for _, path := range entry.paths {
+			if err := os.RemoveAll(path); err != nil {
+				return errors.Wrapf(err, "removing %s %s", entry.Kind, entry.Key)
+			}
+		}
+	}
+	return nil
+}
+
+// Prune evicts the entries that the policy doesn't allow anymore at the given
+// time and returns them.
+func (m CacheManager) Prune(policy CachePolicy, now time.Time) ([]CacheEntry, error) {
+	entries, err := m.Entries()
+	if err != nil {
+		return nil, err
+	}
+	evicted := policy.Evict(entries, now)
+	return evicted, m.Remove(evicted)
+}
+
+// Evict returns the entries, sorted by last use as returned by
+// CacheManager.Entries, that the policy doesn't allow anymore at the given
+// time.
+func (p CachePolicy) Evict(entries []CacheEntry, now time.Time) []CacheEntry {
+	var (
+		evicted []CacheEntry
+		size    int64
+		full    bool
+	)
+	for _, entry := range entries {
+		// Entries are sorted by last use, so once the cache exceeds its
+		// maximum size all less recently used entries are evicted.
+		full = full || (p.MaxSize > 0 && size+entry.Size > p.MaxSize)
+		if full || (p.MaxAge > 0 && now.Sub(entry.LastUsed) > p.MaxAge) {
+			evicted = append(evicted, entry)
+			continue
+		}
+		size += entry.Size
+	}
+	return evicted
+}
+
+func readDirIfExists(dir string) ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return infos, err
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,146 @@
+package campaigns
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCacheManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	manager := CacheManager{
+		Results: ExecutionDiskCache{Dir: filepath.Join(dir, "results"), Action: "action-a"},
+		Volumes: CacheDirVolumes{Dir: filepath.Join(dir, "volumes"), Action: "action-a"},
+	}
+
+	keyA := ExecutionCacheKey{Repo: ActionRepo{Name: "github.com/a/a", Rev: "a"}}
+	keyB := ExecutionCacheKey{Repo: ActionRepo{Name: "github.com/b/b", Rev: "b"}}
+	resultA := PatchInput{Repository: "a", BaseRevision: "a", Patch: "diff a"}
+	if err := manager.Results.Set(ctx, keyA, resultA); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Results.Set(ctx, keyB, PatchInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	volume, err := manager.Volumes.hostDir("alpine:3", "github.com/a/a", "a", "/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(volume, "data"), make([]byte, 100), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Results written by older versions of src only contain the PatchInput.
+	legacy, err := manager.Results.cacheFilePath(ExecutionCacheKey{Repo: ActionRepo{Name: "github.com/c/c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(legacy, []byte(`{"repository":"c","patch":"diff c"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give every entry a distinct last use, a is the most recently used.
+	now := time.Now().Truncate(time.Second)
+	for path, age := range map[string]time.Duration{
+		mustCacheFilePath(t, manager.Results, keyA): 1 * time.Hour,
+		filepath.Dir(volume) + ".json":              2 * time.Hour,
+		mustCacheFilePath(t, manager.Results, keyB): 3 * time.Hour,
+		legacy: 4 * time.Hour,
+	} {
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := manager.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type entry struct{ Kind, Repo, Action, Image string }
+	summarize := func(entries []CacheEntry) []entry {
+		var s []entry
+		for _, e := range entries {
+			s = append(s, entry{e.Kind, e.Repo, e.Action, e.Image})
+		}
+		return s
+	}
+	want := []entry{
+		{CacheEntryResult, "github.com/a/a", "action-a", ""},
+		{CacheEntryVolume, "github.com/a/a", "action-a", "alpine:3"},
+		{CacheEntryResult, "github.com/b/b", "action-a", ""},
+		{CacheEntryResult, "", "", ""},
+	}
+	if diff := cmp.Diff(want, summarize(entries)); diff != "" {
+		t.Fatalf("wrong entries (-want +got):\n%s", diff)
+	}
+	if entries[1].Size != 100 {
+		t.Errorf("wrong volume size %d", entries[1].Size)
+	}
+
+	// Legacy entries can still be read, which marks them as used.
+	result, ok, err := manager.Results.Get(ctx, ExecutionCacheKey{Repo: ActionRepo{Name: "github.com/c/c"}})
+	if err != nil || !ok {
+		t.Fatalf("legacy entry not found: %v", err)
+	}
+	if diff := cmp.Diff(PatchInput{Repository: "c", Patch: "diff c"}, result); diff != "" {
+		t.Errorf("wrong legacy result (-want +got):\n%s", diff)
+	}
+	if fi, err := os.Stat(legacy); err != nil || !fi.ModTime().After(now.Add(-time.Minute)) {
+		t.Errorf("legacy entry not marked as used")
+	}
+	if err := os.Chtimes(legacy, now.Add(-4*time.Hour), now.Add(-4*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		policy CachePolicy
+		want   []entry
+	}{
+		"no limits": {CachePolicy{}, nil},
+		"max age":   {CachePolicy{MaxAge: 150 * time.Minute}, want[2:]},
+		"max size":  {CachePolicy{MaxSize: entries[0].Size + entries[1].Size}, want[2:]},
+		"both":      {CachePolicy{MaxAge: 210 * time.Minute, MaxSize: entries[0].Size}, want[1:]},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, summarize(tc.policy.Evict(entries, now))); diff != "" {
+				t.Errorf("wrong evicted entries (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	evicted, err := manager.Prune(CachePolicy{MaxAge: 90 * time.Minute}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want[1:], summarize(evicted)); diff != "" {
+		t.Errorf("wrong evicted entries (-want +got):\n%s", diff)
+	}
+	for _, path := range []string{filepath.Dir(volume), filepath.Dir(volume) + ".json", legacy} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", path)
+		}
+	}
+	if _, ok, err := manager.Results.Get(ctx, keyA); err != nil || !ok {
+		t.Errorf("entry of github.com/a/a was evicted: %v", err)
+	}
+}
+
+func mustCacheFilePath(t *testing.T, c ExecutionDiskCache, key ExecutionCacheKey) string {
+	path, err := c.cacheFilePath(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
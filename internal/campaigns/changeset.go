@@ -0,0 +1,80 @@
+package campaigns
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Changeset describes the changeset that is created from the patch produced
+// in a repository. In action definitions, its fields can contain templates
+// like the args of steps, which are expanded for every repository.
+type Changeset struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+
+	// CommitMessage is the message of the commit that contains the patch. If
+	// empty, the title is used.
+	CommitMessage string `json:"commitMessage,omitempty"`
+
+	// Author is the author of the commit. If nil, the server decides.
+	Author *ChangesetAuthor `json:"author,omitempty"`
+}
+
+// ChangesetAuthor is the author of the commit of a changeset.
+type ChangesetAuthor struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// templates returns pointers to the fields of the changeset that can contain
+// templates.
+func (c *Changeset) templates() []*string {
+	fields := []*string{&c.Title, &c.Body, &c.CommitMessage}
+	if c.Author != nil {
+		fields = append(fields, &c.Author.Name, &c.Author.Email)
+	}
+	return fields
+}
+
+// validateChangesetTemplates checks that the templates in the changeset can be
+// parsed. A nil changeset is valid.
+func validateChangesetTemplates(c *Changeset) error {
+	if c == nil {
+		return nil
+	}
+	for _, s := range c.templates() {
+		if !isTemplate(*s) {
+			continue
+		}
+		if _, err := parseTemplate(*s); err != nil {
+			return errors.Wrap(err, "changeset")
+		}
+	}
+	return nil
+}
+
+// expandChangeset returns a copy of the changeset in which the templates are
+// expanded for the given repository, or nil if c is nil.
+func expandChangeset(c *Changeset, repo ActionRepo) (*Changeset, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	expanded := *c
+	if c.Author != nil {
+		author := *c.Author
+		expanded.Author = &author
+	}
+
+	data := newStepTemplateData(repo)
+	for _, s := range expanded.templates() {
+		v, err := expandTemplate(*s, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "changeset")
+		}
+		*s = v
+	}
+	if expanded.CommitMessage == "" {
+		expanded.CommitMessage = expanded.Title
+	}
+	return &expanded, nil
+}
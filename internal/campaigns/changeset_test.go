@@ -0,0 +1,43 @@
+package campaigns
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpandChangeset(t *testing.T) {
+	repo := ActionRepo{Name: "github.com/sourcegraph/src-cli", BaseRef: "refs/heads/master"}
+	changeset := &Changeset{
+		Title:  "Run gofmt in ${{ .Repository.Name }}",
+		Body:   "Formats the Go code on ${{ replace .Repository.BaseRef \"refs/heads/\" \"\" -1 }}.",
+		Author: &ChangesetAuthor{Name: "Sourcegraph", Email: "campaigns@sourcegraph.com"},
+	}
+
+	expanded, err := expandChangeset(changeset, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Changeset{
+		Title:         "Run gofmt in github.com/sourcegraph/src-cli",
+		Body:          "Formats the Go code on master.",
+		CommitMessage: "Run gofmt in github.com/sourcegraph/src-cli",
+		Author:        &ChangesetAuthor{Name: "Sourcegraph", Email: "campaigns@sourcegraph.com"},
+	}
+	if diff := cmp.Diff(want, expanded); diff != "" {
+		t.Errorf("wrong expanded changeset (-want +got):\n%s", diff)
+	}
+
+	// The changeset of the action must not be modified.
+	if changeset.CommitMessage != "" || changeset.Author == expanded.Author {
+		t.Errorf("changeset was modified: %+v", changeset)
+	}
+
+	if expanded, err := expandChangeset(nil, repo); err != nil || expanded != nil {
+		t.Errorf("wrong result for nil changeset: %+v, %v", expanded, err)
+	}
+	if err := validateChangesetTemplates(&Changeset{Title: "${{ .Repository.Name"}); err == nil {
+		t.Error("unexpected nil error for unterminated template")
+	}
+}
@@ -0,0 +1,92 @@
+package campaigns
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// StepCondition describes when a step of an action is executed. All of the
+// conditions that are set must be met.
+type StepCondition struct {
+	// PreviousStepChangedFiles requires the previous step to have changed
+	// (true) or not changed (false) files in the repository.
+	PreviousStepChangedFiles *bool `json:"previousStepChangedFiles,omitempty"`
+
+	// FileExists is a path, relative to the repository root, that must
+	// exist.
+	FileExists string `json:"fileExists,omitempty"`
+
+	// RepoMatches is a glob pattern that the repository name must match.
+	RepoMatches string `json:"repoMatches,omitempty"`
+
+	// OutputNotEmpty is the name of an output of a previous step that must
+	// not be empty.
+	OutputNotEmpty string `json:"outputNotEmpty,omitempty"`
+}
+
+// conditionEnv is the state of an action run against which step conditions
+// are evaluated.
+type conditionEnv struct {
+	repoName                 string
+	volumeDir                string
+	previousStepChangedFiles bool
+	outputs                  map[string]string
+}
+
+// evaluate returns whether the condition is met and, if it isn't, why.
+func (c *StepCondition) evaluate(env conditionEnv) (bool, string, error) {
+	if c == nil {
+		return true, "", nil
+	}
+
+	if c.PreviousStepChangedFiles != nil && *c.PreviousStepChangedFiles != env.previousStepChangedFiles {
+		if env.previousStepChangedFiles {
+			return false, "previous step changed files", nil
+		}
+		return false, "previous step did not change files", nil
+	}
+
+	if c.FileExists != "" {
+		// Clean the path as if it was absolute, so that it can't point
+		// outside of the repository.
+		p := filepath.Join(env.volumeDir, filepath.Clean(string(os.PathSeparator)+c.FileExists))
+		if _, err := os.Stat(p); err != nil {
+			if os.IsNotExist(err) {
+				return false, fmt.Sprintf("file %q does not exist", c.FileExists), nil
+			}
+			return false, "", errors.Wrapf(err, "checking whether %q exists", c.FileExists)
+		}
+	}
+
+	if c.RepoMatches != "" {
+		ok, err := path.Match(c.RepoMatches, env.repoName)
+		if err != nil {
+			return false, "", errors.Wrapf(err, "invalid repoMatches pattern %q", c.RepoMatches)
+		}
+		if !ok {
+			return false, fmt.Sprintf("repository name does not match %q", c.RepoMatches), nil
+		}
+	}
+
+	if c.OutputNotEmpty != "" && strings.TrimSpace(env.outputs[c.OutputNotEmpty]) == "" {
+		return false, fmt.Sprintf("output %q is empty", c.OutputNotEmpty), nil
+	}
+
+	return true, "", nil
+}
+
+// needsChangeDetection returns whether any of the steps has a condition on
+// the changes made by the previous step.
+func needsChangeDetection(steps []*ActionStep) bool {
+	for _, step := range steps {
+		if step.If != nil && step.If.PreviousStepChangedFiles != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,610 @@
+package campaigns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	runewidth "github.com/mattn/go-runewidth"
+	"github.com/pkg/errors"
+)
+
+// maxDashboardLines is the number of log lines of a repository the dashboard
+// keeps for tailing.
+const maxDashboardLines = 500
+
+// Dashboard is a full-screen view of the executions of an action, which the
+// ActionLogger shows instead of the progress bar. It lists the repositories
+// the action is being executed in with their current step, elapsed time and
+// last log line. The log of a single repository can be tailed and its
+// execution canceled.
+type Dashboard struct {
+	in, out  *os.File
+	restore  func() error
+	progress *progress
+
+	// terminal renders the events that aren't shown in the list of
+	// repositories, writing them to the dashboard.
+	terminal *terminalSink
+
+	// cancel cancels the execution in the repository with the given name.
+	cancel func(repoName string) bool
+
+	mu        sync.Mutex
+	startedAt time.Time
+	repos     map[string]*dashboardRepo
+
+	finished, failed, cached int
+
+	// messages holds the output that doesn't belong to a repository. It's
+	// written to out when the dashboard is closed.
+	messages []string
+	partial  []byte
+
+	selected      string
+	selectedIndex int
+	tailing       string
+	confirmCancel string
+	notice        string
+
+	closed bool
+	done   chan struct{}
+	reader sync.WaitGroup
+}
+
+type dashboardRepo struct {
+	name      string
+	startedAt time.Time
+	steps     int
+	step      int
+	stepDesc  string
+	lines     []string
+
+	// status is set when the execution finished.
+	status string
+}
+
+func newDashboard(terminal *terminalSink, cancel func(repoName string) bool) *Dashboard {
+	d := &Dashboard{
+		progress:  terminal.progress,
+		cancel:    cancel,
+		startedAt: time.Now(),
+		repos:     map[string]*dashboardRepo{},
+		done:      make(chan struct{}),
+	}
+	d.terminal = &terminalSink{verbose: terminal.verbose, progress: terminal.progress, out: d}
+	return d
+}
+
+// openDashboard shows a new dashboard on the terminal of in and out, which
+// replaces the given terminal sink.
+func openDashboard(in, out *os.File, terminal *terminalSink, cancel func(repoName string) bool) (*Dashboard, error) {
+	for _, f := range []*os.File{in, out} {
+		if !isatty.IsTerminal(f.Fd()) && !isatty.IsCygwinTerminal(f.Fd()) {
+			return nil, errors.Errorf("the dashboard requires a terminal, but %s is not one", f.Name())
+		}
+	}
+
+	restore, err := setupTerminal(in, out)
+	if err != nil {
+		return nil, errors.Wrap(err, "setting up the terminal")
+	}
+
+	d := newDashboard(terminal, cancel)
+	d.in, d.out, d.restore = in, out, restore
+
+	// Switch to the alternate screen and hide the cursor.
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	return d, nil
+}
+
+// run redraws the dashboard and handles the input of the user until the
+// dashboard is closed. It closes the dashboard when ctx is canceled.
+func (d *Dashboard) run(ctx context.Context) {
+	d.reader.Add(1)
+	go d.readInput()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			d.Close()
+			return
+		case <-d.done:
+			return
+		}
+	}
+}
+
+func (d *Dashboard) readInput() {
+	defer d.reader.Done()
+
+	buf := make([]byte, 64)
+	for {
+		select {
+		case <-d.done:
+			return
+		default:
+		}
+
+		n, err := readTerminal(d.in, buf)
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			d.handleKey(key)
+		}
+		d.draw()
+	}
+}
+
+// parseKeys splits the input of the terminal into keys. Arrow keys are
+// returned as "up" and "down".
+func parseKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		switch {
+		case bytes.HasPrefix(input, []byte("\x1b[A")), bytes.HasPrefix(input, []byte("\x1bOA")):
+			keys = append(keys, "up")
+			input = input[3:]
+		case bytes.HasPrefix(input, []byte("\x1b[B")), bytes.HasPrefix(input, []byte("\x1bOB")):
+			keys = append(keys, "down")
+			input = input[3:]
+		case input[0] == '\x1b':
+			// Other escape sequences are ignored, a single ESC is the
+			// escape key.
+			if len(input) > 1 && input[1] == '[' {
+				i := 2
+				for i < len(input) && (input[i] < 0x40 || input[i] > 0x7e) {
+					i++
+				}
+				input = input[min(i+1, len(input)):]
+				continue
+			}
+			keys = append(keys, "esc")
+			input = input[1:]
+		case input[0] == '\r' || input[0] == '\n':
+			keys = append(keys, "enter")
+			input = input[1:]
+		default:
+			keys = append(keys, string(input[0]))
+			input = input[1:]
+		}
+	}
+	return keys
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// handleKey handles a key pressed by the user.
+func (d *Dashboard) handleKey(key string) {
+	d.mu.Lock()
+
+	cancel := ""
+	target := d.tailing
+	if target == "" {
+		running := d.running()
+		if i := d.selection(running); i >= 0 {
+			target = running[i].name
+		}
+	}
+
+	switch {
+	case key == "c" && target != "":
+		if d.confirmCancel == target {
+			d.confirmCancel = ""
+			if r, ok := d.repos[target]; ok && r.status == "" {
+				cancel = target
+				d.notice = fmt.Sprintf("Canceling the execution in %s...", target)
+			}
+			break
+		}
+		d.confirmCancel = target
+		d.notice = fmt.Sprintf("Press c again to cancel the execution in %s.", target)
+
+	case d.tailing != "" && (key == "esc" || key == "q" || key == "t" || key == "enter"):
+		if r, ok := d.repos[d.tailing]; ok && r.status != "" {
+			delete(d.repos, d.tailing)
+		}
+		d.tailing = ""
+		d.confirmCancel = ""
+
+	case d.tailing == "" && (key == "up" || key == "k" || key == "down" || key == "j"):
+		running := d.running()
+		i := d.selection(running)
+		if key == "up" || key == "k" {
+			i--
+		} else {
+			i++
+		}
+		if i >= 0 && i < len(running) {
+			d.selected, d.selectedIndex = running[i].name, i
+		}
+		d.confirmCancel = ""
+
+	case d.tailing == "" && (key == "enter" || key == "t") && target != "":
+		d.tailing = target
+		d.confirmCancel = ""
+
+	default:
+		d.confirmCancel = ""
+	}
+	d.mu.Unlock()
+
+	if cancel != "" && d.cancel != nil && !d.cancel(cancel) {
+		d.mu.Lock()
+		d.notice = fmt.Sprintf("The execution in %s already finished.", cancel)
+		d.mu.Unlock()
+	}
+}
+
+// running returns the repositories that the action is being executed in,
+// in the order they were started. d.mu must be held.
+func (d *Dashboard) running() []*dashboardRepo {
+	running := make([]*dashboardRepo, 0, len(d.repos))
+	for _, r := range d.repos {
+		if r.status == "" {
+			running = append(running, r)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool {
+		if !running[i].startedAt.Equal(running[j].startedAt) {
+			return running[i].startedAt.Before(running[j].startedAt)
+		}
+		return running[i].name < running[j].name
+	})
+	return running
+}
+
+// selection returns the index of the selected repository. If it finished,
+// the one that took its place is selected. d.mu must be held.
+func (d *Dashboard) selection(running []*dashboardRepo) int {
+	if len(running) == 0 {
+		return -1
+	}
+	for i, r := range running {
+		if r.name == d.selected {
+			d.selectedIndex = i
+			return i
+		}
+	}
+	i := min(d.selectedIndex, len(running)-1)
+	d.selected, d.selectedIndex = running[i].name, i
+	return i
+}
+
+// Publish updates the dashboard with the event. The events of repositories
+// the action is being executed in are added to their log, all others are
+// rendered like by the terminal sink.
+func (d *Dashboard) Publish(ev Event) {
+	switch ev.Type {
+	case EventRepoStarted:
+		d.repoStarted(ev.Repo, ev.Steps)
+	case EventStepStarted:
+		d.stepStarted(ev.Repo, *ev.Step, ev.stepDescription())
+	case EventRepoCacheHit:
+		d.repoCached()
+	}
+
+	_, line := describeEvent(ev, false)
+	if ev.Repo != "" && ev.Type != EventRepoCacheHit && d.repoLines(ev.Repo, line) {
+		d.progress.record(ev)
+	} else {
+		d.terminal.Publish(ev)
+	}
+
+	if ev.Type == EventRepoFinished {
+		d.repoFinished(ev.Repo, ev.Error)
+	}
+}
+
+func (d *Dashboard) repoStarted(repoName string, steps int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Retries keep the start time and the log of earlier attempts.
+	r, ok := d.repos[repoName]
+	if !ok {
+		r = &dashboardRepo{name: repoName, startedAt: time.Now()}
+		d.repos[repoName] = r
+	}
+	r.steps, r.step, r.stepDesc = steps, 0, ""
+}
+
+func (d *Dashboard) stepStarted(repoName string, step int, desc string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if r, ok := d.repos[repoName]; ok {
+		r.step, r.stepDesc = step, desc
+	}
+}
+
+// repoLines records log output of a repository. It returns false if the
+// dashboard is closed.
+func (d *Dashboard) repoLines(repoName, text string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return false
+	}
+	r, ok := d.repos[repoName]
+	if !ok {
+		return true
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		r.lines = append(r.lines, stripEscapes(line))
+	}
+	if len(r.lines) > maxDashboardLines {
+		r.lines = append(r.lines[:0], r.lines[len(r.lines)-maxDashboardLines:]...)
+	}
+	return true
+}
+
+func (d *Dashboard) repoFinished(repoName, errMsg string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if errMsg != "" {
+		d.failed++
+	} else {
+		d.finished++
+	}
+
+	r, ok := d.repos[repoName]
+	if !ok {
+		return
+	}
+	if d.tailing != repoName {
+		delete(d.repos, repoName)
+		return
+	}
+	// Keep the repository until the user stops tailing it.
+	r.status = "finished"
+	if errMsg != "" {
+		r.status = "failed: " + stripEscapes(errMsg)
+	}
+}
+
+func (d *Dashboard) repoCached() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cached++
+}
+
+// Write records output that doesn't belong to a repository. The last line is
+// shown at the bottom of the dashboard. Once the dashboard is closed, the
+// output is written to the terminal.
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return d.out.Write(p)
+	}
+
+	d.partial = append(d.partial, p...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(d.partial[:i+1])
+		d.messages = append(d.messages, line)
+		if s := strings.TrimSpace(stripEscapes(line)); s != "" {
+			d.notice = s
+		}
+		d.partial = d.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close removes the dashboard from the terminal, restores the terminal and
+// writes the recorded output. It's safe to call it more than once.
+func (d *Dashboard) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	close(d.done)
+	d.mu.Unlock()
+
+	// The reader must be done before the terminal is restored, because it
+	// relies on reads timing out.
+	d.reader.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprint(d.out, "\x1b[?25h\x1b[?1049l")
+	err := d.restore()
+	for _, m := range d.messages {
+		io.WriteString(d.out, m)
+	}
+	d.out.Write(d.partial)
+	d.messages, d.partial = nil, nil
+	return err
+}
+
+func (d *Dashboard) draw() {
+	width, height, err := terminalSize(d.out)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	for i, line := range d.render(width, height, time.Now()) {
+		if i > 0 {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString(line)
+		buf.WriteString("\x1b[K")
+	}
+	buf.WriteString("\x1b[J")
+	d.out.Write(buf.Bytes())
+}
+
+// render returns the lines of the dashboard for a terminal of the given
+// size. d.mu must be held.
+func (d *Dashboard) render(width, height int, now time.Time) []string {
+	var lines []string
+	if d.tailing != "" {
+		lines = d.renderTail(height, now)
+	} else {
+		lines = d.renderList(height, now)
+	}
+
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(runewidth.Truncate(line, width, ""), " ")
+	}
+	return lines
+}
+
+func (d *Dashboard) renderList(height int, now time.Time) []string {
+	running := d.running()
+	lines := []string{
+		fmt.Sprintf("Steps %d/%d  Running %d  Finished %d  Failed %d  Cached %d  Patches %d  Elapsed %s",
+			d.progress.StepsComplete(), d.progress.TotalSteps(), len(running), d.finished, d.failed, d.cached,
+			d.progress.PatchCount(), now.Sub(d.startedAt).Round(time.Second)),
+		"",
+	}
+
+	// The list takes all lines except for the header, the column names, the
+	// notice and the help.
+	rows := height - 5
+	if len(running) == 0 {
+		lines = append(lines, "  Waiting for executions to start...")
+	} else if rows > 0 {
+		nameWidth, stepWidth := len("REPOSITORY"), len("STEP")
+		for _, r := range running {
+			if w := runewidth.StringWidth(r.name); w > nameWidth {
+				nameWidth = w
+			}
+			if w := runewidth.StringWidth(r.stepColumn()); w > stepWidth {
+				stepWidth = w
+			}
+		}
+		nameWidth, stepWidth = min(nameWidth, 50), min(stepWidth, 40)
+		column := func(s string, w int) string {
+			return runewidth.FillRight(runewidth.Truncate(s, w, "…"), w)
+		}
+
+		lines = append(lines, fmt.Sprintf("  %s  %s  %-8s  %s", column("REPOSITORY", nameWidth), column("STEP", stepWidth), "ELAPSED", "LAST LINE"))
+
+		// Scroll the list so that the selected repository is visible.
+		selected := d.selection(running)
+		first := 0
+		if selected >= rows {
+			first = selected - rows + 1
+		}
+		for i := first; i < len(running) && i < first+rows; i++ {
+			r := running[i]
+			marker := "  "
+			if i == selected {
+				marker = "> "
+			}
+			lines = append(lines, fmt.Sprintf("%s%s  %s  %-8s  %s", marker, column(r.name, nameWidth), column(r.stepColumn(), stepWidth),
+				now.Sub(r.startedAt).Round(time.Second), r.lastLine()))
+		}
+	}
+
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+	return append(lines, d.notice, "↑/↓ select  enter tail log  c cancel repository  ctrl-c cancel run")
+}
+
+func (d *Dashboard) renderTail(height int, now time.Time) []string {
+	r, ok := d.repos[d.tailing]
+	if !ok {
+		r = &dashboardRepo{name: d.tailing}
+	}
+
+	header := r.name
+	switch {
+	case r.status != "":
+		header += "  " + r.status
+	case r.steps > 0:
+		header += fmt.Sprintf("  Step %s  Elapsed %s", r.stepColumn(), now.Sub(r.startedAt).Round(time.Second))
+	}
+	lines := []string{header, ""}
+
+	log := r.lines
+	if rows := height - 4; rows < len(log) {
+		log = log[max(len(log)-rows, 0):]
+	}
+	lines = append(lines, log...)
+
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+	return append(lines, d.notice, "esc back  c cancel repository  ctrl-c cancel run")
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (r *dashboardRepo) stepColumn() string {
+	if r.steps == 0 {
+		return ""
+	}
+	if r.stepDesc == "" {
+		return fmt.Sprintf("%d/%d", r.step+1, r.steps)
+	}
+	return fmt.Sprintf("%d/%d %s", r.step+1, r.steps, r.stepDesc)
+}
+
+func (r *dashboardRepo) lastLine() string {
+	for i := len(r.lines) - 1; i >= 0; i-- {
+		if s := strings.TrimSpace(r.lines[i]); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+var escapeSequence = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// stripEscapes removes the ANSI escape sequences, e.g. colors, and carriage
+// returns from the text, which would break the layout of the dashboard.
+func stripEscapes(s string) string {
+	s = escapeSequence.ReplaceAllString(s, "")
+	if i := strings.LastIndexByte(strings.TrimSuffix(s, "\r"), '\r'); i >= 0 {
+		// Progress output overwrites the line, keep only what's visible.
+		s = s[i+1:]
+	}
+	return strings.Replace(s, "\r", "", -1)
+}
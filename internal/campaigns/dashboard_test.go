@@ -0,0 +1,120 @@
+package campaigns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys := parseKeys([]byte("j\x1b[A\x1bOB\r\x1b[5~c\x1b"))
+	want := []string{"j", "up", "down", "enter", "c", "esc"}
+	if diff := cmp.Diff(want, keys); diff != "" {
+		t.Errorf("wrong keys (-want +got):\n%s", diff)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	var canceled []string
+	p := new(progress)
+	p.SetTotalSteps(6)
+	d := newDashboard(&terminalSink{progress: p}, func(repoName string) bool {
+		canceled = append(canceled, repoName)
+		return true
+	})
+	now := d.startedAt.Add(90 * time.Second)
+
+	d.Publish(Event{Type: EventRepoStarted, Repo: "github.com/a/a", Steps: 2})
+	d.Publish(Event{Type: EventStepStarted, Repo: "github.com/a/a", Step: step(1), Runtime: "docker", Image: "alpine:3"})
+	for _, line := range []string{"\x1b[33mdownloading\x1b[0m", "10%\r50%\r", ""} {
+		d.Publish(Event{Type: EventOutput, Repo: "github.com/a/a", Stream: "stdout", Line: line})
+	}
+	d.repos["github.com/a/a"].startedAt = now.Add(-time.Minute)
+
+	d.Publish(Event{Type: EventRepoStarted, Repo: "github.com/b/b", Steps: 2})
+	d.Publish(Event{Type: EventStepStarted, Repo: "github.com/b/b", Step: step(0), Args: []string{"sh", "-c", "make"}})
+	d.repos["github.com/b/b"].startedAt = now.Add(-time.Second)
+
+	d.Publish(Event{Type: EventRepoStarted, Repo: "github.com/c/c", Steps: 2})
+	d.Publish(Event{Type: EventRepoFinished, Repo: "github.com/c/c", Error: "exit status 1"})
+	d.Publish(Event{Type: EventRepoCacheHit, Repo: "github.com/d/d", Steps: 2})
+	// Output of repositories that aren't executed is dropped.
+	d.Publish(Event{Type: EventOutput, Repo: "github.com/c/c", Stream: "stdout", Line: "ignored"})
+
+	render := func() []string {
+		return d.render(100, 9, now)
+	}
+
+	want := []string{
+		"Steps 2/6  Running 2  Finished 0  Failed 1  Cached 1  Patches 0  Elapsed 1m30s",
+		"",
+		"  REPOSITORY      STEP                     ELAPSED   LAST LINE",
+		"> github.com/a/a  2/2 docker run alpine:3  1m0s      50%",
+		"  github.com/b/b  1/2 sh -c make           1s        [Step 0] command [sh -c make]",
+		"",
+		"",
+		"github.com/d/d -> Cached result found: no diff produced for this repository.",
+		"↑/↓ select  enter tail log  c cancel repository  ctrl-c cancel run",
+	}
+	if diff := cmp.Diff(want, render()); diff != "" {
+		t.Errorf("wrong list (-want +got):\n%s", diff)
+	}
+
+	// The first c asks for confirmation, the second cancels.
+	d.handleKey("down")
+	d.handleKey("c")
+	if len(canceled) != 0 {
+		t.Fatalf("canceled without confirmation")
+	}
+	d.handleKey("c")
+	if diff := cmp.Diff([]string{"github.com/b/b"}, canceled); diff != "" {
+		t.Errorf("wrong repositories canceled (-want +got):\n%s", diff)
+	}
+
+	// Tailing a repository shows its log, even after it finished.
+	d.handleKey("up")
+	d.handleKey("enter")
+	d.Publish(Event{Type: EventRepoFinished, Repo: "github.com/a/a"})
+	want = []string{
+		"github.com/a/a  finished",
+		"",
+		"[Step 1] docker run alpine:3",
+		"downloading",
+		"50%",
+		"",
+		"Finished. No patch produced.",
+		"Canceling the execution in github.com/b/b...",
+		"esc back  c cancel repository  ctrl-c cancel run",
+	}
+	if diff := cmp.Diff(want, render()); diff != "" {
+		t.Errorf("wrong tail (-want +got):\n%s", diff)
+	}
+
+	d.handleKey("esc")
+	if _, ok := d.repos["github.com/a/a"]; ok {
+		t.Errorf("finished repository kept after it was tailed")
+	}
+	if got := render()[3]; !strings.HasPrefix(got, "> github.com/b/b") {
+		t.Errorf("finished repository still selected: %q", got)
+	}
+}
+
+func TestDashboardRepoLines(t *testing.T) {
+	d := newDashboard(&terminalSink{progress: new(progress)}, nil)
+	d.Publish(Event{Type: EventRepoStarted, Repo: "github.com/a/a", Steps: 1})
+
+	for i := 0; i < maxDashboardLines+10; i++ {
+		d.Publish(Event{Type: EventOutput, Repo: "github.com/a/a", Stream: "stdout", Line: fmt.Sprintf("line %d", i)})
+	}
+
+	lines := d.repos["github.com/a/a"].lines
+	if len(lines) != maxDashboardLines {
+		t.Errorf("wrong number of lines %d", len(lines))
+	}
+	if first, last := lines[0], lines[len(lines)-1]; first != "line 10" || last != "line 509" {
+		t.Errorf("wrong lines kept: %q ... %q", first, last)
+	}
+}
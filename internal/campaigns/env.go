@@ -0,0 +1,170 @@
+package campaigns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// EnvValue is the value of an environment variable of an action step. It's
+// either a literal, or a reference to an environment variable of src or to a
+// secret. In action definitions, literals are given as strings and
+// references as objects with a "fromEnv" or "fromSecret" property.
+type EnvValue struct {
+	Value      string
+	FromEnv    string
+	FromSecret string
+}
+
+type envValueRef struct {
+	FromEnv    string `json:"fromEnv,omitempty"`
+	FromSecret string `json:"fromSecret,omitempty"`
+}
+
+func (v EnvValue) MarshalJSON() ([]byte, error) {
+	if v.FromEnv == "" && v.FromSecret == "" {
+		return json.Marshal(v.Value)
+	}
+	return json.Marshal(envValueRef{FromEnv: v.FromEnv, FromSecret: v.FromSecret})
+}
+
+func (v *EnvValue) UnmarshalJSON(data []byte) error {
+	var literal string
+	if err := json.Unmarshal(data, &literal); err == nil {
+		*v = EnvValue{Value: literal}
+		return nil
+	}
+
+	var ref envValueRef
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return errors.Wrap(err, "environment variable values must be strings or objects with \"fromEnv\" or \"fromSecret\"")
+	}
+	*v = EnvValue{FromEnv: ref.FromEnv, FromSecret: ref.FromSecret}
+	return nil
+}
+
+// resolve returns the value of the environment variable.
+func (v EnvValue) resolve(secrets map[string]string) (string, error) {
+	switch {
+	case v.FromEnv != "":
+		value, ok := os.LookupEnv(v.FromEnv)
+		if !ok {
+			return "", errors.Errorf("environment variable %s is not set", v.FromEnv)
+		}
+		return value, nil
+
+	case v.FromSecret != "":
+		value, ok := secrets[v.FromSecret]
+		if !ok {
+			return "", errors.Errorf("secret %s is not defined", v.FromSecret)
+		}
+		return value, nil
+
+	default:
+		return v.Value, nil
+	}
+}
+
+// mergeActionEnv adds the environment variables that are defined for the
+// whole action to every step, unless the step defines a variable with the same
+// name itself.
+func mergeActionEnv(action Action) {
+	if len(action.Env) == 0 {
+		return
+	}
+	for _, step := range action.Steps {
+		env := make(map[string]EnvValue, len(action.Env)+len(step.Env))
+		for name, value := range action.Env {
+			env[name] = value
+		}
+		for name, value := range step.Env {
+			env[name] = value
+		}
+		step.Env = env
+	}
+}
+
+// resolveStepEnv returns the environment variables of the step in "NAME=value"
+// form, sorted by name.
+func resolveStepEnv(step *ActionStep, secrets map[string]string) ([]string, error) {
+	names := make([]string, 0, len(step.Env))
+	for name := range step.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		value, err := step.Env[name].resolve(secrets)
+		if err != nil {
+			return nil, errors.Wrapf(err, "resolving environment variable %s", name)
+		}
+		env = append(env, name+"="+value)
+	}
+	return env, nil
+}
+
+// stepEnvDigest returns the SHA-256 hash of the resolved values of the
+// environment variables of the steps that refer to environment variables of
+// src or to secrets, or "" if there are none. The values aren't part of the
+// steps in the cache key, which only contain the references, and secrets must
+// not be stored in it, but changing them must invalidate cached results.
+func stepEnvDigest(steps []*ActionStep, secrets map[string]string) (string, error) {
+	h := sha256.New()
+	refs := false
+	for i, step := range steps {
+		env, err := resolveStepEnv(step, secrets)
+		if err != nil {
+			return "", errors.Wrapf(err, "step %d", i)
+		}
+		for _, kv := range env {
+			name := kv[:strings.IndexByte(kv, '=')]
+			if v := step.Env[name]; v.FromEnv == "" && v.FromSecret == "" {
+				continue
+			}
+			refs = true
+			// Length-prefix the entries, so that they can't be confused.
+			fmt.Fprintf(h, "%d:%d:%s\n", i, len(kv), kv)
+		}
+	}
+	if !refs {
+		return "", nil
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ValidateActionEnv checks that all environment variables and secrets that
+// the steps of the action refer to are defined.
+func ValidateActionEnv(action Action, secrets map[string]string) error {
+	for i, step := range action.Steps {
+		if _, err := resolveStepEnv(step, secrets); err != nil {
+			return errors.Wrapf(err, "step %d", i)
+		}
+	}
+	return nil
+}
+
+// SecretValues returns the values of the secrets that are referenced by the
+// steps of the action, so that they can be redacted from logs.
+func SecretValues(action Action, secrets map[string]string) []string {
+	var values []string
+	seen := map[string]bool{}
+	for _, step := range action.Steps {
+		for _, v := range step.Env {
+			if v.FromSecret == "" || seen[v.FromSecret] {
+				continue
+			}
+			seen[v.FromSecret] = true
+			if value := secrets[v.FromSecret]; value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
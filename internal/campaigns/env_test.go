@@ -0,0 +1,49 @@
+package campaigns
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStepEnvDigest(t *testing.T) {
+	const name = "SRC_TEST_TARGET_VERSION"
+	defer os.Unsetenv(name)
+
+	literal := []*ActionStep{{Type: "command", Env: map[string]EnvValue{"A": {Value: "a"}}}}
+	if digest, err := stepEnvDigest(literal, nil); err != nil || digest != "" {
+		t.Errorf("literal values are hashed: digest=%q, err=%v", digest, err)
+	}
+
+	steps := []*ActionStep{{Type: "command", Env: map[string]EnvValue{
+		"VERSION": {FromEnv: name},
+		"TOKEN":   {FromSecret: "token"},
+	}}}
+	digest := func(version, token string) string {
+		os.Setenv(name, version)
+		d, err := stepEnvDigest(steps, map[string]string{"token": token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(d, token) {
+			t.Fatalf("digest contains the secret: %s", d)
+		}
+		return d
+	}
+
+	base := digest("1.0", "s3cret")
+	if base == "" || base != digest("1.0", "s3cret") {
+		t.Fatalf("digest isn't stable: %q", base)
+	}
+	if base == digest("2.0", "s3cret") {
+		t.Error("changing an environment variable didn't change the digest")
+	}
+	if base == digest("1.0", "rotated") {
+		t.Error("rotating a secret didn't change the digest")
+	}
+
+	os.Unsetenv(name)
+	if _, err := stepEnvDigest(steps, nil); err == nil {
+		t.Error("no error for undefined variable")
+	}
+}
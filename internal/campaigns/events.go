@@ -0,0 +1,275 @@
+package campaigns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	EventActionStarted   EventType = "actionStarted"
+	EventReposMatched    EventType = "reposMatched"
+	EventRepoCacheHit    EventType = "repoCacheHit"
+	EventRepoStarted     EventType = "repoStarted"
+	EventRepoRetrying    EventType = "repoRetrying"
+	EventRepoFinished    EventType = "repoFinished"
+	EventStepStarted     EventType = "stepStarted"
+	EventStepDone        EventType = "stepDone"
+	EventStepFailed      EventType = "stepFailed"
+	EventStepTimedOut    EventType = "stepTimedOut"
+	EventStepSkipped     EventType = "stepSkipped"
+	EventOutput          EventType = "output"
+	EventMessage         EventType = "message"
+	EventActionSucceeded EventType = "actionSucceeded"
+	EventActionFailed    EventType = "actionFailed"
+)
+
+// Event is something that happened while executing an action. The
+// ActionLogger publishes events to its EventSinks. Only the fields that apply
+// to the Type are set. Secrets are redacted from all fields.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type EventType `json:"type"`
+
+	Repo string `json:"repo,omitempty"`
+	Rev  string `json:"rev,omitempty"`
+
+	// Steps is the number of steps executed in a repository for
+	// repoStarted and repoCacheHit events and in all repositories for
+	// actionStarted events.
+	Steps int `json:"steps,omitempty"`
+
+	// Step is the index of the step for step events.
+	Step *int `json:"step,omitempty"`
+
+	// Runtime and Image are set for steps executed in containers, Args for
+	// steps executed on the host.
+	Runtime string   `json:"runtime,omitempty"`
+	Image   string   `json:"image,omitempty"`
+	Args    []string `json:"args,omitempty"`
+
+	// Elapsed is the duration of a container step. In JSON, it's given as
+	// elapsedSeconds.
+	Elapsed time.Duration `json:"-"`
+
+	// Reason is the reason a step was skipped.
+	Reason string `json:"reason,omitempty"`
+
+	// Phase is what failed before a repoRetrying event, e.g. "fetching the
+	// archive".
+	Phase string `json:"phase,omitempty"`
+
+	// Attempt is the upcoming attempt of a repoRetrying event, out of
+	// MaxAttempts, and Delay the delay before it. In JSON, Delay is given as
+	// delaySeconds.
+	Attempt     int           `json:"attempt,omitempty"`
+	MaxAttempts int           `json:"maxAttempts,omitempty"`
+	Delay       time.Duration `json:"-"`
+
+	PatchProduced bool `json:"patchProduced,omitempty"`
+
+	// LogFile is the log file of a finished repository if it's kept.
+	LogFile string `json:"logFile,omitempty"`
+
+	// Matched is the number of repositories matched by the scope query, and
+	// Skipped and Unsupported those that are not executed.
+	Matched     int      `json:"matched,omitempty"`
+	Skipped     []string `json:"skipped,omitempty"`
+	Unsupported []string `json:"unsupported,omitempty"`
+
+	// Stream is "stdout" or "stderr" and Line a line of the output of a step
+	// or, if Repo is empty, of the command given by Source, e.g. an image
+	// pull.
+	Stream string `json:"stream,omitempty"`
+	Source string `json:"source,omitempty"`
+	Line   string `json:"line,omitempty"`
+
+	// Level is "info" or "warning" for messages.
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Patches is the number of patches produced by the action.
+	Patches int `json:"patches,omitempty"`
+
+	Error string `json:"error,omitempty"`
+
+	// Errors holds the errors of the repositories the action failed in.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// eventJSON is the JSON form of an Event, in which durations are given in
+// seconds, like in execution reports.
+type eventJSON struct {
+	event
+	ElapsedSeconds float64 `json:"elapsedSeconds,omitempty"`
+	DelaySeconds   float64 `json:"delaySeconds,omitempty"`
+}
+
+// event has the fields of Event without its methods.
+type event Event
+
+func (ev Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(eventJSON{
+		event:          event(ev),
+		ElapsedSeconds: ev.Elapsed.Seconds(),
+		DelaySeconds:   ev.Delay.Seconds(),
+	})
+}
+
+func (ev *Event) UnmarshalJSON(data []byte) error {
+	var v eventJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*ev = Event(v.event)
+	ev.Elapsed = time.Duration(v.ElapsedSeconds * float64(time.Second))
+	ev.Delay = time.Duration(v.DelaySeconds * float64(time.Second))
+	return nil
+}
+
+// EventSink receives the events published by an ActionLogger. Publish is
+// called concurrently for the executions in different repositories.
+type EventSink interface {
+	Publish(Event)
+}
+
+// JSONLinesSink is an EventSink that writes every event as a line of JSON.
+type JSONLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+func (s *JSONLinesSink) Publish(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = s.enc.Encode(ev)
+	}
+}
+
+// Err returns the first error that occurred while writing the events. No
+// events are written after it.
+func (s *JSONLinesSink) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// EventChannel is an EventSink that sends the events to a channel, e.g. for
+// programs that embed the Executor. Publishing blocks until the event is
+// received, so the channel must be drained until an actionSucceeded or
+// actionFailed event is received.
+type EventChannel chan<- Event
+
+func (c EventChannel) Publish(ev Event) {
+	c <- ev
+}
+
+// describeEvent returns the line that describes an event of a repository in
+// the output and the log file, and its color. If colored is false, the line
+// doesn't contain escape sequences.
+func describeEvent(ev Event, colored bool) (*color.Color, string) {
+	var step string
+	if ev.Step != nil {
+		step = fmt.Sprintf("[Step %d]", *ev.Step)
+		if colored {
+			step = boldBlack.Sprint(step)
+		}
+	}
+
+	switch ev.Type {
+	case EventRepoStarted:
+		return yellow, fmt.Sprintf("Starting action @ %s (%d steps)\n", ev.Rev, ev.Steps)
+	case EventRepoRetrying:
+		failed := "Action failed"
+		if ev.Phase != "" {
+			failed += " while " + ev.Phase
+		}
+		return yellow, fmt.Sprintf("%s: %q. Retrying in %s (attempt %d of %d).\n", failed, ev.Error, ev.Delay, ev.Attempt, ev.MaxAttempts)
+	case EventRepoFinished:
+		switch {
+		case ev.Error != "" && ev.LogFile != "":
+			return boldRed, fmt.Sprintf("Action failed: %q (Logfile: %s)\n", ev.Error, ev.LogFile)
+		case ev.Error != "":
+			return boldRed, fmt.Sprintf("Action failed: %q\n", ev.Error)
+		case ev.PatchProduced:
+			return boldGreen, "Finished. Patch produced.\n"
+		default:
+			return grey, "Finished. No patch produced.\n"
+		}
+	case EventRepoCacheHit:
+		if ev.PatchProduced {
+			return boldGreen, "Cached result found: using cached diff.\n"
+		}
+		return grey, "Cached result found: no diff produced for this repository.\n"
+	case EventStepStarted:
+		if ev.Runtime != "" {
+			return yellow, fmt.Sprintf("%s %s run %s\n", step, ev.Runtime, ev.Image)
+		}
+		return yellow, fmt.Sprintf("%s command %v\n", step, ev.Args)
+	case EventStepDone:
+		if ev.Elapsed > 0 {
+			return yellow, fmt.Sprintf("%s Done. (%s)\n", step, ev.Elapsed)
+		}
+		return yellow, fmt.Sprintf("%s Done.\n", step)
+	case EventStepFailed:
+		if ev.Elapsed > 0 {
+			return boldRed, fmt.Sprintf("%s %s. (%s)\n", step, ev.Error, ev.Elapsed)
+		}
+		return boldRed, fmt.Sprintf("%s %s.\n", step, ev.Error)
+	case EventStepTimedOut:
+		return boldRed, fmt.Sprintf("%s Timed out: %s\n", step, ev.Error)
+	case EventStepSkipped:
+		return grey, fmt.Sprintf("%s Skipped: %s.\n", step, ev.Reason)
+	case EventOutput:
+		return yellow, ev.Line + "\n"
+	default:
+		return grey, ev.Message
+	}
+}
+
+// stepDescription returns the command or the container a step is executed
+// in.
+func (ev Event) stepDescription() string {
+	if ev.Runtime != "" {
+		return fmt.Sprintf("%s run %s", ev.Runtime, ev.Image)
+	}
+	return strings.Join(ev.Args, " ")
+}
+
+// record updates the progress with the event.
+func (p *progress) record(ev Event) {
+	switch ev.Type {
+	case EventActionStarted:
+		p.SetTotalSteps(int64(ev.Steps))
+	case EventRepoCacheHit:
+		p.IncStepsComplete(int64(ev.Steps))
+		if ev.PatchProduced {
+			p.IncPatchCount()
+		}
+	case EventRepoRetrying:
+		p.resetRepoSteps(ev.Repo)
+	case EventRepoFinished:
+		if ev.Error == "" && ev.PatchProduced {
+			p.IncPatchCount()
+		}
+		p.repoFinished(ev.Repo)
+	case EventStepDone, EventStepSkipped:
+		p.recordRepoStep(ev.Repo, false)
+	case EventStepFailed, EventStepTimedOut:
+		p.recordRepoStep(ev.Repo, true)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -23,6 +24,14 @@ func UserCacheDir() (string, error) {
 type ExecutionCacheKey struct {
 	Repo ActionRepo
 	Runs []*ActionStep
+
+	// PatchPolicy is the patchPolicy of the action, which the cached patch
+	// complies with.
+	PatchPolicy *PatchPolicy `json:",omitempty"`
+
+	// Env is the hash of the values of the environment variables and secrets
+	// the steps refer to, see stepEnvDigest.
+	Env string `json:",omitempty"`
 }
 
 type ExecutionCache interface {
@@ -31,20 +40,62 @@ type ExecutionCache interface {
 	Clear(ctx context.Context, key ExecutionCacheKey) error
 }
 
+// ExecutionDiskCache stores the result of executing an action in a
+// repository in a file named after the hash of its ExecutionCacheKey. The
+// modification time of the file is the last time it was used, see
+// CacheManager.
 type ExecutionDiskCache struct {
 	Dir string
+
+	// Action is the ActionID recorded with new entries.
+	Action string
 }
 
-func (c ExecutionDiskCache) cacheFilePath(key ExecutionCacheKey) (string, error) {
+// diskCacheEntry is the content of the files of ExecutionDiskCache and the
+// body of the requests of ExecutionHTTPCache. Older versions of src stored
+// only the PatchInput.
+type diskCacheEntry struct {
+	Repo   string      `json:"repo"`
+	Action string      `json:"action,omitempty"`
+	Result *PatchInput `json:"result"`
+}
+
+// decodeCacheEntry returns the result stored in a diskCacheEntry.
+func decodeCacheEntry(data []byte) (PatchInput, error) {
+	var entry diskCacheEntry
+	err := json.Unmarshal(data, &entry)
+	if err == nil && entry.Result == nil {
+		entry.Result = &PatchInput{}
+		err = json.Unmarshal(data, entry.Result)
+	}
+	if err != nil {
+		return PatchInput{}, err
+	}
+	return *entry.Result, nil
+}
+
+// executionCacheKeyHash returns the hash of the key that entries are stored
+// under.
+func executionCacheKeyHash(key ExecutionCacheKey) (string, error) {
 	keyJSON, err := json.Marshal(key)
 	if err != nil {
 		return "", errors.Wrap(err, "Failed to marshal JSON when generating action cache key")
 	}
 
 	b := sha256.Sum256(keyJSON)
-	keyString := base64.RawURLEncoding.EncodeToString(b[:16])
+	return base64.RawURLEncoding.EncodeToString(b[:16]), nil
+}
 
-	return filepath.Join(c.Dir, keyString+".json"), nil
+func (c ExecutionDiskCache) cacheFilePath(key ExecutionCacheKey) (string, error) {
+	hash, err := executionCacheKeyHash(key)
+	if err != nil {
+		return "", err
+	}
+	return c.hashFilePath(hash), nil
+}
+
+func (c ExecutionDiskCache) hashFilePath(hash string) string {
+	return filepath.Join(c.Dir, hash+".json")
 }
 
 func (c ExecutionDiskCache) Get(ctx context.Context, key ExecutionCacheKey) (PatchInput, bool, error) {
@@ -61,8 +112,8 @@ func (c ExecutionDiskCache) Get(ctx context.Context, key ExecutionCacheKey) (Pat
 		return PatchInput{}, false, err
 	}
 
-	var result PatchInput
-	if err := json.Unmarshal(data, &result); err != nil {
+	result, err := decodeCacheEntry(data)
+	if err != nil {
 		// Delete the invalid data to avoid causing an error for next time.
 		if err := os.Remove(path); err != nil {
 			return PatchInput{}, false, errors.Wrap(err, "while deleting cache file with invalid JSON")
@@ -70,11 +121,17 @@ func (c ExecutionDiskCache) Get(ctx context.Context, key ExecutionCacheKey) (Pat
 		return PatchInput{}, false, errors.Wrapf(err, "reading cache file %s", path)
 	}
 
+	// Mark the entry as used, so that it's not evicted.
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		return PatchInput{}, false, err
+	}
+
 	return result, true, nil
 }
 
 func (c ExecutionDiskCache) Set(ctx context.Context, key ExecutionCacheKey, result PatchInput) error {
-	data, err := json.Marshal(result)
+	data, err := json.Marshal(diskCacheEntry{Repo: key.Repo.Name, Action: c.Action, Result: &result})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,266 @@
+package campaigns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExecutionHTTPCache is an ExecutionCache that is shared over HTTP, e.g. by
+// a team, so that an action is executed only once in a repository at a
+// revision. Entries are read with GET, written with PUT and removed with
+// DELETE requests to URL/<hash of the key>. See ExecutionCacheHandler for a
+// server.
+type ExecutionHTTPCache struct {
+	URL string
+
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string
+
+	// Action is the ActionID recorded with new entries.
+	Action string
+
+	// Client is used for the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+func (c ExecutionHTTPCache) do(ctx context.Context, method string, key ExecutionCacheKey, body []byte) (*http.Response, error) {
+	hash, err := executionCacheKeyHash(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+"/"+hash, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "remote execution cache")
+	}
+	return resp, nil
+}
+
+// checkResponse returns an error if the status code of the response is not
+// one of the given ones.
+func checkResponse(resp *http.Response, codes ...int) error {
+	for _, code := range codes {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return errors.Errorf("remote execution cache: %s %s failed with status %s: %s", resp.Request.Method, resp.Request.URL, resp.Status, bytes.TrimSpace(msg))
+}
+
+func (c ExecutionHTTPCache) Get(ctx context.Context, key ExecutionCacheKey) (PatchInput, bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return PatchInput{}, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return PatchInput{}, false, nil
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return PatchInput{}, false, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return PatchInput{}, false, errors.Wrap(err, "remote execution cache")
+	}
+	result, err := decodeCacheEntry(data)
+	if err != nil {
+		return PatchInput{}, false, errors.Wrapf(err, "remote execution cache: reading %s", resp.Request.URL)
+	}
+	return result, true, nil
+}
+
+func (c ExecutionHTTPCache) Set(ctx context.Context, key ExecutionCacheKey, result PatchInput) error {
+	data, err := json.Marshal(diskCacheEntry{Repo: key.Repo.Name, Action: c.Action, Result: &result})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+func (c ExecutionHTTPCache) Clear(ctx context.Context, key ExecutionCacheKey) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+}
+
+// TieredExecutionCache is an ExecutionCache that consists of several caches,
+// e.g. a local ExecutionDiskCache in front of a shared ExecutionHTTPCache.
+// Results are looked up in the caches in order and copied to the caches
+// before the one that had them. Results are written to and cleared from all
+// caches.
+type TieredExecutionCache []ExecutionCache
+
+func (t TieredExecutionCache) Get(ctx context.Context, key ExecutionCacheKey) (PatchInput, bool, error) {
+	for i, c := range t {
+		result, ok, err := c.Get(ctx, key)
+		if err != nil {
+			return PatchInput{}, false, err
+		}
+		if !ok {
+			continue
+		}
+		for _, prev := range t[:i] {
+			if err := prev.Set(ctx, key, result); err != nil {
+				return PatchInput{}, false, err
+			}
+		}
+		return result, true, nil
+	}
+	return PatchInput{}, false, nil
+}
+
+func (t TieredExecutionCache) Set(ctx context.Context, key ExecutionCacheKey, result PatchInput) error {
+	for _, c := range t {
+		if err := c.Set(ctx, key, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t TieredExecutionCache) Clear(ctx context.Context, key ExecutionCacheKey) error {
+	for _, c := range t {
+		if err := c.Clear(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxCacheEntrySize is the maximum size of the request body of a PUT to
+// ExecutionCacheHandler.
+const maxCacheEntrySize = 64 << 20
+
+var cacheKeyHashPattern = regexp.MustCompile(`^/[A-Za-z0-9_-]{22}$`)
+
+// ExecutionCacheHandler is an http.Handler that serves the entries of an
+// ExecutionDiskCache to ExecutionHTTPCache clients. Its entries can be
+// managed with a CacheManager like those of any other ExecutionDiskCache.
+type ExecutionCacheHandler struct {
+	Cache ExecutionDiskCache
+
+	// Token, if set, must be given by clients in an "Authorization: token
+	// <Token>" header.
+	Token string
+}
+
+func (h *ExecutionCacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Token != "" && r.Header.Get("Authorization") != "token "+h.Token {
+		http.Error(w, "invalid or missing access token", http.StatusUnauthorized)
+		return
+	}
+	if !cacheKeyHashPattern.MatchString(r.URL.Path) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	path := h.Cache.hashFilePath(r.URL.Path[1:])
+
+	switch r.Method {
+	case http.MethodGet:
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Mark the entry as used, so that it's not evicted.
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxCacheEntrySize+1))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(data) > maxCacheEntrySize {
+			http.Error(w, fmt.Sprintf("entry exceeds the maximum size of %d bytes", maxCacheEntrySize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if _, err := decodeCacheEntry(data); err != nil {
+			http.Error(w, "invalid entry: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := writeFileAtomic(path, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeFileAtomic writes the file so that concurrent readers never see a
+// partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,128 @@
+package campaigns
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExecutionHTTPCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(&ExecutionCacheHandler{Cache: ExecutionDiskCache{Dir: dir}, Token: "secret"})
+	defer ts.Close()
+
+	ctx := context.Background()
+	cache := ExecutionHTTPCache{
+		URL:     ts.URL,
+		Headers: map[string]string{"Authorization": "token secret"},
+		Action:  "action-a",
+	}
+	key := ExecutionCacheKey{
+		Repo: ActionRepo{ID: "1", Name: "github.com/a/a", Rev: "deadbeef"},
+		Runs: []*ActionStep{{Type: "command", Args: []string{"gofmt", "-w", "."}}},
+	}
+	result := PatchInput{Repository: "1", BaseRevision: "deadbeef", BaseRef: "master", Patch: "diff"}
+
+	if _, ok, err := cache.Get(ctx, key); err != nil || ok {
+		t.Fatalf("empty cache returned ok=%v, err=%v", ok, err)
+	}
+	if err := cache.Set(ctx, key, result); err != nil {
+		t.Fatal(err)
+	}
+	have, ok, err := cache.Get(ctx, key)
+	if err != nil || !ok {
+		t.Fatalf("cached result not found: ok=%v, err=%v", ok, err)
+	}
+	if diff := cmp.Diff(result, have); diff != "" {
+		t.Errorf("wrong result (-want +got):\n%s", diff)
+	}
+
+	// The server stores entries like a local cache, with the same keys.
+	local := ExecutionDiskCache{Dir: dir}
+	if have, ok, err := local.Get(ctx, key); err != nil || !ok {
+		t.Errorf("entry not stored in the server's directory: ok=%v, err=%v", ok, err)
+	} else if diff := cmp.Diff(result, have); diff != "" {
+		t.Errorf("wrong stored result (-want +got):\n%s", diff)
+	}
+	entries, err := CacheManager{Results: local, Volumes: CacheDirVolumes{Dir: dir}}.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Repo != "github.com/a/a" || entries[0].Action != "action-a" {
+		t.Errorf("wrong entries on the server: %+v", entries)
+	}
+
+	// Results are copied from the remote cache into the local one.
+	localDir, err := ioutil.TempDir("", "http-cache-test-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(localDir)
+	tiered := TieredExecutionCache{ExecutionDiskCache{Dir: localDir}, cache}
+	if _, ok, err := tiered.Get(ctx, key); err != nil || !ok {
+		t.Fatalf("tiered cache didn't find remote result: ok=%v, err=%v", ok, err)
+	}
+	if _, ok, err := (ExecutionDiskCache{Dir: localDir}).Get(ctx, key); err != nil || !ok {
+		t.Errorf("remote result was not copied to the local cache: ok=%v, err=%v", ok, err)
+	}
+
+	if err := tiered.Clear(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := cache.Get(ctx, key); err != nil || ok {
+		t.Errorf("cleared result still found: ok=%v, err=%v", ok, err)
+	}
+
+	unauthorized := ExecutionHTTPCache{URL: ts.URL}
+	if _, _, err := unauthorized.Get(ctx, key); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("request without token didn't fail with 401: %v", err)
+	}
+}
+
+func TestExecutorUnavailableCache(t *testing.T) {
+	defer setGitIdentity()()
+	archives := newZipArchiveServer(t, map[string]string{"README.md": "# README\n"})
+	defer archives.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "cache unavailable", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	ctx := context.Background()
+	action := Action{Steps: []*ActionStep{{Type: "command", Args: []string{"sh", "-c", "echo hello > hello.txt"}}}}
+	repo := ActionRepo{ID: "1", Name: "github.com/sourcegraph/src-cli", Rev: "deadbeef", BaseRef: "refs/heads/master"}
+	cache := ExecutionHTTPCache{URL: failing.URL}
+
+	logger := NewActionLogger(false, false)
+	executor := NewExecutor(action, 1, logger, ExecutorOpts{Endpoint: archives.URL, Timeout: time.Minute, Cache: cache})
+
+	plan, err := executor.Plan(ctx, []ActionRepo{repo})
+	if err != nil {
+		t.Fatalf("plan failed because of the cache: %s", err)
+	}
+	if plan.Summary.Execute != 1 {
+		t.Errorf("wrong plan summary: %+v", plan.Summary)
+	}
+
+	executor.EnqueueRepo(repo)
+	executor.Start(ctx)
+	if err := executor.Wait(); err != nil {
+		t.Fatalf("execution failed because of the cache: %s", err)
+	}
+	patches := executor.AllPatches()
+	if len(patches) != 1 || !strings.Contains(patches[0].Patch, "+hello") {
+		t.Errorf("patch was not kept: %+v", patches)
+	}
+}
@@ -15,13 +15,34 @@ import (
 type ActionRepoStatus struct {
 	Cached bool
 
+	// Attempts is the number of times the action was executed in the
+	// repository.
+	Attempts int
+
 	LogFile    string
 	EnqueuedAt time.Time
 	StartedAt  time.Time
 	FinishedAt time.Time
 
+	// Steps holds the results of the steps executed in the last attempt.
+	Steps []StepResult
+
 	Patch PatchInput
 	Err   error
+
+	// TimeoutReason describes which timeout was reached if the execution
+	// timed out.
+	TimeoutReason string
+}
+
+// StepResult describes the execution of a single step of an action in a
+// repository.
+type StepResult struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	ExitCode   int       `json:"exitCode"`
+	Skipped    bool      `json:"skipped,omitempty"`
+	TimedOut   bool      `json:"timedOut,omitempty"`
 }
 
 type ExecutorOpts struct {
@@ -31,17 +52,38 @@ type ExecutorOpts struct {
 
 	KeepLogs bool
 	Timeout  time.Duration
+	Retry    RetryPolicy
+
+	// Secrets holds the values of the secrets that environment variables of
+	// steps can refer to.
+	Secrets map[string]string
 
 	ClearCache bool
 	Cache      ExecutionCache
+
+	// Volumes holds the cacheDirs volumes of container steps.
+	Volumes CacheDirVolumes
+
+	// Workspaces creates the workspaces in which the action is executed. If
+	// nil, ZIP archives of the repositories are fetched from Endpoint.
+	Workspaces WorkspaceCreator
+
+	// Journal, if set, records every status transition of the repositories
+	// so that the run can be resumed.
+	Journal *ExecutionJournal
 }
 
 type Executor struct {
 	action Action
 	opt    ExecutorOpts
 
-	reposMu sync.Mutex
-	repos   map[ActionRepo]ActionRepoStatus
+	reposMu  sync.Mutex
+	repos    map[string]ActionRepo       // by repository ID
+	statuses map[string]ActionRepoStatus // by repository ID
+	cancels  map[string]func()           // by repository name
+
+	imageDigestsMu sync.Mutex
+	imageDigests   map[string]string
 
 	par           *parallel.Run
 	doneEnqueuing chan struct{}
@@ -53,14 +95,21 @@ func NewExecutor(action Action, parallelism int, logger *ActionLogger, opt Execu
 	if opt.Cache == nil {
 		opt.Cache = ExecutionNoOpCache{}
 	}
+	if opt.Workspaces == nil {
+		opt.Workspaces = NewZipWorkspaceCreator(opt.Endpoint, opt.AccessToken, opt.AdditionalHeaders)
+	}
 
 	return &Executor{
 		action: action,
 		opt:    opt,
-		repos:  map[ActionRepo]ActionRepoStatus{},
+		repos:  map[string]ActionRepo{},
 		par:    parallel.NewRun(parallelism),
 		logger: logger,
 
+		statuses:     map[string]ActionRepoStatus{},
+		cancels:      map[string]func(){},
+		imageDigests: map[string]string{},
+
 		doneEnqueuing: make(chan struct{}),
 	}
 }
@@ -69,12 +118,24 @@ func (x *Executor) EnqueueRepo(repo ActionRepo) {
 	x.updateRepoStatus(repo, ActionRepoStatus{EnqueuedAt: time.Now()})
 }
 
+// RestoreRepo adds a repository that already finished in a previous,
+// journaled run with the given status. It is not executed again.
+func (x *Executor) RestoreRepo(repo ActionRepo, status ActionRepoStatus) {
+	x.reposMu.Lock()
+	defer x.reposMu.Unlock()
+	x.repos[repo.ID] = repo
+	x.statuses[repo.ID] = status
+}
+
 func (x *Executor) updateRepoStatus(repo ActionRepo, status ActionRepoStatus) {
 	x.reposMu.Lock()
 	defer x.reposMu.Unlock()
 
 	// Perform delta update.
-	prev := x.repos[repo]
+	prev := x.statuses[repo.ID]
+	if status.Attempts == 0 {
+		status.Attempts = prev.Attempts
+	}
 	if status.LogFile == "" {
 		status.LogFile = prev.LogFile
 	}
@@ -87,21 +148,73 @@ func (x *Executor) updateRepoStatus(repo ActionRepo, status ActionRepoStatus) {
 	if status.FinishedAt.IsZero() {
 		status.FinishedAt = prev.FinishedAt
 	}
+	if status.Steps == nil {
+		status.Steps = prev.Steps
+	}
 	if status.Patch == (PatchInput{}) {
 		status.Patch = prev.Patch
 	}
 	if status.Err == nil {
 		status.Err = prev.Err
 	}
+	if status.TimeoutReason == "" {
+		status.TimeoutReason = prev.TimeoutReason
+	}
+
+	x.repos[repo.ID] = repo
+	x.statuses[repo.ID] = status
+
+	if x.opt.Journal != nil {
+		if err := x.opt.Journal.Record(repo, status); err != nil {
+			x.logger.Warnf("Failed to write status of %s to journal: %s\n", repo.Name, err)
+		}
+	}
+}
+
+// Repos returns all repositories that were enqueued or restored.
+func (x *Executor) Repos() []ActionRepo {
+	x.reposMu.Lock()
+	defer x.reposMu.Unlock()
+
+	repos := make([]ActionRepo, 0, len(x.repos))
+	for _, repo := range x.repos {
+		repos = append(repos, repo)
+	}
+	return repos
+}
+
+// RepoStatuses returns the current status of every repository, keyed by
+// repository ID.
+func (x *Executor) RepoStatuses() map[string]ActionRepoStatus {
+	x.reposMu.Lock()
+	defer x.reposMu.Unlock()
+
+	statuses := make(map[string]ActionRepoStatus, len(x.statuses))
+	for id, status := range x.statuses {
+		statuses[id] = status
+	}
+	return statuses
+}
 
-	x.repos[repo] = status
+// CancelRepo cancels the execution of the action in the repository with the
+// given name, without canceling the executions in other repositories. It
+// returns false if the action is not being executed in the repository.
+func (x *Executor) CancelRepo(name string) bool {
+	x.reposMu.Lock()
+	defer x.reposMu.Unlock()
+
+	cancel, ok := x.cancels[name]
+	if ok {
+		cancel()
+	}
+	return ok
 }
 
 func (x *Executor) AllPatches() []PatchInput {
-	patches := make([]PatchInput, 0, len(x.repos))
+	patches := make([]PatchInput, 0, len(x.statuses))
 	x.reposMu.Lock()
 	defer x.reposMu.Unlock()
-	for _, status := range x.repos {
+	for _, status := range x.statuses {
 		if patch := status.Patch; patch != (PatchInput{}) && status.Err == nil {
 			patches = append(patches, status.Patch)
 		}
@@ -112,7 +225,11 @@ func (x *Executor) AllPatches() []PatchInput {
 func (x *Executor) Start(ctx context.Context) {
 	x.reposMu.Lock()
 	allRepos := make([]ActionRepo, 0, len(x.repos))
-	for repo := range x.repos {
+	for id, repo := range x.repos {
+		if !x.statuses[id].FinishedAt.IsZero() {
+			// Restored from a previous run.
+			continue
+		}
 		allRepos = append(allRepos, repo)
 	}
 	x.reposMu.Unlock()
@@ -137,17 +254,39 @@ func (x *Executor) Wait() error {
 }
 
 func (x *Executor) do(ctx context.Context, repo ActionRepo) (err error) {
-	// Check if cached.
-	cacheKey := ExecutionCacheKey{Repo: repo, Runs: x.action.Steps}
+	// Expand the templates in the steps, so that the values they expand to
+	// are part of the cache key.
+	steps, err := x.expandSteps(ctx, repo)
+	if err != nil {
+		return errors.Wrapf(err, "preparing steps for %s", repo.Name)
+	}
+	changeset, err := expandChangeset(x.action.Changeset, repo)
+	if err != nil {
+		return errors.Wrapf(err, "preparing changeset for %s", repo.Name)
+	}
+
+	// Check if cached. The cache is optional, so errors of the cache, e.g. of
+	// an unavailable remote cache, are only logged and treated as misses.
+	cacheKey, err := x.cacheKey(repo, steps)
+	if err != nil {
+		return errors.Wrapf(err, "preparing steps for %s", repo.Name)
+	}
 	if x.opt.ClearCache {
 		if err := x.opt.Cache.Clear(ctx, cacheKey); err != nil {
-			return errors.Wrapf(err, "clearing cache for %s", repo.Name)
+			x.logger.Warnf("Clearing the cache for %s failed: %s\n", repo.Name, err)
 		}
 	} else {
 		if result, ok, err := x.opt.Cache.Get(ctx, cacheKey); err != nil {
-			return errors.Wrapf(err, "checking cache for %s", repo.Name)
+			x.logger.Warnf("Checking the cache for %s failed: %s\n", repo.Name, err)
 		} else if ok {
-			status := ActionRepoStatus{Cached: true, Patch: result}
+			// The changeset isn't cached, so that changing it doesn't
+			// require executing the steps again.
+			if result != (PatchInput{}) {
+				result.Changeset = changeset
+				result.RepositoryName = repo.Name
+			}
+			now := time.Now()
+			status := ActionRepoStatus{Cached: true, StartedAt: now, FinishedAt: now, Patch: result}
 			x.updateRepoStatus(repo, status)
 			x.logger.RepoCacheHit(repo, len(x.action.Steps), status.Patch != PatchInput{})
 			return nil
@@ -156,6 +295,17 @@ func (x *Executor) do(ctx context.Context, repo ActionRepo) (err error) {
 
 	prefix := "action-" + strings.Replace(strings.Replace(repo.Name, "/", "-", -1), "github.com-", "", -1)
 
+	repoCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	x.reposMu.Lock()
+	x.cancels[repo.Name] = cancel
+	x.reposMu.Unlock()
+	defer func() {
+		x.reposMu.Lock()
+		delete(x.cancels, repo.Name)
+		x.reposMu.Unlock()
+	}()
+
 	logFileName, err := x.logger.AddRepo(repo)
 	if err != nil {
 		return errors.Wrapf(err, "failed to setup logging for repo %s", repo.Name)
@@ -166,26 +316,45 @@ func (x *Executor) do(ctx context.Context, repo ActionRepo) (err error) {
 		StartedAt: time.Now(),
 	})
 
-	runCtx, cancel := context.WithTimeout(ctx, x.opt.Timeout)
-	defer cancel()
+	var (
+		patch   []byte
+		results []StepResult
+	)
+	attempts, err := x.opt.Retry.do(repoCtx, func(attempt int) (err error) {
+		x.updateRepoStatus(repo, ActionRepoStatus{Attempts: attempt})
 
-	patch, err := runAction(runCtx, x.opt.Endpoint, x.opt.AccessToken, x.opt.AdditionalHeaders, prefix, repo.Name, repo.Rev, x.action.Steps, x.logger)
+		runCtx, cancel := context.WithTimeout(repoCtx, x.opt.Timeout)
+		defer cancel()
+
+		patch, results, err = runAction(runCtx, x.opt.Workspaces, x.opt.Volumes, prefix, repo, steps, x.action.PatchPolicy, x.opt.Secrets, x.logger)
+		if err != nil && reachedTimeout(runCtx, err) {
+			err = &errTimeoutReached{timeout: x.opt.Timeout}
+		}
+		return err
+	}, func(attempt int, delay time.Duration, err error) {
+		x.logger.RepoRetrying(repo.Name, retryPhase(err), attempt, x.opt.Retry.MaxAttempts, delay, err)
+	})
+	if err != nil && repoCtx.Err() == context.Canceled && ctx.Err() == nil {
+		err = errRepoCanceled
+	}
 	status := ActionRepoStatus{
+		Attempts:   attempts,
 		FinishedAt: time.Now(),
+		Steps:      results,
 	}
 	if len(patch) > 0 {
 		status.Patch = PatchInput{
-			Repository:   repo.ID,
-			BaseRevision: repo.Rev,
-			BaseRef:      repo.BaseRef,
-			Patch:        string(patch),
+			Repository:     repo.ID,
+			BaseRevision:   repo.Rev,
+			BaseRef:        repo.BaseRef,
+			Patch:          string(patch),
+			Changeset:      changeset,
+			RepositoryName: repo.Name,
 		}
 	}
 	if err != nil {
-		if reachedTimeout(runCtx, err) {
-			err = &errTimeoutReached{timeout: x.opt.Timeout}
-		}
 		status.Err = err
+		status.TimeoutReason = timeoutReason(err)
 	}
 
 	x.updateRepoStatus(repo, status)
@@ -198,20 +367,130 @@ func (x *Executor) do(ctx context.Context, repo ActionRepo) (err error) {
 	if err == nil {
 		// We don't use runCtx here because we want to write to the cache even
 		// if we've now reached the timeout
-		if err := x.opt.Cache.Set(ctx, cacheKey, status.Patch); err != nil {
-			return errors.Wrapf(err, "caching result for %s", repo.Name)
+		result := status.Patch
+		result.Changeset = nil
+		if err := x.opt.Cache.Set(ctx, cacheKey, result); err != nil {
+			x.logger.Warnf("Caching the result for %s failed: %s\n", repo.Name, err)
 		}
 	}
 
 	return err
 }
 
+// cacheKey returns the key under which the result of executing the expanded
+// steps in the repository is cached.
+//
+// The file matches of the repository are only part of the key if a step reads
+// the matches file; file matches used in templates are already part of the
+// expanded steps. That way, changes in the search results don't invalidate the
+// cached results of actions that don't use them.
+func (x *Executor) cacheKey(repo ActionRepo, steps []*ActionStep) (ExecutionCacheKey, error) {
+	env, err := stepEnvDigest(steps, x.opt.Secrets)
+	if err != nil {
+		return ExecutionCacheKey{}, err
+	}
+	if !readsMatchesFile(steps) {
+		repo.FileMatches = nil
+	}
+	return ExecutionCacheKey{Repo: repo, Runs: steps, PatchPolicy: x.action.PatchPolicy, Env: env}, nil
+}
+
+// readsMatchesFile returns whether any of the steps refers to the matches
+// file through the SRC_MATCHES_FILE environment variable.
+func readsMatchesFile(steps []*ActionStep) bool {
+	for _, step := range steps {
+		for _, s := range append([]string{step.Script}, step.Args...) {
+			if strings.Contains(s, matchesFileEnv) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expandSteps expands the templates in the steps of the action for the given
+// repository. Images that are only known after expanding the templates are
+// pulled here, instead of in PrepareAction.
+func (x *Executor) expandSteps(ctx context.Context, repo ActionRepo) ([]*ActionStep, error) {
+	steps, err := expandSteps(x.action.Steps, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, step := range steps {
+		if !isTemplate(x.action.Steps[i].Image) {
+			continue
+		}
+		runner, err := stepRunner(step.Type)
+		if err != nil {
+			return nil, err
+		}
+		if r, ok := runner.(imageRunner); ok {
+			if step.ImageContentDigest, err = x.imageContentDigest(ctx, step.Type, r, step.Image); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return steps, nil
+}
+
+// imageContentDigest returns the content digest of the image, pulling it
+// once per step type if necessary.
+func (x *Executor) imageContentDigest(ctx context.Context, typ string, runner imageRunner, image string) (string, error) {
+	x.imageDigestsMu.Lock()
+	defer x.imageDigestsMu.Unlock()
+
+	key := typ + ":" + image
+	if digest, ok := x.imageDigests[key]; ok {
+		return digest, nil
+	}
+
+	digest, err := pullImage(ctx, runner, image, x.opt.Retry, x.logger)
+	if err != nil {
+		return "", err
+	}
+	x.imageDigests[key] = digest
+	return digest, nil
+}
+
+// errRepoCanceled is returned when the execution in a repository was canceled
+// with Executor.CancelRepo.
+var errRepoCanceled = errors.New("Canceled by the user.")
+
 type errTimeoutReached struct{ timeout time.Duration }
 
 func (e *errTimeoutReached) Error() string {
 	return fmt.Sprintf("Timeout reached. Execution took longer than %s.", e.timeout)
 }
 
+// errStepTimeout is returned when a step of an action exceeds its own
+// timeout.
+type errStepTimeout struct {
+	step    int
+	timeout time.Duration
+}
+
+func (e *errStepTimeout) Error() string {
+	return fmt.Sprintf("Timeout reached. Step %d took longer than %s.", e.step, e.timeout)
+}
+
+// timeoutReason returns the reason of the timeout if err was caused by one,
+// and an empty string otherwise.
+func timeoutReason(err error) string {
+	var (
+		stepErr *errStepTimeout
+		repoErr *errTimeoutReached
+	)
+	switch {
+	case errors.As(err, &stepErr):
+		return fmt.Sprintf("step %d exceeded its timeout of %s", stepErr.step, stepErr.timeout)
+	case errors.As(err, &repoErr):
+		return fmt.Sprintf("execution exceeded the timeout of %s", repoErr.timeout)
+	default:
+		return ""
+	}
+}
+
 func reachedTimeout(cmdCtx context.Context, err error) bool {
 	if ee, ok := errors.Cause(err).(*exec.ExitError); ok {
 		if ee.String() == "signal: killed" && cmdCtx.Err() == context.DeadlineExceeded {
@@ -0,0 +1,264 @@
+package campaigns
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	journalRunFile     = "run.json"
+	journalEntriesFile = "journal.jsonl"
+)
+
+// NewRunID returns an identifier for a new action run that can be used to
+// create an ExecutionJournal. It starts with the time, so that the IDs of runs
+// sort by when they were started, and ends with a random suffix, so that runs
+// started in the same second get different IDs.
+func NewRunID() string {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		// Fall back to the sub-second part of the time.
+		return time.Now().UTC().Format("20060102-150405.000000000")
+	}
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// ExecutionJournal records every ActionRepoStatus transition of an Executor
+// on disk, so that an interrupted run can be resumed with LoadJournaledRun.
+type ExecutionJournal struct {
+	RunID string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// JournaledRun is the state of an action run as reconstructed from its
+// ExecutionJournal.
+type JournaledRun struct {
+	ID     string
+	Action Action
+	Repos  []ActionRepo
+
+	// ActionID is the ActionID of the action as it was defined. It is empty
+	// for runs journaled by older versions of src.
+	ActionID string
+
+	// Statuses holds the last recorded status of every repository that was
+	// enqueued before the run stopped, keyed by repository ID.
+	Statuses map[string]ActionRepoStatus
+}
+
+// Done returns whether the given repository finished successfully, or its
+// result was cached, in the journaled run and doesn't need to be executed
+// again.
+func (r *JournaledRun) Done(repo ActionRepo) bool {
+	status, ok := r.Statuses[repo.ID]
+	return ok && (status.Cached || !status.FinishedAt.IsZero()) && status.Err == nil
+}
+
+type journalRun struct {
+	Action   Action       `json:"action"`
+	ActionID string       `json:"actionID,omitempty"`
+	Repos    []ActionRepo `json:"repos"`
+}
+
+type journalEntry struct {
+	Repo ActionRepo `json:"repo"`
+
+	Cached     bool         `json:"cached,omitempty"`
+	Attempts   int          `json:"attempts,omitempty"`
+	LogFile    string       `json:"logFile,omitempty"`
+	EnqueuedAt time.Time    `json:"enqueuedAt"`
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	Steps      []StepResult `json:"steps,omitempty"`
+	Patch      PatchInput   `json:"patch"`
+	Err        string       `json:"error,omitempty"`
+
+	TimeoutReason string `json:"timeoutReason,omitempty"`
+}
+
+// CreateExecutionJournal creates the journal for a new run in dir. The action,
+// its ActionID and the repositories it is executed in are stored alongside the
+// journal.
+func CreateExecutionJournal(dir, runID string, action Action, actionID string, repos []ActionRepo) (*ExecutionJournal, error) {
+	runDir := filepath.Join(dir, runID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "creating journal directory")
+	}
+	if err := os.Mkdir(runDir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "creating journal for run %s", runID)
+	}
+
+	data, err := json.Marshal(journalRun{Action: action, ActionID: actionID, Repos: repos})
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(runDir, journalRunFile), data, 0600); err != nil {
+		return nil, errors.Wrapf(err, "writing journal for run %s", runID)
+	}
+
+	return OpenExecutionJournal(dir, runID)
+}
+
+// OpenExecutionJournal opens the journal of an existing run in dir. New
+// entries are appended to it.
+func OpenExecutionJournal(dir, runID string) (*ExecutionJournal, error) {
+	f, err := os.OpenFile(filepath.Join(dir, runID, journalEntriesFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening journal for run %s", runID)
+	}
+	return &ExecutionJournal{RunID: runID, f: f}, nil
+}
+
+// Record appends the status of the given repository to the journal.
+func (j *ExecutionJournal) Record(repo ActionRepo, status ActionRepoStatus) error {
+	entry := journalEntry{
+		Repo:       repo,
+		Cached:     status.Cached,
+		Attempts:   status.Attempts,
+		LogFile:    status.LogFile,
+		EnqueuedAt: status.EnqueuedAt,
+		StartedAt:  status.StartedAt,
+		FinishedAt: status.FinishedAt,
+		Steps:      status.Steps,
+		Patch:      status.Patch,
+
+		TimeoutReason: status.TimeoutReason,
+	}
+	if status.Err != nil {
+		entry.Err = status.Err.Error()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.f.Write(append(data, '\n'))
+	return err
+}
+
+func (j *ExecutionJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+// LoadJournaledRun reads the journal of the run with the given ID in dir.
+func LoadJournaledRun(dir, runID string) (*JournaledRun, error) {
+	runDir := filepath.Join(dir, runID)
+
+	data, err := ioutil.ReadFile(filepath.Join(runDir, journalRunFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Errorf("no journal found for run %s in %s", runID, dir)
+		}
+		return nil, err
+	}
+
+	var jr journalRun
+	if err := json.Unmarshal(data, &jr); err != nil {
+		return nil, errors.Wrapf(err, "reading journal for run %s", runID)
+	}
+
+	run := &JournaledRun{
+		ID:       runID,
+		Action:   jr.Action,
+		ActionID: jr.ActionID,
+		Repos:    jr.Repos,
+		Statuses: map[string]ActionRepoStatus{},
+	}
+
+	f, err := os.Open(filepath.Join(runDir, journalEntriesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return run, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// The last entry may have been cut off if src was killed while
+			// writing it.
+			continue
+		}
+
+		status := ActionRepoStatus{
+			Cached:     entry.Cached,
+			Attempts:   entry.Attempts,
+			LogFile:    entry.LogFile,
+			EnqueuedAt: entry.EnqueuedAt,
+			StartedAt:  entry.StartedAt,
+			FinishedAt: entry.FinishedAt,
+			Steps:      entry.Steps,
+			Patch:      entry.Patch,
+
+			TimeoutReason: entry.TimeoutReason,
+		}
+		if entry.Err != "" {
+			status.Err = errors.New(entry.Err)
+		}
+		run.Statuses[entry.Repo.ID] = status
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "reading journal for run %s", runID)
+	}
+
+	return run, nil
+}
+
+// EstimateRepoDuration returns the average duration of the successful,
+// uncached executions of the action with the given ActionID in a repository
+// in the runs journaled in dir, and the number of executions it is computed
+// from.
+func EstimateRepoDuration(dir, actionID string) (time.Duration, int, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	var (
+		total   time.Duration
+		samples int
+	)
+	for _, fi := range infos {
+		if !fi.IsDir() {
+			continue
+		}
+		run, err := LoadJournaledRun(dir, fi.Name())
+		if err != nil || run.ActionID != actionID {
+			continue
+		}
+		for _, status := range run.Statuses {
+			if status.Cached || status.Err != nil || status.StartedAt.IsZero() || status.FinishedAt.IsZero() {
+				continue
+			}
+			total += status.FinishedAt.Sub(status.StartedAt)
+			samples++
+		}
+	}
+	if samples == 0 {
+		return 0, 0, nil
+	}
+	return total / time.Duration(samples), samples, nil
+}
@@ -0,0 +1,112 @@
+package campaigns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+)
+
+func TestExecutionJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "action-runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	action := Action{
+		ScopeQuery: "repo:github",
+		Steps:      []*ActionStep{{Type: "command", Args: []string{"echo"}}},
+	}
+	succeeded := ActionRepo{ID: "1", Name: "github.com/a/a", Rev: "deadbeef", BaseRef: "master", FileMatches: []FileMatch{{Path: "README.md"}}}
+	failed := ActionRepo{ID: "2", Name: "github.com/b/b", Rev: "f00b4r", BaseRef: "master"}
+	unfinished := ActionRepo{ID: "3", Name: "github.com/c/c", Rev: "c0ffee", BaseRef: "master"}
+	cached := ActionRepo{ID: "4", Name: "github.com/d/d", Rev: "d00d", BaseRef: "master"}
+	repos := []ActionRepo{succeeded, failed, unfinished, cached}
+
+	j, err := CreateExecutionJournal(dir, "run", action, "action-id", repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	patch := PatchInput{Repository: "1", BaseRevision: "deadbeef", BaseRef: "master", Patch: "diff"}
+	for _, e := range []struct {
+		repo   ActionRepo
+		status ActionRepoStatus
+	}{
+		{succeeded, ActionRepoStatus{EnqueuedAt: now}},
+		{failed, ActionRepoStatus{EnqueuedAt: now}},
+		{unfinished, ActionRepoStatus{EnqueuedAt: now}},
+		{succeeded, ActionRepoStatus{EnqueuedAt: now, StartedAt: now}},
+		{failed, ActionRepoStatus{EnqueuedAt: now, StartedAt: now}},
+		{succeeded, ActionRepoStatus{EnqueuedAt: now, StartedAt: now, FinishedAt: now, Patch: patch}},
+		{failed, ActionRepoStatus{EnqueuedAt: now, StartedAt: now, FinishedAt: now, Err: errors.New("exit status 1")}},
+		// Cache hits were recorded without FinishedAt by older versions.
+		{cached, ActionRepoStatus{EnqueuedAt: now, Cached: true}},
+	} {
+		if err := j.Record(e.repo, e.status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate an entry that was cut off when src was killed.
+	f, err := os.OpenFile(filepath.Join(dir, "run", journalEntriesFile), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"repo":{"ID":"3"`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	run, err := LoadJournaledRun(dir, "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(action.ScopeQuery, run.Action.ScopeQuery); diff != "" {
+		t.Errorf("wrong action (-want +got):\n%s", diff)
+	}
+	if run.ActionID != "action-id" {
+		t.Errorf("wrong action ID %q", run.ActionID)
+	}
+	if diff := cmp.Diff(repos, run.Repos); diff != "" {
+		t.Errorf("wrong repos (-want +got):\n%s", diff)
+	}
+
+	for i, want := range []bool{true, false, false, true} {
+		if have := run.Done(repos[i]); have != want {
+			t.Errorf("wrong done state for %s: have %v; want %v", repos[i].Name, have, want)
+		}
+	}
+
+	if have := run.Statuses[succeeded.ID].Patch; have != patch {
+		t.Errorf("wrong patch: have %+v; want %+v", have, patch)
+	}
+	if err := run.Statuses[failed.ID].Err; err == nil || err.Error() != "exit status 1" {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	if _, err := LoadJournaledRun(dir, "unknown"); err == nil {
+		t.Error("unexpected nil error for unknown run")
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	ids := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := NewRunID()
+		if ids[id] {
+			t.Fatalf("duplicate run ID %s", id)
+		}
+		ids[id] = true
+	}
+}
@@ -0,0 +1,310 @@
+package campaigns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// LintSeverity is the severity of a LintDiagnostic.
+type LintSeverity string
+
+const (
+	// LintError is the severity of problems that make the execution of the
+	// action fail.
+	LintError LintSeverity = "error"
+
+	// LintWarning is the severity of patterns that are probably mistakes.
+	LintWarning LintSeverity = "warning"
+)
+
+// LintDiagnostic is a problem found in an action definition.
+type LintDiagnostic struct {
+	Severity LintSeverity `json:"severity"`
+
+	// Path is the path of the value in the definition that the diagnostic
+	// refers to, e.g. "steps.0.image". It's empty for the whole definition.
+	Path string `json:"path"`
+
+	// Line and Column are the 1-based position of the value in the source
+	// of the definition, or 0 if it's unknown.
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+
+	Message string `json:"message"`
+}
+
+func (d LintDiagnostic) String() string {
+	if d.Path == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Path, d.Message)
+}
+
+// LintOptions configures the checks of LintActionDefinition beyond the ones
+// of the definition itself.
+type LintOptions struct {
+	// CheckEnvironment enables the checks that the images and binaries the
+	// steps need are available on this machine.
+	CheckEnvironment bool
+
+	// Dir is the directory that the paths of step bundles and of the
+	// repositories file of the scope are relative to.
+	Dir string
+
+	// CheckScopeQuery, if set, is called with every scope query of the
+	// action and returns an error if its syntax is invalid.
+	CheckScopeQuery func(ctx context.Context, query string) error
+}
+
+// stepChecker is implemented by StepRunners that can check, without running a
+// step, that what the step needs is available on this machine.
+type stepChecker interface {
+	check(ctx context.Context, step *ActionStep) []stepProblem
+}
+
+// stepProblem is a problem found by a stepChecker in the value at the given
+// path relative to the step, e.g. "image".
+type stepProblem struct {
+	severity LintSeverity
+	path     string
+	message  string
+}
+
+// riskyStepPatterns are the patterns in the args and scripts of steps that are
+// probably mistakes.
+var riskyStepPatterns = []struct {
+	pattern *regexp.Regexp
+	message string
+}{
+	{
+		pattern: regexp.MustCompile(`\brm\s+(-\S+\s+)+/work/?\*?(["'\s;&|)]|$)`),
+		message: "deletes the whole repository in /work, which produces a patch that deletes all files",
+	},
+	{
+		pattern: regexp.MustCompile(`\bgit\s+(-\S+\s+)*push\b`),
+		message: "pushes to a remote, but changes must only be made in the working tree, from which the patch is produced",
+	},
+}
+
+// LintActionDefinition checks the YAML or JSON source of an action definition
+// without executing it. Unlike ValidateActionDefinition, it reports all
+// problems it finds as diagnostics with their position in the source, and also
+// warns about risky patterns in the steps.
+func LintActionDefinition(ctx context.Context, src []byte, opts LintOptions) []LintDiagnostic {
+	positions := newSourcePositions(src)
+
+	var (
+		diags   []LintDiagnostic
+		origins []stepOrigin
+	)
+	add := func(severity LintSeverity, at, message string) {
+		// Values in the steps of bundles are reported at the step that
+		// uses the bundle.
+		at, inBundle := sourceLintPath(origins, at)
+		message = inBundle + message
+		line, column := positions.find(at)
+		diags = append(diags, LintDiagnostic{
+			Severity: severity,
+			Path:     at,
+			Line:     line,
+			Column:   column,
+			Message:  message,
+		})
+	}
+
+	def, err := yaml.YAMLToJSONStrict(src)
+	if err != nil {
+		d := LintDiagnostic{Severity: LintError, Message: err.Error()}
+		d.Line = yamlErrorLine(err)
+		return append(diags, d)
+	}
+
+	def, origins, err = resolveStepBundles(ctx, def, opts.Dir)
+	if err != nil {
+		var bundleErr *stepBundleError
+		if errors.As(err, &bundleErr) {
+			add(LintError, fmt.Sprintf("steps.%d", bundleErr.step), bundleErr.err.Error())
+		} else {
+			add(LintError, "", err.Error())
+		}
+		return diags
+	}
+
+	res, err := validateActionSchema(def)
+	if err != nil {
+		add(LintError, "", err.Error())
+		return diags
+	}
+	for _, e := range res.Errors() {
+		field := e.Field()
+		if field == "(root)" {
+			field = ""
+		}
+		// Point at the property that isn't allowed rather than the object.
+		if prop, ok := e.Details()["property"].(string); ok && e.Type() == "additional_property_not_allowed" {
+			field = joinLintPath(field, prop)
+		}
+		add(LintError, field, e.Description())
+	}
+	if len(diags) > 0 {
+		// The other checks require a definition that matches the schema.
+		return diags
+	}
+
+	var action Action
+	if err := json.Unmarshal(def, &action); err != nil {
+		add(LintError, "", err.Error())
+		return diags
+	}
+	for _, e := range checkActionDefinition(&action) {
+		add(LintError, e.path, e.err.Error())
+	}
+
+	for i, step := range action.Steps {
+		stepPath := fmt.Sprintf("steps.%d", i)
+
+		for _, s := range append([]string{step.Image, step.Script}, step.Args...) {
+			if !isTemplate(s) {
+				continue
+			}
+			if _, err := parseTemplate(s); err != nil {
+				add(LintError, stepPath, "invalid template: "+err.Error())
+			}
+		}
+
+		for j, dir := range step.CacheDirs {
+			// The cache dirs are paths in the container, so they are
+			// always slash-separated.
+			if !path.IsAbs(dir) {
+				add(LintError, fmt.Sprintf("%s.cacheDirs.%d", stepPath, j), fmt.Sprintf("cache dir %q must be an absolute path", dir))
+			}
+		}
+
+		for _, risky := range riskyStepPatterns {
+			if risky.pattern.MatchString(strings.Join(step.Args, " ")) {
+				add(LintWarning, stepPath+".args", risky.message)
+			}
+			if risky.pattern.MatchString(step.Script) {
+				add(LintWarning, stepPath+".script", risky.message)
+			}
+		}
+
+		if !opts.CheckEnvironment {
+			continue
+		}
+		if checker, ok := stepRunners[step.Type].(stepChecker); ok {
+			for _, p := range checker.check(ctx, step) {
+				add(p.severity, joinLintPath(stepPath, p.path), p.message)
+			}
+		}
+	}
+
+	if err := validateChangesetTemplates(action.Changeset); err != nil {
+		add(LintError, "changeset", "invalid template: "+errors.Cause(err).Error())
+	}
+
+	if _, err := action.ScopeRepositories(opts.Dir); err != nil {
+		add(LintError, "scope.repositoriesFile", err.Error())
+	}
+
+	if opts.CheckScopeQuery != nil {
+		if action.ScopeQuery != "" {
+			if err := opts.CheckScopeQuery(ctx, action.ScopeQuery); err != nil {
+				add(LintError, "scopeQuery", err.Error())
+			}
+		}
+		if action.Scope != nil {
+			for i, query := range action.Scope.Queries {
+				if err := opts.CheckScopeQuery(ctx, query); err != nil {
+					add(LintError, fmt.Sprintf("scope.queries.%d", i), err.Error())
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Line < diags[j].Line
+	})
+	return diags
+}
+
+// HasLintErrors returns whether any of the diagnostics is an error.
+func HasLintErrors(diags []LintDiagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == LintError {
+			return true
+		}
+	}
+	return false
+}
+
+func joinLintPath(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	if child == "" {
+		return parent
+	}
+	return parent + "." + child
+}
+
+// sourceLintPath returns the path in the source of the action definition of
+// the value at path in the definition in which the step bundles are resolved.
+// If the value is in the steps of a bundle, the path of the step that uses the
+// bundle and a message prefix with the path in the bundle are returned.
+func sourceLintPath(origins []stepOrigin, path string) (string, string) {
+	if origins == nil || !strings.HasPrefix(path, "steps.") {
+		return path, ""
+	}
+	index, rest := strings.TrimPrefix(path, "steps."), ""
+	if i := strings.Index(index, "."); i >= 0 {
+		index, rest = index[:i], index[i:]
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(origins) {
+		return path, ""
+	}
+
+	o := origins[i]
+	if o.bundle == "" {
+		return fmt.Sprintf("steps.%d%s", o.step, rest), ""
+	}
+	return fmt.Sprintf("steps.%d", o.step), fmt.Sprintf("steps.%d%s of bundle %s: ", o.bundleStep, rest, o.bundle)
+}
+
+var yamlErrorLinePattern = regexp.MustCompile(`\bline (\d+)\b`)
+
+// yamlErrorLine returns the line number in an error returned by the YAML
+// parser, or 0 if it has none.
+func yamlErrorLine(err error) int {
+	m := yamlErrorLinePattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(m[1])
+	return line
+}
+
+// checkStepBinary checks that the binary a step executes is on the PATH, or
+// exists if it's an absolute path. Relative paths refer to files in the
+// repository and aren't checked.
+func checkStepBinary(name, path string) []stepProblem {
+	if isTemplate(name) || strings.Contains(name, "/") && !filepath.IsAbs(name) {
+		return nil
+	}
+	if _, err := exec.LookPath(name); err != nil {
+		return []stepProblem{{severity: LintError, path: path, message: err.Error()}}
+	}
+	return nil
+}
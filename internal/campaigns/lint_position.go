@@ -0,0 +1,207 @@
+package campaigns
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/sourcegraph/jsonx"
+)
+
+// sourcePositions finds the positions of the values in the source of an
+// action definition, given their paths like "steps.0.image". JSON sources
+// are parsed, while YAML sources are only scanned for the keys and sequence
+// items of block style YAML, since the YAML parser doesn't report positions.
+// When the value at a path can't be found, the position of the closest
+// parent that can is used.
+type sourcePositions struct {
+	text []rune
+	json *jsonx.Node
+
+	yaml []yamlLine
+}
+
+// yamlLine is a line of a YAML source that isn't empty or a comment.
+type yamlLine struct {
+	number int    // 1-based
+	indent int    // number of leading spaces
+	text   string // text after the leading spaces
+}
+
+func newSourcePositions(src []byte) *sourcePositions {
+	text := string(src)
+	if strings.HasPrefix(strings.TrimSpace(text), "{") {
+		root, _ := jsonx.ParseTree(text, jsonx.ParseOptions{Comments: true, TrailingCommas: true})
+		return &sourcePositions{text: []rune(text), json: root}
+	}
+
+	p := &sourcePositions{}
+	for i, l := range strings.Split(text, "\n") {
+		l = strings.TrimRight(l, "\r")
+		trimmed := strings.TrimLeft(l, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "---") || trimmed == "..." {
+			continue
+		}
+		p.yaml = append(p.yaml, yamlLine{number: i + 1, indent: len(l) - len(trimmed), text: trimmed})
+	}
+	return p
+}
+
+// find returns the 1-based line and column of the value at path, or 0, 0 if
+// not even the definition itself can be found.
+func (p *sourcePositions) find(path string) (line, column int) {
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, ".")
+	}
+	if p.json != nil {
+		return p.findJSON(segments)
+	}
+	return p.findYAML(segments)
+}
+
+func (p *sourcePositions) findJSON(segments []string) (line, column int) {
+	node, offset := p.json, p.json.Offset
+	for _, seg := range segments {
+		var next *jsonx.Node
+		switch node.Type {
+		case jsonx.Object:
+			for _, prop := range node.Children {
+				if len(prop.Children) == 2 && prop.Children[0].Value == seg {
+					next, offset = prop.Children[1], prop.Offset
+					break
+				}
+			}
+		case jsonx.Array:
+			if i, err := strconv.Atoi(seg); err == nil && i >= 0 && i < len(node.Children) {
+				next = node.Children[i]
+				offset = next.Offset
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+
+	line, column = 1, 1
+	for _, r := range p.text[:offset] {
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+func (p *sourcePositions) findYAML(segments []string) (line, column int) {
+	block := p.yaml
+	if len(block) == 0 {
+		return 0, 0
+	}
+	line, column = block[0].number, block[0].indent+1
+	for _, seg := range segments {
+		var (
+			at    yamlLine
+			child []yamlLine
+			ok    bool
+		)
+		if i, err := strconv.Atoi(seg); err == nil {
+			at, child, ok = yamlItem(block, i)
+		} else {
+			at, child, ok = yamlKey(block, seg)
+		}
+		if !ok {
+			break
+		}
+		line, column = at.number, at.indent+1
+		if len(child) == 0 {
+			break
+		}
+		block = child
+	}
+	return line, column
+}
+
+// yamlKey returns the line of the key in the mapping in block and the lines
+// of its value.
+func yamlKey(block []yamlLine, key string) (yamlLine, []yamlLine, bool) {
+	indent := block[0].indent
+	for i, l := range block {
+		if l.indent != indent {
+			continue
+		}
+		rest, ok := yamlKeyValue(l.text, key)
+		if !ok {
+			continue
+		}
+
+		var value []yamlLine
+		if rest != "" {
+			value = append(value, yamlLine{number: l.number, indent: l.indent + len(l.text) - len(rest), text: rest})
+		}
+		for _, v := range block[i+1:] {
+			// The items of a sequence can have the same indentation as
+			// its key.
+			if v.indent < indent || v.indent == indent && (rest != "" || !isYAMLItem(v.text)) {
+				break
+			}
+			value = append(value, v)
+		}
+		return l, value, true
+	}
+	return yamlLine{}, nil, false
+}
+
+// yamlKeyValue returns the text after the key if the line starts with it.
+func yamlKeyValue(text, key string) (string, bool) {
+	for _, k := range []string{key, `"` + key + `"`, "'" + key + "'"} {
+		if !strings.HasPrefix(text, k) {
+			continue
+		}
+		rest := strings.TrimLeft(text[len(k):], " ")
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			continue
+		}
+		rest = strings.TrimLeft(rest[1:], " ")
+		if strings.HasPrefix(rest, "#") {
+			rest = ""
+		}
+		return rest, true
+	}
+	return "", false
+}
+
+// yamlItem returns the line of the i-th item of the sequence in block and the
+// lines of its value.
+func yamlItem(block []yamlLine, i int) (yamlLine, []yamlLine, bool) {
+	indent := block[0].indent
+	n := 0
+	for j, l := range block {
+		if l.indent != indent || !isYAMLItem(l.text) {
+			continue
+		}
+		if n < i {
+			n++
+			continue
+		}
+
+		var value []yamlLine
+		if rest := strings.TrimLeft(l.text[1:], " "); rest != "" {
+			value = append(value, yamlLine{number: l.number, indent: l.indent + len(l.text) - len(rest), text: rest})
+		}
+		for _, v := range block[j+1:] {
+			if v.indent <= indent {
+				break
+			}
+			value = append(value, v)
+		}
+		return l, value, true
+	}
+	return yamlLine{}, nil, false
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
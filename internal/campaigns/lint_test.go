@@ -0,0 +1,197 @@
+package campaigns
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLintActionDefinition(t *testing.T) {
+	tests := map[string]struct {
+		src  string
+		opts LintOptions
+		want []LintDiagnostic
+	}{
+		"valid": {
+			src: `scopeQuery: repohasfile:go.mod
+steps:
+  - type: command
+    args: ["sh", "-c", "go fmt ./..."]
+`,
+			opts: LintOptions{CheckEnvironment: true},
+		},
+		"yaml syntax": {
+			src: `scopeQuery: x
+steps:
+  - type: command
+   args: [ls]
+`,
+			want: []LintDiagnostic{
+				{Severity: LintError, Line: 3, Message: "yaml: line 3: did not find expected '-' indicator"},
+			},
+		},
+		"schema": {
+			src: `scopeQuery: x
+steps:
+  - type: command
+    args: [ls]
+  - type: command
+    arg: [ls]
+`,
+			want: []LintDiagnostic{
+				{Severity: LintError, Path: "steps.1.arg", Line: 6, Column: 5, Message: "Additional property arg is not allowed"},
+			},
+		},
+		"steps": {
+			src: `# Format the code.
+scopeQuery: x
+steps:
+- type: docker
+  image: golang
+  cacheDirs:
+    - /go/pkg
+    - .cache
+- type: command
+  args:
+    - sh
+    - -c
+    - rm -rf /work/* && git push origin HEAD
+  timeout: 0s
+- type: command
+  args: [src-lint-test-no-such-binary]
+`,
+			opts: LintOptions{CheckEnvironment: false},
+			want: []LintDiagnostic{
+				{Severity: LintError, Path: "steps.0.cacheDirs.1", Line: 8, Column: 5, Message: `cache dir ".cache" must be an absolute path`},
+				{Severity: LintError, Path: "steps.1", Line: 9, Column: 1, Message: `invalid timeout "0s": must be positive`},
+				{Severity: LintWarning, Path: "steps.1.args", Line: 10, Column: 3, Message: riskyStepPatterns[0].message},
+				{Severity: LintWarning, Path: "steps.1.args", Line: 10, Column: 3, Message: riskyStepPatterns[1].message},
+			},
+		},
+		"environment": {
+			src: `{
+  "scopeQuery": "x",
+  "steps": [
+    {"type": "command", "args": ["sh", "-c", "ls"]},
+    {"type": "command", "args": ["src-lint-test-no-such-binary"]},
+    {"type": "command", "args": ["./relative/to/the/repo.sh"]}
+  ]
+}`,
+			opts: LintOptions{CheckEnvironment: true},
+			want: []LintDiagnostic{
+				{Severity: LintError, Path: "steps.1.args.0", Line: 5, Column: 34, Message: `exec: "src-lint-test-no-such-binary": executable file not found in $PATH`},
+			},
+		},
+		"scope query": {
+			src: `{"scopeQuery": "repo:(", "steps": [{"type": "command", "args": ["ls"]}]}`,
+			opts: LintOptions{CheckScopeQuery: func(ctx context.Context, query string) error {
+				return errors.New("invalid query " + query)
+			}},
+			want: []LintDiagnostic{
+				{Severity: LintError, Path: "scopeQuery", Line: 1, Column: 2, Message: "invalid query repo:("},
+			},
+		},
+		"scope": {
+			src: `scope:
+  queries:
+    - lang:go
+    - repo:(
+  repositoriesFile: src-lint-test-no-such-file.txt
+steps:
+  - type: command
+    args: [ls]
+`,
+			opts: LintOptions{CheckScopeQuery: func(ctx context.Context, query string) error {
+				if query == "repo:(" {
+					return errors.New("invalid query " + query)
+				}
+				return nil
+			}},
+			want: []LintDiagnostic{
+				{Severity: LintError, Path: "scope.queries.1", Line: 4, Column: 5, Message: "invalid query repo:("},
+				{Severity: LintError, Path: "scope.repositoriesFile", Line: 5, Column: 3, Message: "reading repositories file: open src-lint-test-no-such-file.txt: no such file or directory"},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			have := LintActionDefinition(context.Background(), []byte(tc.src), tc.opts)
+			if diff := cmp.Diff(tc.want, have); diff != "" {
+				t.Errorf("wrong diagnostics (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLintActionDefinitionBundles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "step-bundles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundle := `{"steps": [{"type": "command", "args": ["ls"]}, {"type": "command", "args": ["git", "push"]}]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "bundle.json"), []byte(bundle), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	src := `scopeQuery: x
+steps:
+  - uses: bundle.json
+  - type: command
+    args: [ls]
+    timeout: 0s
+  - uses: missing.json
+`
+	have := LintActionDefinition(context.Background(), []byte(src), LintOptions{Dir: dir})
+	want := []LintDiagnostic{
+		{Severity: LintError, Path: "steps.2", Line: 7, Column: 3, Message: "reading bundle: open " + filepath.Join(dir, "missing.json") + ": no such file or directory"},
+	}
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("wrong diagnostics (-want +got):\n%s", diff)
+	}
+
+	src = strings.TrimSuffix(src, "  - uses: missing.json\n")
+	have = LintActionDefinition(context.Background(), []byte(src), LintOptions{Dir: dir})
+	want = []LintDiagnostic{
+		{Severity: LintWarning, Path: "steps.0", Line: 3, Column: 3, Message: "steps.1.args of bundle " + filepath.Join(dir, "bundle.json") + ": " + riskyStepPatterns[1].message},
+		{Severity: LintError, Path: "steps.1", Line: 4, Column: 3, Message: `invalid timeout "0s": must be positive`},
+	}
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("wrong diagnostics (-want +got):\n%s", diff)
+	}
+}
+
+func TestSourcePositions(t *testing.T) {
+	src := `scopeQuery: x
+"steps":
+  - type: command
+    args:
+    - ls
+    - -l
+  -
+    type: docker # comment
+    image: alpine
+`
+	positions := newSourcePositions([]byte(src))
+	for path, want := range map[string][2]int{
+		"":               {1, 1},
+		"steps":          {2, 1},
+		"steps.0.args.1": {6, 5},
+		"steps.1":        {7, 3},
+		"steps.1.image":  {9, 5},
+		"steps.1.cpus":   {7, 3},
+		"steps.2.type":   {2, 1},
+	} {
+		line, column := positions.find(path)
+		if have := [2]int{line, column}; have != want {
+			t.Errorf("wrong position of %q: have %v; want %v", path, have, want)
+		}
+	}
+}
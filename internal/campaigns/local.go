@@ -0,0 +1,150 @@
+package campaigns
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LocalRepositoryIDPrefix prefixes the path of a local repository to form its
+// ID, since it has no ID on Sourcegraph. Patch sets can't be created from the
+// patches of local repositories.
+const LocalRepositoryIDPrefix = "local:"
+
+// IsLocalRepositoryID returns whether the repository ID, e.g. of a patch, is
+// the ID of a local repository.
+func IsLocalRepositoryID(id string) bool {
+	return strings.HasPrefix(id, LocalRepositoryIDPrefix)
+}
+
+// LocalRepos returns the local git repositories in which an action is
+// executed instead of the ones matched by its scope query. path is either a
+// git repository or a directory whose subdirectories are git repositories.
+// Actions are executed at the commit currently checked out in each
+// repository. Uncommitted changes are ignored.
+func LocalRepos(ctx context.Context, path string) ([]ActionRepo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isGitRepository(path) {
+		repo, err := localRepo(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		return []ActionRepo{repo}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading local repositories")
+	}
+	var repos []ActionRepo
+	for _, e := range entries {
+		dir := filepath.Join(path, e.Name())
+		if !e.IsDir() || !isGitRepository(dir) {
+			continue
+		}
+		repo, err := localRepo(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+	if len(repos) == 0 {
+		return nil, errors.Errorf("%s is neither a git repository nor contains any", path)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos, nil
+}
+
+func isGitRepository(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+func localRepo(ctx context.Context, dir string) (ActionRepo, error) {
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+
+	rev, err := git("rev-parse", "--verify", "HEAD^{commit}")
+	if err != nil {
+		return ActionRepo{}, errors.Errorf("%s has no commits", dir)
+	}
+	// In a detached HEAD there is no branch to use as base ref.
+	baseRef, err := git("symbolic-ref", "--quiet", "HEAD")
+	if err != nil {
+		baseRef = "HEAD"
+	}
+
+	name := filepath.Base(dir)
+	if remote, err := git("remote", "get-url", "origin"); err == nil {
+		if n := repoNameFromRemote(remote); n != "" {
+			name = n
+		}
+	}
+
+	return ActionRepo{
+		ID:        LocalRepositoryIDPrefix + dir,
+		Name:      name,
+		Rev:       rev,
+		BaseRef:   baseRef,
+		LocalPath: dir,
+	}, nil
+}
+
+// repoNameFromRemote returns the name that a repository cloned from the given
+// remote URL has on Sourcegraph, e.g. "github.com/sourcegraph/src-cli" for
+// "git@github.com:sourcegraph/src-cli.git".
+func repoNameFromRemote(remote string) string {
+	var host, path string
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else if i := strings.Index(remote, ":"); i > 0 && !strings.Contains(remote[:i], "/") && !strings.Contains(remote, "://") {
+		// scp-like syntax: [user@]host:path
+		host, path = remote[:i], remote[i+1:]
+		if j := strings.LastIndex(host, "@"); j >= 0 {
+			host = host[j+1:]
+		}
+	} else {
+		return ""
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || path == "" {
+		return ""
+	}
+	return host + "/" + path
+}
+
+// cloneLocalRepository creates a temporary clone of the local repository at
+// the given revision, which shares the objects of the local repository.
+func cloneLocalRepository(ctx context.Context, localPath, rev, prefix string) (string, error) {
+	volumeDir, err := ioutil.TempDir(tempDirPrefix, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	for _, args := range [][]string{
+		{"clone", "--quiet", "--shared", "--no-checkout", "--", localPath, volumeDir},
+		{"-C", volumeDir, "checkout", "--quiet", "--detach", rev},
+	} {
+		if out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput(); err != nil {
+			os.RemoveAll(volumeDir)
+			return "", errors.Wrapf(err, "'git %s' failed: %s", strings.Join(args, " "), out)
+		}
+	}
+	return volumeDir, nil
+}
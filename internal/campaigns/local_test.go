@@ -0,0 +1,92 @@
+package campaigns
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepoNameFromRemote(t *testing.T) {
+	for remote, want := range map[string]string{
+		"https://github.com/sourcegraph/src-cli.git":       "github.com/sourcegraph/src-cli",
+		"ssh://git@gitlab.example.com:2222/group/sub/repo": "gitlab.example.com/group/sub/repo",
+		"git@github.com:sourcegraph/src-cli.git":           "github.com/sourcegraph/src-cli",
+		"/home/alice/repos/src-cli":                        "",
+		"file:///home/alice/repos/src-cli":                 "",
+	} {
+		if have := repoNameFromRemote(remote); have != want {
+			t.Errorf("wrong name for %q: have %q; want %q", remote, have, want)
+		}
+	}
+}
+
+func TestLocalRepos(t *testing.T) {
+	defer setGitIdentity()()
+
+	dir, err := ioutil.TempDir("", "src-local-repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repoDir := filepath.Join(dir, "src-cli")
+	if err := os.Mkdir(repoDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repoDir, "README.md"), []byte("# README\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"remote", "add", "origin", "git@github.com:sourcegraph/src-cli.git"},
+		{"add", "README.md"},
+		{"commit", "--quiet", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s", strings.Join(args, " "), out)
+		}
+	}
+	// Not a repository, so it's ignored.
+	if err := os.Mkdir(filepath.Join(dir, "other"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := LocalRepos(context.Background(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 1 {
+		t.Fatalf("wrong number of repos: %+v", repos)
+	}
+	repo := repos[0]
+	if repo.Name != "github.com/sourcegraph/src-cli" || repo.LocalPath != repoDir || !IsLocalRepositoryID(repo.ID) || len(repo.Rev) != 40 || !strings.HasPrefix(repo.BaseRef, "refs/heads/") {
+		t.Errorf("wrong repo: %+v", repo)
+	}
+
+	// Uncommitted changes are ignored.
+	if err := ioutil.WriteFile(filepath.Join(repoDir, "README.md"), []byte("# Changed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := NewActionLogger(false, false)
+	if _, err := logger.AddRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.RepoFinished(repo.Name, false, nil)
+
+	steps := []*ActionStep{{Type: "command", Args: []string{"sh", "-c", "echo '## Usage' >> README.md"}}}
+	patch, _, err := runAction(context.Background(), NewZipWorkspaceCreator("", "", nil), CacheDirVolumes{}, "action-test", repo, steps, nil, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- README.md\n+++ README.md\n@@ -1 +1,2 @@\n # README\n+## Usage\n"
+	if !strings.Contains(string(patch), want) {
+		t.Errorf("patch does not contain %q:\n%s", want, patch)
+	}
+}
@@ -2,10 +2,12 @@ package campaigns
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,7 +17,6 @@ import (
 	"github.com/mattn/go-isatty"
 	"github.com/neelance/parallel"
 	"github.com/pkg/errors"
-	"github.com/segmentio/textio"
 )
 
 var (
@@ -27,16 +28,21 @@ var (
 	grey      = color.New(color.FgHiBlack)
 )
 
+// ActionLogger publishes the events of an action run to its EventSinks and
+// writes the log files of the repositories. By default, the events are
+// rendered on stderr with a progress bar.
 type ActionLogger struct {
-	verbose  bool
 	keepLogs bool
 
-	progress *progress
-	out      io.WriteCloser
+	terminal *terminalSink
+	sinks    []EventSink
 
 	mu         sync.Mutex
 	logFiles   map[string]*os.File
 	logWriters map[string]io.Writer
+
+	// redactor replaces secret values in the output, if any are set.
+	redactor *strings.Replacer
 }
 
 func NewActionLogger(verbose, keepLogs bool) *ActionLogger {
@@ -46,75 +52,149 @@ func NewActionLogger(verbose, keepLogs bool) *ActionLogger {
 	}
 
 	progress := new(progress)
-
-	return &ActionLogger{
+	terminal := &terminalSink{
 		verbose:  verbose,
-		keepLogs: keepLogs,
 		progress: progress,
 		out: &progressWriter{
 			p: progress,
 			w: os.Stderr,
 		},
+	}
+
+	return &ActionLogger{
+		keepLogs:   keepLogs,
+		terminal:   terminal,
+		sinks:      []EventSink{terminal},
 		logFiles:   map[string]*os.File{},
 		logWriters: map[string]io.Writer{},
 	}
 }
 
+// AddSink adds a sink that receives all events published from now on. It
+// must not be called while an action is executed.
+func (a *ActionLogger) AddSink(sink EventSink) {
+	a.sinks = append(a.sinks, sink)
+}
+
+// RedactSecrets makes the logger replace the given values with "********" in
+// all events and log files. It must be called before the action is started.
+func (a *ActionLogger) RedactSecrets(values []string) {
+	if len(values) == 0 {
+		return
+	}
+
+	// Replace longer secrets first, in case one secret contains another.
+	sorted := append([]string(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+
+	oldnew := make([]string, 0, 2*len(sorted))
+	for _, v := range sorted {
+		oldnew = append(oldnew, v, "********")
+	}
+	a.redactor = strings.NewReplacer(oldnew...)
+}
+
+// ShowDashboard shows a full-screen Dashboard of the executions on the
+// terminal instead of the progress bar, until the action finished or ctx is
+// canceled. cancel is called when the user cancels the execution in a
+// repository, see Executor.CancelRepo. It must be called before the action
+// is started.
+func (a *ActionLogger) ShowDashboard(ctx context.Context, cancel func(repoName string) bool) (*Dashboard, error) {
+	d, err := openDashboard(os.Stdin, os.Stderr, a.terminal, cancel)
+	if err != nil {
+		return nil, err
+	}
+	for i, sink := range a.sinks {
+		if sink == a.terminal {
+			a.sinks[i] = d
+		}
+	}
+	go d.run(ctx)
+	return d, nil
+}
+
+func (a *ActionLogger) redact(s string) string {
+	if a.redactor == nil {
+		return s
+	}
+	return a.redactor.Replace(s)
+}
+
+func (a *ActionLogger) redactAll(ss []string) []string {
+	if a.redactor == nil || ss == nil {
+		return ss
+	}
+	redacted := make([]string, len(ss))
+	for i, s := range ss {
+		redacted[i] = a.redactor.Replace(s)
+	}
+	return redacted
+}
+
+// publish redacts the event, writes it to the log file of its repository and
+// publishes it to all sinks.
+func (a *ActionLogger) publish(ev Event) {
+	ev.Time = time.Now()
+	ev.Args = a.redactAll(ev.Args)
+	ev.Reason = a.redact(ev.Reason)
+	ev.Line = a.redact(ev.Line)
+	ev.Message = a.redact(ev.Message)
+	ev.Error = a.redact(ev.Error)
+	ev.Errors = a.redactAll(ev.Errors)
+
+	// The output of steps is written to the log file by RepoStdoutStderr.
+	if ev.Repo != "" && ev.Type != EventOutput && ev.Type != EventRepoCacheHit {
+		if w, ok := a.RepoWriter(ev.Repo); ok {
+			_, line := describeEvent(ev, false)
+			fmt.Fprint(w, line)
+		}
+	}
+
+	for _, sink := range a.sinks {
+		sink.Publish(ev)
+	}
+}
+
+func step(i int) *int { return &i }
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
 func (a *ActionLogger) Start(totalSteps int) {
-	a.progress.SetTotalSteps(int64(totalSteps))
+	a.publish(Event{Type: EventActionStarted, Steps: totalSteps})
 }
 
 func (a *ActionLogger) Infof(format string, args ...interface{}) {
-	if a.verbose {
-		a.log("", grey, format, args...)
-	}
+	a.publish(Event{Type: EventMessage, Level: "info", Message: fmt.Sprintf(format, args...)})
 }
 
 func (a *ActionLogger) Warnf(format string, args ...interface{}) {
-	if a.verbose {
-		a.log("", yellow, "WARNING: "+format, args...)
-	}
+	a.publish(Event{Type: EventMessage, Level: "warning", Message: fmt.Sprintf(format, args...)})
 }
 
 func (a *ActionLogger) ActionFailed(err error, patches []PatchInput) {
-	a.out.Close()
-	fmt.Fprintln(os.Stderr)
+	ev := Event{Type: EventActionFailed, Patches: len(patches)}
 	if perr, ok := err.(parallel.Errors); ok {
-		if len(patches) > 0 {
-			yellow.Fprintf(os.Stderr, "✗  Action produced %d patches but failed with %d errors:\n\n", len(patches), len(perr))
-		} else {
-			yellow.Fprintf(os.Stderr, "✗  Action failed with %d errors:\n", len(perr))
-		}
+		ev.Errors = make([]string, 0, len(perr))
 		for _, e := range perr {
-			fmt.Fprintf(os.Stderr, "\t- %s\n", e)
-		}
-		fmt.Println()
-	} else if err != nil {
-		if len(patches) > 0 {
-			yellow.Fprintf(os.Stderr, "✗  Action produced %d patches but failed with error: %s\n\n", len(patches), err)
-		} else {
-			yellow.Fprintf(os.Stderr, "✗  Action failed with error: %s\n\n", err)
+			ev.Errors = append(ev.Errors, e.Error())
 		}
 	} else {
-		grey.Fprintf(os.Stderr, "✗  Action did not produce any patches.\n\n")
+		ev.Error = errorString(err)
 	}
+	a.publish(ev)
 }
 
 func (a *ActionLogger) ActionSuccess(patches []PatchInput) {
-	a.out.Close()
-	fmt.Fprintln(os.Stderr)
-	format := "✔  Action produced %d patches."
-	hiGreen.Fprintf(os.Stderr, format, len(patches))
+	a.publish(Event{Type: EventActionSucceeded, Patches: len(patches)})
 }
 
 func (a *ActionLogger) RepoCacheHit(repo ActionRepo, stepCount int, patchProduced bool) {
-	a.progress.IncStepsComplete(int64(stepCount))
-	if patchProduced {
-		a.progress.IncPatchCount()
-		a.log(repo.Name, boldGreen, "Cached result found: using cached diff.\n")
-		return
-	}
-	a.log(repo.Name, grey, "Cached result found: no diff produced for this repository.\n")
+	a.publish(Event{Type: EventRepoCacheHit, Repo: repo.Name, Rev: repo.Rev, Steps: stepCount, PatchProduced: patchProduced})
 }
 
 func (a *ActionLogger) AddRepo(repo ActionRepo) (string, error) {
@@ -142,30 +222,45 @@ func (a *ActionLogger) RepoWriter(repoName string) (io.Writer, bool) {
 	return w, ok
 }
 
+// InfoPipe returns a writer that publishes the lines written to it as the
+// stdout of the given command, e.g. an image pull.
 func (a *ActionLogger) InfoPipe(prefix string) io.Writer {
-	stdoutPrefix := fmt.Sprintf("%s -> [STDOUT]: ", yellow.Sprint(prefix))
-	stderr := textio.NewPrefixWriter(os.Stderr, stdoutPrefix)
-	return io.Writer(stderr)
+	return &redactingWriter{w: &outputWriter{a: a, source: prefix, stream: "stdout"}, r: a.redactor}
 }
 
+// ErrorPipe is like InfoPipe for stderr.
 func (a *ActionLogger) ErrorPipe(prefix string) io.Writer {
-	stderrPrefix := fmt.Sprintf("%s -> [STDERR]: ", yellow.Sprint(prefix))
-	stderr := textio.NewPrefixWriter(os.Stderr, stderrPrefix)
-	return io.Writer(stderr)
+	return &redactingWriter{w: &outputWriter{a: a, source: prefix, stream: "stderr"}, r: a.redactor}
 }
 
 func (a *ActionLogger) RepoStdoutStderr(repoName string) (io.Writer, io.Writer, bool) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	w, ok := a.logWriters[repoName]
+	if !ok {
+		w = ioutil.Discard
+	}
 
-	stderrPrefix := fmt.Sprintf("%s -> [STDERR]: ", yellow.Sprint(repoName))
-	stderr := textio.NewPrefixWriter(a.out, stderrPrefix)
+	stdout := &outputWriter{a: a, repo: repoName, stream: "stdout"}
+	stderr := &outputWriter{a: a, repo: repoName, stream: "stderr"}
+	return &redactingWriter{w: io.MultiWriter(stdout, w), r: a.redactor},
+		&redactingWriter{w: io.MultiWriter(stderr, w), r: a.redactor},
+		ok
+}
 
-	stdoutPrefix := fmt.Sprintf("%s -> [STDOUT]: ", yellow.Sprint(repoName))
-	stdout := textio.NewPrefixWriter(a.out, stdoutPrefix)
+// outputWriter publishes every line written to it as an output event. It
+// expects to be written complete lines, see redactingWriter.
+type outputWriter struct {
+	a            *ActionLogger
+	repo, source string
+	stream       string
+}
 
-	return io.MultiWriter(stdout, w), io.MultiWriter(stderr, w), ok
+func (w *outputWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		w.a.publish(Event{Type: EventOutput, Repo: w.repo, Source: w.source, Stream: w.stream, Line: line})
+	}
+	return len(p), nil
 }
 
 func (a *ActionLogger) RepoFinished(repoName string, patchProduced bool, actionErr error) error {
@@ -177,18 +272,11 @@ func (a *ActionLogger) RepoFinished(repoName string, patchProduced bool, actionE
 	}
 	a.mu.Unlock()
 
-	if actionErr != nil {
-		if a.keepLogs {
-			a.write(repoName, boldRed, "Action failed: %q (Logfile: %s)\n", actionErr, f.Name())
-		} else {
-			a.write(repoName, boldRed, "Action failed: %q\n", actionErr)
-		}
-	} else if patchProduced {
-		a.progress.IncPatchCount()
-		a.write(repoName, boldGreen, "Finished. Patch produced.\n")
-	} else {
-		a.write(repoName, grey, "Finished. No patch produced.\n")
+	ev := Event{Type: EventRepoFinished, Repo: repoName, PatchProduced: patchProduced, Error: errorString(actionErr)}
+	if actionErr != nil && a.keepLogs {
+		ev.LogFile = f.Name()
 	}
+	a.publish(ev)
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -205,58 +293,139 @@ func (a *ActionLogger) RepoFinished(repoName string, patchProduced bool, actionE
 	return nil
 }
 
+func (a *ActionLogger) RepoRetrying(repoName, phase string, attempt, maxAttempts int, delay time.Duration, err error) {
+	a.publish(Event{Type: EventRepoRetrying, Repo: repoName, Phase: phase, Attempt: attempt, MaxAttempts: maxAttempts, Delay: delay, Error: errorString(err)})
+}
+
 func (a *ActionLogger) RepoStarted(repoName, rev string, steps []*ActionStep) {
-	a.write(repoName, yellow, "Starting action @ %s (%d steps)\n", rev, len(steps))
+	a.publish(Event{Type: EventRepoStarted, Repo: repoName, Rev: rev, Steps: len(steps)})
 }
 
 func (a *ActionLogger) CommandStepStarted(repoName string, step int, args []string) {
-	a.write(repoName, yellow, "%s command %v\n", boldBlack.Sprintf("[Step %d]", step), args)
+	a.publish(Event{Type: EventStepStarted, Repo: repoName, Step: &step, Args: args})
 }
 
 func (a *ActionLogger) CommandStepErrored(repoName string, step int, err error) {
-	a.progress.IncStepsComplete(1)
-	a.progress.IncStepsFailed()
-	a.write(repoName, boldRed, "%s %s.\n", boldBlack.Sprintf("[Step %d]", step), err)
+	a.publish(Event{Type: EventStepFailed, Repo: repoName, Step: &step, Error: errorString(err)})
 }
 
 func (a *ActionLogger) CommandStepDone(repoName string, step int) {
-	a.progress.IncStepsComplete(1)
-	a.write(repoName, yellow, "%s Done.\n", boldBlack.Sprintf("[Step %d]", step))
+	a.publish(Event{Type: EventStepDone, Repo: repoName, Step: &step})
+}
+
+func (a *ActionLogger) StepTimedOut(repoName string, step int, err error) {
+	a.publish(Event{Type: EventStepTimedOut, Repo: repoName, Step: &step, Error: errorString(err)})
 }
 
-func (a *ActionLogger) DockerStepStarted(repoName string, step int, image string) {
-	a.write(repoName, yellow, "%s docker run %s\n", boldBlack.Sprintf("[Step %d]", step), image)
+func (a *ActionLogger) StepSkipped(repoName string, step int, reason string) {
+	a.publish(Event{Type: EventStepSkipped, Repo: repoName, Step: &step, Reason: reason})
 }
 
-func (a *ActionLogger) DockerStepErrored(repoName string, step int, err error, elapsed time.Duration) {
-	a.progress.IncStepsComplete(1)
-	a.progress.IncStepsFailed()
-	a.write(repoName, boldRed, "%s %s. (%s)\n", boldBlack.Sprintf("[Step %d]", step), err, elapsed)
+func (a *ActionLogger) ContainerStepStarted(repoName string, step int, runtime, image string) {
+	a.publish(Event{Type: EventStepStarted, Repo: repoName, Step: &step, Runtime: runtime, Image: image})
 }
 
-func (a *ActionLogger) DockerStepDone(repoName string, step int, elapsed time.Duration) {
-	a.progress.IncStepsComplete(1)
-	a.write(repoName, yellow, "%s Done. (%s)\n", boldBlack.Sprintf("[Step %d]", step), elapsed)
+func (a *ActionLogger) ContainerStepErrored(repoName string, step int, err error, elapsed time.Duration) {
+	a.publish(Event{Type: EventStepFailed, Repo: repoName, Step: &step, Error: errorString(err), Elapsed: elapsed})
+}
+
+func (a *ActionLogger) ContainerStepDone(repoName string, step int, elapsed time.Duration) {
+	a.publish(Event{Type: EventStepDone, Repo: repoName, Step: &step, Elapsed: elapsed})
 }
 
 func (a *ActionLogger) RepoMatches(repoCount int, skipped, unsupported []string) {
-	for _, r := range skipped {
-		a.Infof("Skipping repository %s because we couldn't determine default branch.\n", r)
+	a.publish(Event{Type: EventReposMatched, Matched: repoCount, Skipped: skipped, Unsupported: unsupported})
+}
+
+// terminalSink renders the events as colored text, with a progress bar at
+// the bottom.
+type terminalSink struct {
+	verbose  bool
+	progress *progress
+	out      io.WriteCloser
+}
+
+func (s *terminalSink) Publish(ev Event) {
+	s.progress.record(ev)
+
+	switch ev.Type {
+	case EventActionStarted:
+
+	case EventMessage:
+		if !s.verbose {
+			return
+		}
+		if ev.Level == "warning" {
+			s.log("", yellow, "WARNING: "+ev.Message)
+		} else {
+			s.log("", grey, ev.Message)
+		}
+
+	case EventOutput:
+		prefix := ev.Repo
+		if prefix == "" {
+			prefix = ev.Source
+		}
+		fmt.Fprintf(s.out, "%s -> [%s]: %s\n", yellow.Sprint(prefix), strings.ToUpper(ev.Stream), ev.Line)
+
+	case EventReposMatched:
+		s.reposMatched(ev)
+
+	case EventActionFailed:
+		s.out.Close()
+		fmt.Fprintln(os.Stderr)
+		if ev.Errors != nil {
+			if ev.Patches > 0 {
+				yellow.Fprintf(os.Stderr, "✗  Action produced %d patches but failed with %d errors:\n\n", ev.Patches, len(ev.Errors))
+			} else {
+				yellow.Fprintf(os.Stderr, "✗  Action failed with %d errors:\n", len(ev.Errors))
+			}
+			for _, e := range ev.Errors {
+				fmt.Fprintf(os.Stderr, "\t- %s\n", e)
+			}
+			fmt.Println()
+		} else if ev.Error != "" {
+			if ev.Patches > 0 {
+				yellow.Fprintf(os.Stderr, "✗  Action produced %d patches but failed with error: %s\n\n", ev.Patches, ev.Error)
+			} else {
+				yellow.Fprintf(os.Stderr, "✗  Action failed with error: %s\n\n", ev.Error)
+			}
+		} else {
+			grey.Fprintf(os.Stderr, "✗  Action did not produce any patches.\n\n")
+		}
+
+	case EventActionSucceeded:
+		s.out.Close()
+		fmt.Fprintln(os.Stderr)
+		format := "✔  Action produced %d patches."
+		hiGreen.Fprintf(os.Stderr, format, ev.Patches)
+
+	default:
+		c, line := describeEvent(ev, true)
+		s.log(ev.Repo, c, line)
 	}
-	unsupportedCount := len(unsupported)
+}
+
+func (s *terminalSink) reposMatched(ev Event) {
+	for _, r := range ev.Skipped {
+		if s.verbose {
+			s.log("", grey, fmt.Sprintf("Skipping repository %s because we couldn't determine default branch.\n", r))
+		}
+	}
+	repoCount, unsupportedCount := ev.Matched, len(ev.Unsupported)
 	var matchesStr string
 	if repoCount == 1 {
-		matchesStr = fmt.Sprintf("%d repository matches the scopeQuery.", repoCount)
+		matchesStr = fmt.Sprintf("%d repository is in scope.", repoCount)
 	} else {
 		var warnStr string
 		if repoCount == 0 {
 			warnStr = "WARNING: "
 		}
-		matchesStr = fmt.Sprintf("%s%d repositories match the scopeQuery.", warnStr, repoCount)
+		matchesStr = fmt.Sprintf("%s%d repositories are in scope.", warnStr, repoCount)
 	}
 	if unsupportedCount > 0 {
 		matchesStr += fmt.Sprintf("\n\n%d repositories were filtered out because they are on a codehost not supported by campaigns. (use -include-unsupported to generate patches for them anyway):\n", unsupportedCount)
-		for i, repo := range unsupported {
+		for i, repo := range ev.Unsupported {
 			matchesStr += color.HiYellowString("- %s\n", repo)
 			if i == 10 {
 				matchesStr += fmt.Sprintf("and %d more.\n", unsupportedCount-10)
@@ -268,23 +437,61 @@ func (a *ActionLogger) RepoMatches(repoCount int, skipped, unsupported []string)
 	if repoCount > 0 {
 		color = boldGreen
 	}
-	a.write("", color, "%s\n\n", matchesStr)
+	s.log("", color, matchesStr+"\n\n")
 }
 
-// write writes to the RepoWriter associated with the given repoName and logs the message using the log method.
-func (a *ActionLogger) write(repoName string, c *color.Color, format string, args ...interface{}) {
-	if w, ok := a.RepoWriter(repoName); ok {
-		fmt.Fprintf(w, format, args...)
+// log writes the line, prefixed with the repository if it's not empty.
+func (s *terminalSink) log(repoName string, c *color.Color, line string) {
+	if len(repoName) > 0 {
+		line = fmt.Sprintf("%s -> %s", c.Sprint(repoName), line)
 	}
-	a.log(repoName, c, format, args...)
+	fmt.Fprint(s.out, c.Sprint(line))
 }
 
-// log logs only to stderr, it does not log to our repoWriters.
-func (a *ActionLogger) log(repoName string, c *color.Color, format string, args ...interface{}) {
-	if len(repoName) > 0 {
-		format = fmt.Sprintf("%s -> %s", c.Sprint(repoName), format)
+// redactingWriter replaces secrets in the lines written to it. Incomplete
+// lines are buffered until they are completed or Flush is called, so that
+// secrets split across writes are redacted, too. If r is nil, the lines are
+// written unchanged.
+type redactingWriter struct {
+	w io.Writer
+	r *strings.Replacer
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	if i := bytes.LastIndexByte(w.buf, '\n'); i >= 0 {
+		if _, err := io.WriteString(w.w, w.redact(string(w.buf[:i+1]))); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[i+1:]...)
 	}
-	fmt.Fprintf(a.out, c.Sprintf(format, args...))
+	return len(p), nil
+}
+
+// Flush writes the buffered incomplete line.
+func (w *redactingWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.w, w.redact(string(w.buf)))
+	w.buf = w.buf[:0]
+	return err
+}
+
+func (w *redactingWriter) redact(s string) string {
+	if w.r == nil {
+		return s
+	}
+	return w.r.Replace(s)
 }
 
 type progress struct {
@@ -293,8 +500,15 @@ type progress struct {
 	totalSteps    int64
 	stepsComplete int64
 	stepsFailed   int64
+
+	// repoSteps are the steps completed in the current attempt of every
+	// running repository, which are taken back when it's retried.
+	mu        sync.Mutex
+	repoSteps map[string]*repoStepCount
 }
 
+type repoStepCount struct{ complete, failed int64 }
+
 func (p *progress) SetTotalSteps(n int64) {
 	atomic.StoreInt64(&p.totalSteps, n)
 }
@@ -319,6 +533,44 @@ func (p *progress) IncStepsFailed() {
 	atomic.AddInt64(&p.stepsFailed, 1)
 }
 
+// recordRepoStep counts a completed step of the repository.
+func (p *progress) recordRepoStep(repoName string, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.repoSteps == nil {
+		p.repoSteps = map[string]*repoStepCount{}
+	}
+	count, ok := p.repoSteps[repoName]
+	if !ok {
+		count = &repoStepCount{}
+		p.repoSteps[repoName] = count
+	}
+	count.complete++
+	p.IncStepsComplete(1)
+	if failed {
+		count.failed++
+		p.IncStepsFailed()
+	}
+}
+
+// resetRepoSteps takes back the steps completed in the failed attempt of the
+// repository, since they are executed again when it's retried.
+func (p *progress) resetRepoSteps(repoName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if count, ok := p.repoSteps[repoName]; ok {
+		p.IncStepsComplete(-count.complete)
+		atomic.AddInt64(&p.stepsFailed, -count.failed)
+		delete(p.repoSteps, repoName)
+	}
+}
+
+func (p *progress) repoFinished(repoName string) {
+	p.mu.Lock()
+	delete(p.repoSteps, repoName)
+	p.mu.Unlock()
+}
+
 func (p *progress) PatchCount() int64 {
 	return atomic.LoadInt64(&p.patchCount)
 }
@@ -0,0 +1,107 @@
+package campaigns
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+)
+
+func TestRedactingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewActionLogger(false, false)
+	logger.RedactSecrets([]string{"s3cr3t", "s3cr3t-but-longer"})
+	w := &redactingWriter{w: &buf, r: logger.redactor}
+
+	for _, s := range []string{"token: s3c", "r3t\nother token: s3cr3t-but", "-longer\nno newline s3cr3t"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := "token: ********\nother token: ********\n"; buf.String() != want {
+		t.Errorf("wrong output before flush: have %q; want %q", buf.String(), want)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "token: ********\nother token: ********\nno newline ********"; buf.String() != want {
+		t.Errorf("wrong output after flush: have %q; want %q", buf.String(), want)
+	}
+}
+
+func TestActionLoggerEvents(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewActionLogger(false, false)
+	logger.RedactSecrets([]string{"s3cr3t"})
+	sink := NewJSONLinesSink(&buf)
+	logger.AddSink(sink)
+
+	repo := ActionRepo{Name: "github.com/a/a", Rev: "deadbeef"}
+	logger.RepoCacheHit(repo, 2, true)
+	logger.CommandStepStarted("github.com/a/a", 0, []string{"echo", "s3cr3t"})
+	logger.StepSkipped("github.com/a/a", 1, "condition is false")
+	logger.ContainerStepDone("github.com/a/a", 1, 1500*time.Millisecond)
+	logger.RepoRetrying("github.com/a/a", "fetching the archive", 2, 3, 5*time.Second, errors.New("HTTP 502"))
+	logger.ActionSuccess(nil)
+	if err := sink.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{`"elapsedSeconds":1.5`, `"delaySeconds":5`} {
+		if !strings.Contains(buf.String(), field) {
+			t.Errorf("events do not contain %s:\n%s", field, buf.String())
+		}
+	}
+
+	var events []Event
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var ev Event
+		if err := dec.Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		ev.Time = time.Time{}
+		events = append(events, ev)
+	}
+
+	want := []Event{
+		{Type: EventRepoCacheHit, Repo: "github.com/a/a", Rev: "deadbeef", Steps: 2, PatchProduced: true},
+		{Type: EventStepStarted, Repo: "github.com/a/a", Step: step(0), Args: []string{"echo", "********"}},
+		{Type: EventStepSkipped, Repo: "github.com/a/a", Step: step(1), Reason: "condition is false"},
+		{Type: EventStepDone, Repo: "github.com/a/a", Step: step(1), Elapsed: 1500 * time.Millisecond},
+		{Type: EventRepoRetrying, Repo: "github.com/a/a", Phase: "fetching the archive", Attempt: 2, MaxAttempts: 3, Delay: 5 * time.Second, Error: "HTTP 502"},
+		{Type: EventActionSucceeded},
+	}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("wrong events (-want +got):\n%s", diff)
+	}
+}
+
+func TestProgressRetry(t *testing.T) {
+	p := new(progress)
+	for _, ev := range []Event{
+		{Type: EventActionStarted, Steps: 4},
+		{Type: EventStepDone, Repo: "github.com/a/a", Step: step(0)},
+		{Type: EventStepDone, Repo: "github.com/b/b", Step: step(0)},
+		{Type: EventStepFailed, Repo: "github.com/a/a", Step: step(1)},
+		{Type: EventRepoRetrying, Repo: "github.com/a/a", Attempt: 2, MaxAttempts: 3},
+		{Type: EventStepDone, Repo: "github.com/a/a", Step: step(0)},
+		{Type: EventStepDone, Repo: "github.com/a/a", Step: step(1)},
+		{Type: EventRepoFinished, Repo: "github.com/a/a"},
+		{Type: EventStepSkipped, Repo: "github.com/b/b", Step: step(1)},
+		{Type: EventRepoFinished, Repo: "github.com/b/b"},
+	} {
+		p.record(ev)
+	}
+	if have, want := p.StepsComplete(), int64(4); have != want {
+		t.Errorf("wrong steps complete: have %d; want %d", have, want)
+	}
+	if have := p.TotalStepsFailed(); have != 0 {
+		t.Errorf("wrong steps failed: have %d; want 0", have)
+	}
+}
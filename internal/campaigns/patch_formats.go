@@ -0,0 +1,454 @@
+package campaigns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The formats patches can be written in and read from.
+const (
+	// PatchFormatJSON is a JSON array of PatchInputs, like patches.json.
+	PatchFormatJSON = "json"
+
+	// PatchFormatDir is a directory with a <repository>/<name>.patch file in
+	// the format of `git format-patch` for every repository.
+	PatchFormatDir = "dir"
+
+	// PatchFormatMbox is an mbox of `git format-patch` style emails, one for
+	// every repository.
+	PatchFormatMbox = "mbox"
+
+	// PatchFormatDiff is a single unified diff, in which the diff of every
+	// repository is preceded by comment lines with its metadata.
+	PatchFormatDiff = "diff"
+)
+
+// PatchFormats are all formats that patches can be written in.
+var PatchFormats = []string{PatchFormatJSON, PatchFormatDir, PatchFormatMbox, PatchFormatDiff}
+
+// The metadata of a patch is written as headers with these names. In emails,
+// they are prefixed with emailHeaderPrefix, in diffs they're comment lines.
+const (
+	patchHeaderRepository     = "Repository"
+	patchHeaderRepositoryName = "Repository-Name"
+	patchHeaderBaseRevision   = "Base-Revision"
+	patchHeaderBaseRef        = "Base-Ref"
+	patchHeaderChangeset      = "Changeset"
+
+	emailHeaderPrefix = "X-Sourcegraph-"
+)
+
+// mboxFromLine starts every email, like in the output of `git format-patch`.
+const mboxFromLine = "From 0000000000000000000000000000000000000000 Mon Sep 17 00:00:00 2001\n"
+
+var mboxSeparator = regexp.MustCompile(`(?m)^From [0-9a-f]{40} Mon Sep 17 00:00:00 2001\n`)
+
+// defaultPatchAuthor is the sender of the emails of patches whose changeset
+// has no author.
+var defaultPatchAuthor = ChangesetAuthor{Name: "src actions", Email: "noreply@sourcegraph.com"}
+
+// WritePatches writes the patches to w in the given format, which must not be
+// PatchFormatDir, see WritePatchDir.
+func WritePatches(w io.Writer, format string, patches []PatchInput) error {
+	switch format {
+	case PatchFormatJSON:
+		return json.NewEncoder(w).Encode(patches)
+	case PatchFormatMbox:
+		for _, p := range sortedPatches(patches) {
+			email, err := patchEmail(p)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, email); err != nil {
+				return err
+			}
+		}
+		return nil
+	case PatchFormatDiff:
+		for _, p := range sortedPatches(patches) {
+			headers, err := patchHeaders(p)
+			if err != nil {
+				return err
+			}
+			for _, h := range headers {
+				if _, err := fmt.Fprintf(w, "# %s: %s\n", h[0], h[1]); err != nil {
+					return err
+				}
+			}
+			if _, err := io.WriteString(w, p.Patch); err != nil {
+				return err
+			}
+		}
+		return nil
+	case PatchFormatDir:
+		return errors.New("patches in the dir format must be written with WritePatchDir")
+	default:
+		return errors.Errorf("unknown patch format %q", format)
+	}
+}
+
+// WritePatchDir writes every patch to a <repository>/<name>.patch file in
+// dir, in the format of `git format-patch`. The repository is its name, or its
+// ID if the name is unknown, but never the path of a local repository. If
+// patches would be written to the same file, e.g. because local repositories
+// have the same base name, a numeric suffix is added to the name.
+func WritePatchDir(dir string, patches []PatchInput) error {
+	written := make(map[string]bool, len(patches))
+	for _, p := range sortedPatches(patches) {
+		email, err := patchEmail(p)
+		if err != nil {
+			return err
+		}
+
+		repo := p.RepositoryName
+		if repo == "" && IsLocalRepositoryID(p.Repository) {
+			repo = filepath.Base(strings.TrimPrefix(p.Repository, LocalRepositoryIDPrefix))
+		} else if repo == "" {
+			repo = p.Repository
+		}
+		name := "0001-" + patchFileName(patchSubject(p))
+		path := filepath.Join(dir, filepath.FromSlash(repo), name+".patch")
+		for i := 2; written[path]; i++ {
+			path = filepath.Join(dir, filepath.FromSlash(repo), fmt.Sprintf("%s-%d.patch", name, i))
+		}
+		written[path] = true
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(email), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadPatches reads the patches in the given format from r, which must not be
+// PatchFormatDir, see ReadPatchDir.
+func ReadPatches(r io.Reader, format string) ([]PatchInput, error) {
+	switch format {
+	case PatchFormatJSON:
+		var patches []PatchInput
+		if err := json.NewDecoder(r).Decode(&patches); err != nil {
+			return nil, err
+		}
+		return patches, nil
+	case PatchFormatMbox:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		var patches []PatchInput
+		for _, email := range mboxSeparator.Split(string(data), -1) {
+			if strings.TrimSpace(email) == "" {
+				continue
+			}
+			p, err := parsePatchEmail(email)
+			if err != nil {
+				return nil, err
+			}
+			patches = append(patches, p)
+		}
+		return patches, nil
+	case PatchFormatDiff:
+		return parsePatchDiff(r)
+	case PatchFormatDir:
+		return nil, errors.New("patches in the dir format must be read with ReadPatchDir")
+	default:
+		return nil, errors.Errorf("unknown patch format %q", format)
+	}
+}
+
+// ReadPatchDir reads the patches from the .patch files in dir, which were
+// written by WritePatchDir or `git format-patch`.
+func ReadPatchDir(dir string) ([]PatchInput, error) {
+	var patches []PatchInput
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".patch" {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		p, err := parsePatchEmail(mboxSeparator.ReplaceAllString(string(data), ""))
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+		patches = append(patches, p)
+		return nil
+	})
+	return patches, err
+}
+
+// sortedPatches returns the patches sorted by repository, so that the output
+// doesn't depend on the order in which the executions finished.
+func sortedPatches(patches []PatchInput) []PatchInput {
+	sorted := append([]PatchInput(nil), patches...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].RepositoryName != sorted[j].RepositoryName {
+			return sorted[i].RepositoryName < sorted[j].RepositoryName
+		}
+		return sorted[i].Repository < sorted[j].Repository
+	})
+	return sorted
+}
+
+// patchHeaders returns the names and values of the headers that hold the
+// metadata of the patch.
+func patchHeaders(p PatchInput) ([][2]string, error) {
+	headers := [][2]string{{patchHeaderRepository, p.Repository}}
+	if p.RepositoryName != "" {
+		headers = append(headers, [2]string{patchHeaderRepositoryName, p.RepositoryName})
+	}
+	headers = append(headers,
+		[2]string{patchHeaderBaseRevision, p.BaseRevision},
+		[2]string{patchHeaderBaseRef, p.BaseRef},
+	)
+	if p.Changeset != nil {
+		changeset, err := json.Marshal(p.Changeset)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, [2]string{patchHeaderChangeset, string(changeset)})
+	}
+	return headers, nil
+}
+
+// setPatchHeader sets the field of the patch that the header holds.
+func setPatchHeader(p *PatchInput, name, value string) error {
+	switch name {
+	case patchHeaderRepository:
+		p.Repository = value
+	case patchHeaderRepositoryName:
+		p.RepositoryName = value
+	case patchHeaderBaseRevision:
+		p.BaseRevision = value
+	case patchHeaderBaseRef:
+		p.BaseRef = value
+	case patchHeaderChangeset:
+		p.Changeset = &Changeset{}
+		return errors.Wrap(json.Unmarshal([]byte(value), p.Changeset), "invalid changeset")
+	}
+	return nil
+}
+
+// patchSubject returns the subject of the email of the patch, which is the
+// first line of the commit message of its changeset.
+func patchSubject(p PatchInput) string {
+	if p.Changeset == nil || p.Changeset.CommitMessage == "" {
+		return "Apply action"
+	}
+	return strings.SplitN(p.Changeset.CommitMessage, "\n", 2)[0]
+}
+
+var nonFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._]+`)
+
+// patchFileName returns the name of the patch file for the subject, like `git
+// format-patch` does.
+func patchFileName(subject string) string {
+	name := strings.Trim(nonFileNameChars.ReplaceAllString(subject, "-"), "-.")
+	if len(name) > 52 {
+		name = strings.TrimRight(name[:52], "-.")
+	}
+	return name
+}
+
+// patchEmail returns the patch as an email in the format of `git
+// format-patch`. The metadata is kept in X-Sourcegraph- headers.
+func patchEmail(p PatchInput) (string, error) {
+	headers, err := patchHeaders(p)
+	if err != nil {
+		return "", err
+	}
+
+	author := defaultPatchAuthor
+	var body string
+	if p.Changeset != nil {
+		if p.Changeset.Author != nil {
+			author = *p.Changeset.Author
+		}
+		if parts := strings.SplitN(p.Changeset.CommitMessage, "\n", 2); len(parts) == 2 {
+			body = strings.TrimSpace(parts[1])
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(mboxFromLine)
+	fmt.Fprintf(&b, "From: %s\n", (&mail.Address{Name: author.Name, Address: author.Email}).String())
+	fmt.Fprintf(&b, "Subject: [PATCH] %s\n", mime.QEncoding.Encode("utf-8", patchSubject(p)))
+	for _, h := range headers {
+		fmt.Fprintf(&b, "%s%s: %s\n", emailHeaderPrefix, h[0], h[1])
+	}
+	b.WriteString("\n")
+	if body != "" {
+		b.WriteString(body + "\n\n")
+	}
+	b.WriteString("---\n")
+	b.WriteString(p.Patch)
+	b.WriteString("-- \nsrc\n\n")
+	return b.String(), nil
+}
+
+// parsePatchEmail parses an email written by patchEmail, without the mbox From
+// line. The metadata of the patch is read from the X-Sourcegraph- headers,
+// the diff from the body. If the subject, the commit message in the body or
+// the sender were edited, they replace the commit message and author of the
+// changeset.
+func parsePatchEmail(email string) (PatchInput, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(email))
+	if err != nil {
+		return PatchInput{}, errors.Wrap(err, "parsing patch email")
+	}
+
+	var p PatchInput
+	for _, name := range []string{patchHeaderRepository, patchHeaderRepositoryName, patchHeaderBaseRevision, patchHeaderBaseRef, patchHeaderChangeset} {
+		if value := msg.Header.Get(emailHeaderPrefix + name); value != "" {
+			if err := setPatchHeader(&p, name, value); err != nil {
+				return PatchInput{}, err
+			}
+		}
+	}
+	if p.Repository == "" {
+		return PatchInput{}, errors.Errorf("patch email %q has no %s%s header", msg.Header.Get("Subject"), emailHeaderPrefix, patchHeaderRepository)
+	}
+
+	data, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		return PatchInput{}, err
+	}
+	body, diff := splitPatchEmailBody(string(data))
+	p.Patch = diff
+
+	if p.Changeset != nil {
+		if err := updatePatchChangeset(p.Changeset, msg.Header, body); err != nil {
+			return PatchInput{}, err
+		}
+	}
+	return p, nil
+}
+
+var diffStartPattern = regexp.MustCompile(`(?m)^diff --git `)
+
+// splitPatchEmailBody splits the body of a patch email into the body of the
+// commit message and the diff. The diff starts after the last "---" line
+// before the first "diff --git" line, since the commit message can contain
+// "---" lines too, and ends before the signature.
+func splitPatchEmailBody(s string) (body, diff string) {
+	head := s
+	if loc := diffStartPattern.FindStringIndex(s); loc != nil {
+		head = s[:loc[0]]
+	}
+	switch i := strings.LastIndex(head, "\n---\n"); {
+	case i >= 0:
+		body, diff = s[:i], s[i+len("\n---\n"):]
+	case strings.HasPrefix(s, "---\n"):
+		diff = s[len("---\n"):]
+	default:
+		diff = s
+	}
+	if i := strings.LastIndex(diff, "\n-- \n"); i >= 0 {
+		diff = diff[:i+1]
+	}
+	return strings.TrimSpace(body), diff
+}
+
+var patchSubjectPrefix = regexp.MustCompile(`^\[PATCH[^\]]*\]\s*`)
+
+// updatePatchChangeset replaces the commit message and author of the
+// changeset with the ones in the email, if they were edited.
+func updatePatchChangeset(c *Changeset, header mail.Header, body string) error {
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		return errors.Wrap(err, "invalid patch email subject")
+	}
+	subject = patchSubjectPrefix.ReplaceAllString(subject, "")
+
+	var oldBody string
+	if parts := strings.SplitN(c.CommitMessage, "\n", 2); len(parts) == 2 {
+		oldBody = strings.TrimSpace(parts[1])
+	}
+	if subject != patchSubject(PatchInput{Changeset: c}) || body != oldBody {
+		c.CommitMessage = subject
+		if body != "" {
+			c.CommitMessage += "\n\n" + body
+		}
+	}
+
+	from, err := header.AddressList("From")
+	if err == mail.ErrHeaderNotPresent || err == nil && len(from) == 0 {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "invalid patch email sender")
+	}
+	author := ChangesetAuthor{Name: from[0].Name, Email: from[0].Address}
+	oldAuthor := defaultPatchAuthor
+	if c.Author != nil {
+		oldAuthor = *c.Author
+	}
+	if author != oldAuthor {
+		c.Author = &author
+	}
+	return nil
+}
+
+// parsePatchDiff parses a diff written by WritePatches in the diff format.
+// Every patch starts with a "# Repository:" comment line.
+func parsePatchDiff(r io.Reader) ([]PatchInput, error) {
+	var (
+		patches   []PatchInput
+		current   *PatchInput
+		inHeaders bool
+		diff      bytes.Buffer
+	)
+	finish := func() {
+		if current != nil {
+			current.Patch = diff.String()
+			patches = append(patches, *current)
+		}
+		diff.Reset()
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if strings.HasPrefix(line, "# "+patchHeaderRepository+": ") {
+				finish()
+				current, inHeaders = &PatchInput{}, true
+			}
+
+			if inHeaders && strings.HasPrefix(line, "# ") {
+				parts := strings.SplitN(strings.TrimSuffix(line[2:], "\n"), ": ", 2)
+				if len(parts) == 2 {
+					if err := setPatchHeader(current, parts[0], parts[1]); err != nil {
+						return nil, err
+					}
+				}
+			} else if current != nil {
+				inHeaders = false
+				diff.WriteString(line)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	finish()
+	return patches, nil
+}
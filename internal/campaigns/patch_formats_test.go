@@ -0,0 +1,209 @@
+package campaigns
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testPatches() []PatchInput {
+	return []PatchInput{
+		{
+			Repository:     "UmVwb3NpdG9yeToy",
+			RepositoryName: "github.com/sourcegraph/sourcegraph",
+			BaseRevision:   "f00b4r",
+			BaseRef:        "refs/heads/main",
+			Patch:          "diff --git a.go a.go\nindex 1..2 100644\n--- a.go\n+++ a.go\n@@ -1 +1 @@\n-package a\n+package b\n",
+			Changeset: &Changeset{
+				Title:         "Rename package a",
+				Body:          "It's called b now.\n\n---\nThanks!",
+				CommitMessage: "Rename package a\n\nThe package is called b now.",
+				Author:        &ChangesetAuthor{Name: "Jörg Müller", Email: "joerg@example.com"},
+			},
+		},
+		{
+			Repository:     "UmVwb3NpdG9yeTox",
+			RepositoryName: "github.com/sourcegraph/src-cli",
+			BaseRevision:   "deadbeef",
+			BaseRef:        "refs/heads/master",
+			Patch:          "diff --git README.md README.md\n--- README.md\n+++ README.md\n@@ -1,2 +1 @@\n # README\n--- \n",
+		},
+	}
+}
+
+func TestPatchFormats(t *testing.T) {
+	patches := testPatches()
+	want := sortedPatches(patches)
+
+	for _, format := range []string{PatchFormatJSON, PatchFormatMbox, PatchFormatDiff} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WritePatches(&buf, format, patches); err != nil {
+				t.Fatal(err)
+			}
+			have, err := ReadPatches(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format == PatchFormatJSON {
+				have = sortedPatches(have)
+			}
+			if diff := cmp.Diff(want, have); diff != "" {
+				t.Errorf("wrong patches (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run(PatchFormatDir, func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "patches")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		if err := WritePatchDir(dir, patches); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{
+			"github.com/sourcegraph/sourcegraph/0001-Rename-package-a.patch",
+			"github.com/sourcegraph/src-cli/0001-Apply-action.patch",
+		} {
+			if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+				t.Errorf("patch file not written: %s", err)
+			}
+		}
+
+		have, err := ReadPatchDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, sortedPatches(have)); diff != "" {
+			t.Errorf("wrong patches (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestWritePatchDirSameFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Local repositories without an origin that have the same base name.
+	patches := []PatchInput{
+		{Repository: LocalRepositoryIDPrefix + "/a/project", BaseRevision: "f00b4r", Patch: "diff --git a.go a.go\n"},
+		{Repository: LocalRepositoryIDPrefix + "/b/project", BaseRevision: "deadbeef", Patch: "diff --git b.go b.go\n"},
+		{Repository: LocalRepositoryIDPrefix + "/c/project", BaseRevision: "c0ffee", Patch: "diff --git c.go c.go\n"},
+	}
+	if err := WritePatchDir(dir, patches); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		"project/0001-Apply-action.patch",
+		"project/0001-Apply-action-2.patch",
+		"project/0001-Apply-action-3.patch",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("patch file not written: %s", err)
+		}
+	}
+
+	have, err := ReadPatchDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(sortedPatches(patches), sortedPatches(have)); diff != "" {
+		t.Errorf("wrong patches (-want +got):\n%s", diff)
+	}
+}
+
+func TestParsePatchEmail(t *testing.T) {
+	p := testPatches()[0]
+	p.Changeset.CommitMessage = "Rename package a\n\nThe package is called b now.\n---\nNot the diff yet."
+	email, err := patchEmail(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbox := mboxSeparator.ReplaceAllString(email, "")
+
+	tests := map[string]struct {
+		edit func(string) string
+		want Changeset
+	}{
+		"unchanged": {
+			edit: func(s string) string { return s },
+			want: *p.Changeset,
+		},
+		"edited": {
+			edit: func(s string) string {
+				s = strings.Replace(s, "Subject: [PATCH] Rename package a", "Subject: [PATCH v2] Rename package a to b", 1)
+				s = strings.Replace(s, "\n---\nNot the diff yet.\n", "\n---\nStill not the diff.\n", 1)
+				return strings.Replace(s, "From: =?utf-8?q?J=C3=B6rg_M=C3=BCller?= <joerg@example.com>", "From: Jane Doe <jane@example.com>", 1)
+			},
+			want: Changeset{
+				Title:         p.Changeset.Title,
+				Body:          p.Changeset.Body,
+				CommitMessage: "Rename package a to b\n\nThe package is called b now.\n---\nStill not the diff.",
+				Author:        &ChangesetAuthor{Name: "Jane Doe", Email: "jane@example.com"},
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			edited := tc.edit(mbox)
+			if edited == mbox && name != "unchanged" {
+				t.Fatalf("email not edited:\n%s", mbox)
+			}
+			have, err := parsePatchEmail(edited)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, *have.Changeset); diff != "" {
+				t.Errorf("wrong changeset (-want +got):\n%s", diff)
+			}
+			if have.Patch != p.Patch {
+				t.Errorf("wrong patch:\nhave %q\nwant %q", have.Patch, p.Patch)
+			}
+		})
+	}
+}
+
+func TestPatchEmailApplies(t *testing.T) {
+	defer setGitIdentity()()
+	ts := newZipArchiveServer(t, map[string]string{"a.go": "package a\n"})
+	defer ts.Close()
+
+	ws, err := NewZipWorkspaceCreator(ts.URL, "", nil).Create(context.Background(), ActionRepo{Name: "github.com/sourcegraph/sourcegraph", Rev: "f00b4r"}, "action-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Remove()
+
+	email, err := patchEmail(testPatches()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbox := filepath.Join(ws.Dir(), "..", filepath.Base(ws.Dir())+".mbox")
+	if err := ioutil.WriteFile(mbox, []byte(email), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(mbox)
+
+	if _, err := runGit(context.Background(), ws.Dir(), "am", "-p0", mbox); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runGit(context.Background(), ws.Dir(), "log", "-1", "--format=%an <%ae>%n%B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Jörg Müller <joerg@example.com>\nRename package a\n\nThe package is called b now.\n"; strings.TrimSpace(string(out)) != strings.TrimSpace(want) {
+		t.Errorf("wrong commit: have %q; want %q", out, want)
+	}
+}
@@ -0,0 +1,124 @@
+package campaigns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+// PatchPolicy restricts the patches an action produces. The execution in a
+// repository whose patch violates the policy fails with an error, instead of
+// producing a patch that breaks the creation of the patch set later.
+type PatchPolicy struct {
+	// Include and Exclude are glob patterns of paths relative to the
+	// repository root, e.g. "vendor/**". Only changes to files that match
+	// Include, or all files if it's empty, and don't match Exclude are kept
+	// in the patch.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+
+	// MaxSize is the maximum size of a patch, e.g. "1MB".
+	MaxSize string `json:"maxSize,omitempty"`
+
+	// AllowBinary is whether patches can change binary files. If nil, they
+	// can.
+	AllowBinary *bool `json:"allowBinary,omitempty"`
+}
+
+// validate checks the parts of the policy that can't be expressed in the
+// schema. A nil policy is valid.
+func (p *PatchPolicy) validate() error {
+	_, err := p.maxSize()
+	return err
+}
+
+// maxSize returns the parsed MaxSize of the policy, or 0 if there is none.
+func (p *PatchPolicy) maxSize() (uint64, error) {
+	if p == nil || p.MaxSize == "" {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(p.MaxSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid maxSize")
+	}
+	return size, nil
+}
+
+// pathspecs returns the git pathspecs that select the changes kept in the
+// patch.
+func (p *PatchPolicy) pathspecs() []string {
+	if p == nil {
+		return nil
+	}
+	specs := make([]string, 0, len(p.Include)+len(p.Exclude))
+	for _, pattern := range p.Include {
+		specs = append(specs, ":(glob)"+pattern)
+	}
+	for _, pattern := range p.Exclude {
+		specs = append(specs, ":(glob,exclude)"+pattern)
+	}
+	return specs
+}
+
+// errPatchPolicy is returned when the patch produced in a repository violates
+// the patchPolicy of the action.
+type errPatchPolicy struct{ reason string }
+
+func (e *errPatchPolicy) Error() string {
+	return fmt.Sprintf("Patch rejected by patchPolicy: %s.", e.reason)
+}
+
+// diffStaged returns the patch of the changes staged in the work tree in dir
+// that are selected by the policy, and checks that it complies with the
+// policy.
+func diffStaged(ctx context.Context, dir string, policy *PatchPolicy) ([]byte, error) {
+	var pathspecs []string
+	if specs := policy.pathspecs(); len(specs) > 0 {
+		pathspecs = append([]string{"--"}, specs...)
+	}
+
+	// As of Sourcegraph 3.14 we only support unified diff format.
+	// That means we need to strip away the `a/` and `/b` prefixes with `--no-prefix`.
+	// See: https://github.com/sourcegraph/sourcegraph/blob/82d5e7e1562fef6be5c0b17f18631040fd330835/enterprise/internal/campaigns/service.go#L324-L329
+	//
+	// Also, we need to add --binary so binary file changes are inlined in the patch.
+	//
+	patch, err := runGit(ctx, dir, append([]string{"diff", "--cached", "--no-prefix", "--binary"}, pathspecs...)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "git diff failed")
+	}
+	if policy == nil || len(patch) == 0 {
+		return patch, nil
+	}
+
+	maxSize, err := policy.maxSize()
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && uint64(len(patch)) > maxSize {
+		return nil, &errPatchPolicy{reason: fmt.Sprintf("it is %s, more than the maxSize of %s", humanize.Bytes(uint64(len(patch))), humanize.Bytes(maxSize))}
+	}
+
+	if policy.AllowBinary != nil && !*policy.AllowBinary {
+		// --numstat prints "-" instead of the added and removed lines of
+		// binary files.
+		numstat, err := runGit(ctx, dir, append([]string{"diff", "--cached", "--numstat"}, pathspecs...)...)
+		if err != nil {
+			return nil, errors.Wrap(err, "git diff failed")
+		}
+		var binaries []string
+		for _, line := range strings.Split(string(numstat), "\n") {
+			if fields := strings.SplitN(line, "\t", 3); len(fields) == 3 && fields[0] == "-" && fields[1] == "-" {
+				binaries = append(binaries, fields[2])
+			}
+		}
+		if len(binaries) > 0 {
+			return nil, &errPatchPolicy{reason: fmt.Sprintf("it changes binary files, which allowBinary forbids: %s", strings.Join(binaries, ", "))}
+		}
+	}
+
+	return patch, nil
+}
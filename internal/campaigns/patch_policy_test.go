@@ -0,0 +1,75 @@
+package campaigns
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRunActionPatchPolicy(t *testing.T) {
+	defer setGitIdentity()()
+	ts := newZipArchiveServer(t, map[string]string{"README.md": "# README\n", "vendor/lib.go": "package lib\n"})
+	defer ts.Close()
+
+	repo := ActionRepo{Name: "github.com/sourcegraph/src-cli", Rev: "deadbeef"}
+	steps := []*ActionStep{
+		{Type: "command", Args: []string{"sh", "-c", "echo '## Usage' >> README.md && echo '// changed' >> vendor/lib.go && printf 'a\\000b' > data.bin"}},
+	}
+
+	disallow := false
+	tests := map[string]struct {
+		policy  *PatchPolicy
+		want    []string
+		wantErr string
+	}{
+		"no policy": {
+			want: []string{"+++ README.md", "+++ vendor/lib.go", "data.bin"},
+		},
+		"exclude": {
+			policy: &PatchPolicy{Exclude: []string{"vendor/**"}},
+			want:   []string{"+++ README.md", "data.bin"},
+		},
+		"include": {
+			policy: &PatchPolicy{Include: []string{"*.md"}, AllowBinary: &disallow},
+			want:   []string{"+++ README.md"},
+		},
+		"binary": {
+			policy:  &PatchPolicy{AllowBinary: &disallow},
+			wantErr: "Patch rejected by patchPolicy: it changes binary files, which allowBinary forbids: data.bin.",
+		},
+		"max size": {
+			policy:  &PatchPolicy{MaxSize: "100B"},
+			wantErr: "more than the maxSize of 100 B",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			logger := NewActionLogger(false, false)
+			if _, err := logger.AddRepo(repo); err != nil {
+				t.Fatal(err)
+			}
+			defer logger.RepoFinished(repo.Name, false, nil)
+
+			patch, _, err := runAction(context.Background(), NewZipWorkspaceCreator(ts.URL, "", nil), CacheDirVolumes{}, "action-test", repo, steps, tc.policy, nil, logger)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("wrong error: have %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, file := range []string{"+++ README.md", "+++ vendor/lib.go", "data.bin"} {
+				want := false
+				for _, w := range tc.want {
+					want = want || w == file
+				}
+				if have := strings.Contains(string(patch), file); have != want {
+					t.Errorf("patch contains %q: have %t; want %t\n%s", file, have, want, patch)
+				}
+			}
+		})
+	}
+}
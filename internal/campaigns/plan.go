@@ -0,0 +1,266 @@
+package campaigns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExecutionPlan describes what executing an action would do, without
+// executing any of its steps. See Executor.Plan.
+type ExecutionPlan struct {
+	ActionID string      `json:"actionID,omitempty"`
+	Summary  PlanSummary `json:"summary"`
+
+	Repositories []PlannedRepo  `json:"repositories"`
+	Images       []PlannedImage `json:"images"`
+
+	// Skipped holds the names of the repositories matched by the scope
+	// query whose default branch couldn't be determined.
+	Skipped []string `json:"skipped"`
+
+	// Unsupported holds the names of the repositories matched by the scope
+	// query that are on code hosts not supported by campaigns.
+	Unsupported []string `json:"unsupported"`
+
+	Estimate PlanEstimate `json:"estimate"`
+}
+
+type PlanSummary struct {
+	Repositories int `json:"repositories"`
+	Execute      int `json:"execute"`
+	Cached       int `json:"cached"`
+	Skipped      int `json:"skipped"`
+	Unsupported  int `json:"unsupported"`
+}
+
+type PlannedRepo struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Rev     string `json:"rev"`
+	BaseRef string `json:"baseRef"`
+
+	// Cached is true if the result is in the cache and the action won't be
+	// executed in the repository.
+	Cached bool `json:"cached"`
+
+	// FileMatches is the number of files matched by the scope query.
+	FileMatches int `json:"fileMatches,omitempty"`
+}
+
+type PlannedImage struct {
+	Type   string `json:"type"`
+	Image  string `json:"image"`
+	Digest string `json:"digest"`
+}
+
+// PlanEstimate is the estimated cost of executing the action in the
+// repositories that aren't cached.
+type PlanEstimate struct {
+	Executions int `json:"executions"`
+	Steps      int `json:"steps"`
+
+	// Samples is the number of executions of the same action in previous
+	// runs that the durations are estimated from. If it's 0, the durations
+	// are unknown.
+	Samples int `json:"samples"`
+
+	// RepoSeconds is the average duration of executing the action in a
+	// repository.
+	RepoSeconds float64 `json:"repoSeconds"`
+
+	// TotalSeconds is the sum of the durations of all executions and
+	// WallSeconds the time they take when executed in parallel.
+	TotalSeconds float64 `json:"totalSeconds"`
+	WallSeconds  float64 `json:"wallSeconds"`
+	Parallelism  int     `json:"parallelism"`
+}
+
+// Plan returns the plan for executing the action in the given repositories:
+// which of them are cached and which images the steps run in. Images of steps
+// that depend on the repository are pulled, like when the action is executed,
+// but no step is executed.
+func (x *Executor) Plan(ctx context.Context, repos []ActionRepo) (*ExecutionPlan, error) {
+	plan := &ExecutionPlan{
+		Repositories: make([]PlannedRepo, 0, len(repos)),
+		Images:       []PlannedImage{},
+		Skipped:      []string{},
+		Unsupported:  []string{},
+	}
+
+	images := map[PlannedImage]bool{}
+	for _, repo := range repos {
+		steps, err := x.expandSteps(ctx, repo)
+		if err != nil {
+			return nil, errors.Wrapf(err, "preparing steps for %s", repo.Name)
+		}
+		for _, step := range steps {
+			if step.ImageContentDigest != "" {
+				images[PlannedImage{Type: step.Type, Image: step.Image, Digest: step.ImageContentDigest}] = true
+			}
+		}
+
+		r := PlannedRepo{
+			ID:          repo.ID,
+			Name:        repo.Name,
+			Rev:         repo.Rev,
+			BaseRef:     repo.BaseRef,
+			FileMatches: len(repo.FileMatches),
+		}
+		if !x.opt.ClearCache {
+			key, err := x.cacheKey(repo, steps)
+			if err != nil {
+				return nil, errors.Wrapf(err, "preparing steps for %s", repo.Name)
+			}
+			_, ok, err := x.opt.Cache.Get(ctx, key)
+			if err != nil {
+				x.logger.Warnf("Checking the cache for %s failed: %s\n", repo.Name, err)
+			}
+			r.Cached = ok
+		}
+
+		plan.Summary.Repositories++
+		if r.Cached {
+			plan.Summary.Cached++
+		} else {
+			plan.Summary.Execute++
+		}
+		plan.Repositories = append(plan.Repositories, r)
+	}
+
+	sort.Slice(plan.Repositories, func(i, j int) bool {
+		return plan.Repositories[i].Name < plan.Repositories[j].Name
+	})
+	for image := range images {
+		plan.Images = append(plan.Images, image)
+	}
+	sort.Slice(plan.Images, func(i, j int) bool {
+		if plan.Images[i].Image != plan.Images[j].Image {
+			return plan.Images[i].Image < plan.Images[j].Image
+		}
+		return plan.Images[i].Type < plan.Images[j].Type
+	})
+
+	plan.Estimate.Executions = plan.Summary.Execute
+	plan.Estimate.Steps = plan.Summary.Execute * len(x.action.Steps)
+	return plan, nil
+}
+
+// AddUnmatched adds the repositories that were matched by the scope query
+// but are not executed to the plan.
+func (p *ExecutionPlan) AddUnmatched(skipped, unsupported []string) {
+	p.Skipped = append(p.Skipped, skipped...)
+	p.Unsupported = append(p.Unsupported, unsupported...)
+	sort.Strings(p.Skipped)
+	sort.Strings(p.Unsupported)
+	p.Summary.Skipped = len(p.Skipped)
+	p.Summary.Unsupported = len(p.Unsupported)
+}
+
+// EstimateDurations estimates the durations of the executions from the
+// average duration of previous executions of the action in a repository and
+// the number of parallel jobs.
+func (p *ExecutionPlan) EstimateDurations(repoDuration time.Duration, samples, parallelism int) {
+	e := &p.Estimate
+	e.Parallelism = parallelism
+	e.Samples = samples
+	if samples == 0 {
+		return
+	}
+
+	e.RepoSeconds = repoDuration.Seconds()
+	e.TotalSeconds = e.RepoSeconds * float64(e.Executions)
+	if parallelism > 0 && e.Executions > 0 {
+		// Executions run in rounds of up to parallelism repositories.
+		rounds := (e.Executions + parallelism - 1) / parallelism
+		e.WallSeconds = e.RepoSeconds * float64(rounds)
+	}
+}
+
+func (p *ExecutionPlan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// WriteText writes the plan in a human readable form.
+func (p *ExecutionPlan) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	s := p.Summary
+	cached := "are"
+	if s.Cached == 1 {
+		cached = "is"
+	}
+	fmt.Fprintf(tw, "%s: %d will be executed and %d %s cached.\n",
+		pluralize(s.Repositories, "repository is in scope", "repositories are in scope"), s.Execute, s.Cached, cached)
+	if s.Skipped > 0 {
+		fmt.Fprintf(tw, "%s skipped because the default branch couldn't be determined.\n", pluralize(s.Skipped, "repository is", "repositories are"))
+	}
+	if s.Unsupported > 0 {
+		fmt.Fprintf(tw, "%s skipped because the code host is not supported by campaigns (use -include-unsupported to execute the action in them anyway).\n", pluralize(s.Unsupported, "repository is", "repositories are"))
+	}
+
+	if len(p.Images) > 0 {
+		fmt.Fprintf(tw, "\nImages:\n")
+		for _, image := range p.Images {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", image.Type, image.Image, image.Digest)
+		}
+	}
+
+	fmt.Fprintf(tw, "\nRepositories:\n")
+	for _, r := range p.Repositories {
+		state := "execute"
+		if r.Cached {
+			state = "cached"
+		}
+		var matches string
+		if r.FileMatches > 0 {
+			matches = pluralize(r.FileMatches, "matched file", "matched files")
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", state, r.Name, r.BaseRef, shortRev(r.Rev), matches)
+	}
+	for _, name := range p.Skipped {
+		fmt.Fprintf(tw, "  skipped\t%s\t\t\t\n", name)
+	}
+	for _, name := range p.Unsupported {
+		fmt.Fprintf(tw, "  unsupported\t%s\t\t\t\n", name)
+	}
+
+	e := p.Estimate
+	fmt.Fprintf(tw, "\nEstimate: %s with %s in total.\n", pluralize(e.Executions, "execution", "executions"), pluralize(e.Steps, "step", "steps"))
+	if e.Samples > 0 && e.Executions > 0 {
+		fmt.Fprintf(tw, "Based on %s of this action, an execution takes %s, %s in total and %s with %d parallel jobs.\n",
+			pluralize(e.Samples, "previous execution", "previous executions"),
+			seconds(e.RepoSeconds), seconds(e.TotalSeconds), seconds(e.WallSeconds), e.Parallelism)
+	} else if e.Executions > 0 {
+		fmt.Fprintf(tw, "The durations can't be estimated because the action wasn't executed before.\n")
+	}
+
+	return tw.Flush()
+}
+
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+func shortRev(rev string) string {
+	if len(rev) > 10 && !strings.ContainsAny(rev, "/.") {
+		return rev[:10]
+	}
+	return rev
+}
+
+func seconds(s float64) time.Duration {
+	return (time.Duration(s * float64(time.Second))).Round(time.Second)
+}
@@ -0,0 +1,139 @@
+package campaigns
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExecutorPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	action := Action{Steps: []*ActionStep{
+		{Type: "command", Args: []string{"gofmt", "-w", "${{ join .Repository.FileMatches \" \" }}"}},
+		{Type: "command", Args: []string{"true"}},
+	}}
+	cached := ActionRepo{ID: "1", Name: "github.com/a/a", Rev: "deadbeefdeadbeef", BaseRef: "refs/heads/master", FileMatches: []FileMatch{{Path: "a.go"}}}
+	uncached := ActionRepo{ID: "2", Name: "github.com/b/b", Rev: "f00b4r", BaseRef: "refs/heads/master"}
+
+	cache := ExecutionDiskCache{Dir: dir}
+	logger := NewActionLogger(false, false)
+	executor := NewExecutor(action, 2, logger, ExecutorOpts{Cache: cache})
+
+	steps, err := expandSteps(action.Steps, cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := executor.cacheKey(cached, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set(ctx, key, PatchInput{Repository: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := executor.Plan(ctx, []ActionRepo{uncached, cached})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.AddUnmatched([]string{"github.com/c/c"}, nil)
+	plan.EstimateDurations(90*time.Second, 4, 2)
+
+	want := &ExecutionPlan{
+		Summary: PlanSummary{Repositories: 2, Execute: 1, Cached: 1, Skipped: 1},
+		Repositories: []PlannedRepo{
+			{ID: "1", Name: "github.com/a/a", Rev: "deadbeefdeadbeef", BaseRef: "refs/heads/master", Cached: true, FileMatches: 1},
+			{ID: "2", Name: "github.com/b/b", Rev: "f00b4r", BaseRef: "refs/heads/master"},
+		},
+		Images:      []PlannedImage{},
+		Skipped:     []string{"github.com/c/c"},
+		Unsupported: []string{},
+		Estimate: PlanEstimate{
+			Executions:   1,
+			Steps:        2,
+			Samples:      4,
+			RepoSeconds:  90,
+			TotalSeconds: 90,
+			WallSeconds:  90,
+			Parallelism:  2,
+		},
+	}
+	if diff := cmp.Diff(want, plan); diff != "" {
+		t.Errorf("wrong plan (-want +got):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	if err := plan.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"2 repositories are in scope: 1 will be executed and 1 is cached.",
+		"1 repository is skipped because the default branch couldn't be determined.",
+		"  cached   github.com/a/a  refs/heads/master  deadbeefde  1 matched file",
+		"  execute  github.com/b/b  refs/heads/master  f00b4r",
+		"  skipped  github.com/c/c",
+		"Estimate: 1 execution with 2 steps in total.",
+		"Based on 4 previous executions of this action, an execution takes 1m30s, 1m30s in total and 1m30s with 2 parallel jobs.",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("plan doesn't contain %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestEstimateRepoDuration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "estimate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, samples, err := EstimateRepoDuration(dir, "action"); err != nil || samples != 0 {
+		t.Fatalf("empty journal: samples=%d, err=%v", samples, err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	repos := []ActionRepo{{ID: "1"}, {ID: "2"}, {ID: "3"}}
+	for _, run := range []struct {
+		id, actionID string
+		statuses     []ActionRepoStatus
+	}{
+		{"run-1", "action", []ActionRepoStatus{
+			{StartedAt: now, FinishedAt: now.Add(1 * time.Minute)},
+			{StartedAt: now, FinishedAt: now.Add(3 * time.Minute)},
+			{Cached: true},
+		}},
+		{"run-2", "other-action", []ActionRepoStatus{
+			{StartedAt: now, FinishedAt: now.Add(time.Hour)},
+		}},
+	} {
+		j, err := CreateExecutionJournal(dir, run.id, Action{}, run.actionID, repos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, status := range run.statuses {
+			if err := j.Record(repos[i], status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		j.Close()
+	}
+
+	d, samples, err := EstimateRepoDuration(dir, "action")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 2*time.Minute || samples != 2 {
+		t.Errorf("wrong estimate: %s from %d samples", d, samples)
+	}
+}
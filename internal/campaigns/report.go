@@ -0,0 +1,269 @@
+package campaigns
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExecutionReport is a machine-readable summary of the execution of an action
+// in a set of repositories.
+type ExecutionReport struct {
+	Summary      ReportSummary `json:"summary"`
+	Repositories []RepoReport  `json:"repositories"`
+}
+
+type ReportSummary struct {
+	Repositories int `json:"repositories"`
+	Succeeded    int `json:"succeeded"`
+	Failed       int `json:"failed"`
+	NotExecuted  int `json:"notExecuted"`
+	TimedOut     int `json:"timedOut"`
+	Cached       int `json:"cached"`
+	Patches      int `json:"patches"`
+}
+
+type RepoReport struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Rev     string `json:"rev"`
+	BaseRef string `json:"baseRef"`
+
+	Cached          bool    `json:"cached"`
+	Attempts        int     `json:"attempts"`
+	DurationSeconds float64 `json:"durationSeconds"`
+
+	// LogFile is only set if the log file still exists, which is the case
+	// for failed repositories or when logs are kept.
+	LogFile string `json:"logFile,omitempty"`
+
+	Steps    []StepReport `json:"steps"`
+	DiffStat DiffStat     `json:"diffStat"`
+	Error    string       `json:"error,omitempty"`
+
+	// TimeoutReason is set if the execution failed because it timed out.
+	TimeoutReason string `json:"timeoutReason,omitempty"`
+
+	finished bool
+}
+
+type StepReport struct {
+	Type            string  `json:"type"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	ExitCode        int     `json:"exitCode"`
+	Skipped         bool    `json:"skipped,omitempty"`
+	TimedOut        bool    `json:"timedOut,omitempty"`
+}
+
+// DiffStat counts the files changed and lines inserted and deleted by a patch.
+type DiffStat struct {
+	Files      int `json:"files"`
+	Insertions int `json:"insertions"`
+	Deletions  int `json:"deletions"`
+}
+
+// NewExecutionReport creates the report for the repositories in which the
+// action was executed, given their statuses keyed by repository ID.
+func NewExecutionReport(action Action, repos []ActionRepo, statuses map[string]ActionRepoStatus) *ExecutionReport {
+	report := &ExecutionReport{Repositories: make([]RepoReport, 0, len(repos))}
+
+	for _, repo := range repos {
+		status := statuses[repo.ID]
+		r := RepoReport{
+			ID:       repo.ID,
+			Name:     repo.Name,
+			Rev:      repo.Rev,
+			BaseRef:  repo.BaseRef,
+			Cached:   status.Cached,
+			Attempts: status.Attempts,
+			Steps:    make([]StepReport, 0, len(status.Steps)),
+			DiffStat: ComputeDiffStat(status.Patch.Patch),
+			finished: status.Cached || !status.FinishedAt.IsZero(),
+		}
+		if !status.StartedAt.IsZero() && !status.FinishedAt.IsZero() {
+			r.DurationSeconds = status.FinishedAt.Sub(status.StartedAt).Seconds()
+		}
+		if status.LogFile != "" {
+			if _, err := os.Stat(status.LogFile); err == nil {
+				r.LogFile = status.LogFile
+			}
+		}
+		for i, step := range status.Steps {
+			s := StepReport{
+				DurationSeconds: step.FinishedAt.Sub(step.StartedAt).Seconds(),
+				ExitCode:        step.ExitCode,
+				Skipped:         step.Skipped,
+				TimedOut:        step.TimedOut,
+			}
+			if i < len(action.Steps) {
+				s.Type = action.Steps[i].Type
+			}
+			r.Steps = append(r.Steps, s)
+		}
+		if status.Err != nil {
+			r.Error = status.Err.Error()
+			r.TimeoutReason = status.TimeoutReason
+		}
+
+		report.Summary.Repositories++
+		switch {
+		case status.Err != nil:
+			report.Summary.Failed++
+			if status.TimeoutReason != "" {
+				report.Summary.TimedOut++
+			}
+		case !r.finished:
+			report.Summary.NotExecuted++
+		default:
+			report.Summary.Succeeded++
+		}
+		if status.Cached {
+			report.Summary.Cached++
+		}
+		if status.Err == nil && status.Patch != (PatchInput{}) {
+			report.Summary.Patches++
+		}
+
+		report.Repositories = append(report.Repositories, r)
+	}
+
+	sort.Slice(report.Repositories, func(i, j int) bool {
+		return report.Repositories[i].Name < report.Repositories[j].Name
+	})
+
+	return report
+}
+
+func (r *ExecutionReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, with a test case for every
+// repository.
+func (r *ExecutionReport) WriteJUnit(w io.Writer) error {
+	suite := junitTestSuite{
+		Name:     "src actions exec",
+		Tests:    r.Summary.Repositories,
+		Failures: r.Summary.Failed,
+		Skipped:  r.Summary.NotExecuted,
+	}
+
+	var total float64
+	for _, repo := range r.Repositories {
+		total += repo.DurationSeconds
+
+		tc := junitTestCase{
+			Name:      repo.Name,
+			Classname: "actions",
+			Time:      junitTime(repo.DurationSeconds),
+		}
+
+		var out []string
+		if repo.Cached {
+			out = append(out, "Cached result found.")
+		}
+		for i, step := range repo.Steps {
+			if step.Skipped {
+				out = append(out, fmt.Sprintf("Step %d (%s): skipped", i, step.Type))
+				continue
+			}
+			if step.TimedOut {
+				out = append(out, fmt.Sprintf("Step %d (%s): timed out after %s", i, step.Type, time.Duration(step.DurationSeconds*float64(time.Second)).Round(time.Millisecond)))
+				continue
+			}
+			out = append(out, fmt.Sprintf("Step %d (%s): exit code %d after %s", i, step.Type, step.ExitCode, time.Duration(step.DurationSeconds*float64(time.Second)).Round(time.Millisecond)))
+		}
+		if repo.DiffStat != (DiffStat{}) {
+			out = append(out, fmt.Sprintf("%d files changed, %d insertions(+), %d deletions(-)", repo.DiffStat.Files, repo.DiffStat.Insertions, repo.DiffStat.Deletions))
+		}
+		if repo.LogFile != "" {
+			out = append(out, "Log file: "+repo.LogFile)
+		}
+		tc.SystemOut = strings.Join(out, "\n")
+
+		switch {
+		case repo.TimeoutReason != "":
+			tc.Failure = &junitMessage{Message: repo.Error, Type: "timeout", Text: repo.TimeoutReason}
+		case repo.Error != "":
+			tc.Failure = &junitMessage{Message: repo.Error, Text: repo.Error}
+		case !repo.finished:
+			tc.Skipped = &junitMessage{Message: "Action was not executed in this repository."}
+		}
+
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = junitTime(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// ComputeDiffStat counts the changes in a patch produced by `git diff`.
+func ComputeDiffStat(patch string) DiffStat {
+	var stat DiffStat
+	inHeader := false
+	for _, line := range strings.Split(patch, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			stat.Files++
+			inHeader = true
+		case strings.HasPrefix(line, "@@"):
+			inHeader = false
+		case inHeader:
+			// Skip the "---" and "+++" lines naming the files.
+		case strings.HasPrefix(line, "+"):
+			stat.Insertions++
+		case strings.HasPrefix(line, "-"):
+			stat.Deletions++
+		}
+	}
+	return stat
+}
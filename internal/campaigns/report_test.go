@@ -0,0 +1,96 @@
+package campaigns
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+)
+
+const testPatch = `diff --git README.md README.md
+index 671e50a..851b23a 100644
+--- README.md
++++ README.md
+@@ -1,3 +1,3 @@
+ # README
+-This is a README.
++This is the README.
+++++ not a file header
+diff --git new.txt new.txt
+new file mode 100644
+index 0000000..3b18e51
+--- /dev/null
++++ new.txt
+@@ -0,0 +1 @@
++hello world
+`
+
+func TestComputeDiffStat(t *testing.T) {
+	want := DiffStat{Files: 2, Insertions: 3, Deletions: 1}
+	if diff := cmp.Diff(want, ComputeDiffStat(testPatch)); diff != "" {
+		t.Errorf("wrong diff stat (-want +got):\n%s", diff)
+	}
+}
+
+func TestNewExecutionReport(t *testing.T) {
+	action := Action{Steps: []*ActionStep{{Type: "command"}, {Type: "docker"}}}
+	t0 := time.Date(2020, 7, 15, 10, 0, 0, 0, time.UTC)
+
+	repos := []ActionRepo{
+		{ID: "1", Name: "github.com/a/a"},
+		{ID: "2", Name: "github.com/b/b"},
+		{ID: "3", Name: "github.com/c/c"},
+		{ID: "4", Name: "github.com/d/d"},
+	}
+	report := NewExecutionReport(action, repos, map[string]ActionRepoStatus{
+		"1": {
+			Attempts:   1,
+			StartedAt:  t0,
+			FinishedAt: t0.Add(3 * time.Second),
+			Steps: []StepResult{
+				{StartedAt: t0, FinishedAt: t0.Add(time.Second)},
+				{StartedAt: t0.Add(time.Second), FinishedAt: t0.Add(3 * time.Second)},
+			},
+			Patch: PatchInput{Repository: "1", Patch: testPatch},
+		},
+		"2": {
+			Attempts:   2,
+			StartedAt:  t0,
+			FinishedAt: t0.Add(time.Second),
+			Steps:      []StepResult{{StartedAt: t0, FinishedAt: t0.Add(time.Second), ExitCode: 1}},
+			Err:        errors.New("run command: exit status 1"),
+		},
+		"3": {Cached: true},
+		"4": {EnqueuedAt: t0},
+	})
+
+	want := ReportSummary{Repositories: 4, Succeeded: 2, Failed: 1, NotExecuted: 1, Cached: 1, Patches: 1}
+	if diff := cmp.Diff(want, report.Summary); diff != "" {
+		t.Errorf("wrong summary (-want +got):\n%s", diff)
+	}
+
+	a := report.Repositories[0]
+	if a.Name != "github.com/a/a" || a.DurationSeconds != 3 || len(a.Steps) != 2 {
+		t.Errorf("wrong report for github.com/a/a: %+v", a)
+	}
+	if diff := cmp.Diff(StepReport{Type: "docker", DurationSeconds: 2}, a.Steps[1]); diff != "" {
+		t.Errorf("wrong step report (-want +got):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJUnit(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<testsuite name="src actions exec" tests="4" failures="1" skipped="1" time="4.000">`,
+		`<failure message="run command: exit status 1">`,
+		`<skipped message="Action was not executed in this repository."></skipped>`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("JUnit report does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package campaigns
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy describes how often and after which delay the execution of an
+// action in a repository is retried when it failed in a retryable phase.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times an action is executed in a
+	// repository. Values smaller than 2 disable retries.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. It's doubled for
+	// every subsequent retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// backoff returns the delay before the given attempt, which is at least 2.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 2; i < attempt && (p.MaxBackoff == 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// do calls fn until it succeeds, returns an error that is not retryable, or
+// the maximum number of attempts is reached. Before every retry, onRetry is
+// called with the number of the upcoming attempt, the delay before it and the
+// error of the previous attempt. It returns the number of attempts made.
+func (p RetryPolicy) do(ctx context.Context, fn func(attempt int) error, onRetry func(attempt int, delay time.Duration, err error)) (int, error) {
+	attempt := 1
+	for {
+		err := fn(attempt)
+		if err == nil || !isRetryable(err) || attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		attempt++
+		delay := p.backoff(attempt)
+		onRetry(attempt, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return attempt - 1, err
+		}
+	}
+}
+
+// retryableError marks errors that occurred in a phase of an action run that
+// can succeed when it's retried, such as fetching the repository archive, as
+// opposed to a step that exited with a non-zero exit code.
+type retryableError struct {
+	// phase describes what failed, e.g. "fetching the archive", and is used
+	// in the retry message.
+	phase string
+	err   error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Cause() error  { return e.err }
+func (e *retryableError) Unwrap() error { return e.err }
+
+func retryable(phase string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{phase: phase, err: err}
+}
+
+func isRetryable(err error) bool {
+	var e *retryableError
+	return errors.As(err, &e)
+}
+
+// retryPhase returns the phase in which the retryable error occurred, or ""
+// if it isn't retryable.
+func retryPhase(err error) string {
+	var e *retryableError
+	if errors.As(err, &e) {
+		return e.phase
+	}
+	return ""
+}
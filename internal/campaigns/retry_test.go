@@ -0,0 +1,82 @@
+package campaigns
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{
+		2: 1 * time.Second,
+		3: 2 * time.Second,
+		4: 4 * time.Second,
+		5: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		if have := p.backoff(attempt); have != want {
+			t.Errorf("wrong backoff for attempt %d: have %s; want %s", attempt, have, want)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3}
+	ctx := context.Background()
+	noop := func(int, time.Duration, error) {}
+
+	t.Run("retryable error", func(t *testing.T) {
+		var phases []string
+		attempts, err := p.do(ctx, func(int) error {
+			return errors.Wrap(retryable("fetching the archive", errors.New("HTTP 502")), "Fetching ZIP archive failed")
+		}, func(_ int, _ time.Duration, err error) {
+			phases = append(phases, retryPhase(err))
+		})
+		if err == nil {
+			t.Error("unexpected nil error")
+		}
+		if attempts != 3 {
+			t.Errorf("wrong number of attempts: have %d; want 3", attempts)
+		}
+		if have, want := strings.Join(phases, ", "), "fetching the archive, fetching the archive"; have != want {
+			t.Errorf("wrong phases: have %q; want %q", have, want)
+		}
+	})
+
+	t.Run("non-retryable error", func(t *testing.T) {
+		attempts, err := p.do(ctx, func(int) error {
+			return errors.New("exit status 1")
+		}, noop)
+		if err == nil {
+			t.Error("unexpected nil error")
+		}
+		if attempts != 1 {
+			t.Errorf("wrong number of attempts: have %d; want 1", attempts)
+		}
+	})
+
+	t.Run("success after retry", func(t *testing.T) {
+		var retries []int
+		attempts, err := p.do(ctx, func(attempt int) error {
+			if attempt == 1 {
+				return retryable("unzipping the archive", errors.New("unexpected EOF"))
+			}
+			return nil
+		}, func(attempt int, _ time.Duration, _ error) {
+			retries = append(retries, attempt)
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+		if attempts != 2 {
+			t.Errorf("wrong number of attempts: have %d; want 2", attempts)
+		}
+		if len(retries) != 1 || retries[0] != 2 {
+			t.Errorf("wrong retries: %v", retries)
+		}
+	})
+}
@@ -2,9 +2,9 @@ package campaigns
 
 import (
 	"archive/zip"
+	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -21,148 +21,173 @@ import (
 	"golang.org/x/net/context/ctxhttp"
 )
 
-func runAction(ctx context.Context, endpoint, accessToken string, additionalHeaders map[string]string, prefix, repoName, rev string, steps []*ActionStep, logger *ActionLogger) ([]byte, error) {
+// matchesFileEnv is the environment variable that holds the path of the file
+// that lists the files and lines that matched the scope query. The file is
+// written outside of the work tree, so that it's never part of the patch.
+const matchesFileEnv = "SRC_MATCHES_FILE"
+
+// containerMatchesFile is the path of the matches file in containers.
+const containerMatchesFile = "/src-matches.json"
+
+func runAction(ctx context.Context, workspaces WorkspaceCreator, volumes CacheDirVolumes, prefix string, repo ActionRepo, steps []*ActionStep, policy *PatchPolicy, secrets map[string]string, logger *ActionLogger) ([]byte, []StepResult, error) {
+	repoName, rev := repo.Name, repo.Rev
 	logger.RepoStarted(repoName, rev, steps)
 
-	zipFile, err := fetchRepositoryArchive(ctx, endpoint, accessToken, additionalHeaders, repoName, rev)
-	if err != nil {
-		return nil, errors.Wrap(err, "Fetching ZIP archive failed")
+	if repo.LocalPath != "" {
+		workspaces = localWorkspaceCreator{}
 	}
-	defer os.Remove(zipFile.Name())
-
-	volumeDir, err := unzipToTempDir(ctx, zipFile.Name(), prefix)
+	ws, err := workspaces.Create(ctx, repo, prefix)
 	if err != nil {
-		return nil, errors.Wrap(err, "Unzipping the ZIP archive failed")
+		return nil, nil, err
 	}
-	defer os.RemoveAll(volumeDir)
+	defer ws.Remove()
+	volumeDir := ws.Dir()
 
 	runGitCmd := func(args ...string) ([]byte, error) {
-		cmd := exec.CommandContext(ctx, "git", args...)
-		cmd.Dir = volumeDir
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			return nil, errors.Wrapf(err, "'git %s' failed: %s", strings.Join(args, " "), out)
-		}
-		return out, nil
+		return runGit(ctx, volumeDir, args...)
 	}
 
-	if _, err := runGitCmd("init"); err != nil {
-		return nil, errors.Wrap(err, "git init failed")
+	matchesFile, err := writeMatchesFile(prefix, repo.FileMatches)
+	if err != nil {
+		return nil, nil, err
 	}
-	// --force because we want previously "gitignored" files in the repository
-	if _, err := runGitCmd("add", "--force", "--all"); err != nil {
-		return nil, errors.Wrap(err, "git add failed")
+	defer os.Remove(matchesFile)
+
+	// To evaluate conditions on the changes made by a step, we compare the
+	// trees of the work tree before and after it ran.
+	detectChanges := needsChangeDetection(steps)
+	writeTree := func() (string, error) {
+		if _, err := runGitCmd("add", "--all"); err != nil {
+			return "", errors.Wrap(err, "git add failed")
+		}
+		out, err := runGitCmd("write-tree")
+		if err != nil {
+			return "", errors.Wrap(err, "git write-tree failed")
+		}
+		return strings.TrimSpace(string(out)), nil
 	}
-	if _, err := runGitCmd("commit", "--quiet", "--all", "-m", "src-action-exec"); err != nil {
-		return nil, errors.Wrap(err, "git commit failed")
+	var tree string
+	if detectChanges {
+		if tree, err = writeTree(); err != nil {
+			return nil, nil, err
+		}
 	}
 
+	cond := conditionEnv{
+		repoName:  repoName,
+		volumeDir: volumeDir,
+		outputs:   map[string]string{},
+	}
+	results := make([]StepResult, 0, len(steps))
 	for i, step := range steps {
-		switch step.Type {
-		case "command":
-			logger.CommandStepStarted(repoName, i, step.Args)
-
-			cmd := exec.CommandContext(ctx, step.Args[0], step.Args[1:]...)
-			cmd.Dir = volumeDir
-
-			if stdout, stderr, ok := logger.RepoStdoutStderr(repoName); ok {
-				cmd.Stdout = stdout
-				cmd.Stderr = stderr
-			}
+		result := StepResult{StartedAt: time.Now()}
 
-			if err := cmd.Run(); err != nil {
-				logger.CommandStepErrored(repoName, i, err)
-				return nil, errors.Wrap(err, "run command")
-			}
-			logger.CommandStepDone(repoName, i)
-
-		case "docker":
-			logger.DockerStepStarted(repoName, i, step.Image)
+		ok, reason, err := step.If.evaluate(cond)
+		if err != nil {
+			return nil, results, errors.Wrapf(err, "evaluating condition of step %d", i)
+		}
+		if !ok {
+			logger.StepSkipped(repoName, i, reason)
+			result.FinishedAt = result.StartedAt
+			result.Skipped = true
+			results = append(results, result)
+			cond.previousStepChangedFiles = false
+			continue
+		}
 
-			cidFile, err := ioutil.TempFile(tempDirPrefix, prefix+"-container-id")
-			if err != nil {
-				return nil, errors.Wrap(err, "Creating a CID file failed")
-			}
-			_ = os.Remove(cidFile.Name()) // docker exits if this file exists upon `docker run` starting
-			defer func() {
-				cid, err := ioutil.ReadFile(cidFile.Name())
-				_ = os.Remove(cidFile.Name())
-				if err == nil {
-					ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
-					defer cancel()
-					_ = exec.CommandContext(ctx, "docker", "rm", "-f", "--", string(cid)).Run()
-				}
-			}()
-
-			const workDir = "/work"
-			cmd := exec.CommandContext(ctx, "docker", "run",
-				"--rm",
-				"--cidfile", cidFile.Name(),
-				"--workdir", workDir,
-				"--mount", fmt.Sprintf("type=bind,source=%s,target=%s", volumeDir, workDir),
-			)
-			for _, cacheDir := range step.CacheDirs {
-				// persistentCacheDir returns a host directory that persists across runs of this
-				// action for this repository. It is useful for (e.g.) yarn and npm caches.
-				persistentCacheDir := func(containerDir string) (string, error) {
-					baseCacheDir, err := UserCacheDir()
-					if err != nil {
-						return "", err
-					}
-					b := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%s", step.Image, repoName, rev)))
-					return filepath.Join(baseCacheDir, "action-exec-cache-dir",
-						base64.RawURLEncoding.EncodeToString(b[:16]),
-						strings.TrimPrefix(cacheDir, string(os.PathSeparator))), nil
-				}
-
-				hostDir, err := persistentCacheDir(cacheDir)
-				if err != nil {
-					return nil, err
-				}
-				if err := os.MkdirAll(hostDir, 0700); err != nil {
-					return nil, err
-				}
-				cmd.Args = append(cmd.Args, "--mount", fmt.Sprintf("type=bind,source=%s,target=%s", hostDir, cacheDir))
-			}
-			cmd.Args = append(cmd.Args, "--", step.Image)
-			cmd.Args = append(cmd.Args, step.Args...)
-			cmd.Dir = volumeDir
+		env, err := resolveStepEnv(step, secrets)
+		if err != nil {
+			return nil, results, errors.Wrapf(err, "step %d", i)
+		}
+		for name, value := range cond.outputs {
+			env = append(env, name+"="+value)
+		}
+		var stdout bytes.Buffer
 
-			if stdout, stderr, ok := logger.RepoStdoutStderr(repoName); ok {
-				cmd.Stdout = stdout
-				cmd.Stderr = stderr
-			}
+		runner, err := stepRunner(step.Type)
+		if err != nil {
+			return nil, results, err
+		}
+		timeout, err := step.timeout()
+		if err != nil {
+			return nil, results, errors.Wrapf(err, "step %d", i)
+		}
+		stepCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout > 0 {
+			stepCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+		err = runner.Run(stepCtx, StepRun{
+			Index:       i,
+			Step:        step,
+			RepoName:    repoName,
+			Rev:         rev,
+			WorkDir:     volumeDir,
+			MatchesFile: matchesFile,
+			TempPrefix:  prefix,
+			Env:         env,
+			Stdout:      &stdout,
+			Volumes:     volumes,
+			Logger:      logger,
+		})
+		cancel()
+		result.FinishedAt = time.Now()
+		result.ExitCode = exitCode(err)
+		// Only the step's own deadline makes it time out. If the context of
+		// the repository is done, its error is reported instead.
+		if err != nil && timeout > 0 && stepCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			result.TimedOut = true
+			err = &errStepTimeout{step: i, timeout: timeout}
+			logger.StepTimedOut(repoName, i, err)
+		}
+		results = append(results, result)
+		if err != nil {
+			return nil, results, err
+		}
 
-			t0 := time.Now()
-			err = cmd.Run()
-			elapsed := time.Since(t0).Round(time.Millisecond)
-			if err != nil {
-				logger.DockerStepErrored(repoName, i, err, elapsed)
-				return nil, errors.Wrapf(err, "Running Docker container for image %q failed", step.Image)
+		if step.Output != "" {
+			cond.outputs[step.Output] = strings.TrimRight(stdout.String(), "\n")
+		}
+		if detectChanges {
+			prev := tree
+			if tree, err = writeTree(); err != nil {
+				return nil, results, err
 			}
-			logger.DockerStepDone(repoName, i, elapsed)
-
-		default:
-			return nil, fmt.Errorf("unrecognized run type %q", step.Type)
+			cond.previousStepChangedFiles = tree != prev
 		}
 	}
 
 	if _, err := runGitCmd("add", "--all"); err != nil {
-		return nil, errors.Wrap(err, "git add failed")
+		return nil, results, errors.Wrap(err, "git add failed")
 	}
 
-	// As of Sourcegraph 3.14 we only support unified diff format.
-	// That means we need to strip away the `a/` and `/b` prefixes with `--no-prefix`.
-	// See: https://github.com/sourcegraph/sourcegraph/blob/82d5e7e1562fef6be5c0b17f18631040fd330835/enterprise/internal/campaigns/service.go#L324-L329
-	//
-	// Also, we need to add --binary so binary file changes are inlined in the patch.
-	//
-	diffOut, err := runGitCmd("diff", "--cached", "--no-prefix", "--binary")
+	patch, err := diffStaged(ctx, volumeDir, policy)
+	return patch, results, err
+}
+
+// writeMatchesFile writes the file matches of the repository to a temporary
+// file outside of the work tree, so that steps can operate on only the
+// matched files, and returns its path.
+func writeMatchesFile(prefix string, matches []FileMatch) (string, error) {
+	if matches == nil {
+		matches = []FileMatch{}
+	}
+	data, err := json.MarshalIndent(matches, "", "  ")
 	if err != nil {
-		return nil, errors.Wrap(err, "git diff failed")
+		return "", err
 	}
-
-	return diffOut, err
+	f, err := ioutil.TempFile(tempDirPrefix, prefix+"-matches")
+	if err != nil {
+		return "", errors.Wrap(err, "writing file matches failed")
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "writing file matches failed")
+	}
+	return f.Name(), nil
 }
 
 // We use an explicit prefix for our temp directories, because otherwise Go
@@ -198,12 +223,16 @@ func fetchRepositoryArchive(ctx context.Context, endpoint, accessToken string, a
 	}
 	resp, err := ctxhttp.Do(ctx, nil, req)
 	if err != nil {
-		return nil, err
+		return nil, retryable("fetching the archive", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unable to fetch archive (HTTP %d from %s)", resp.StatusCode, zipURL)
+		err := fmt.Errorf("unable to fetch archive (HTTP %d from %s)", resp.StatusCode, zipURL)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, retryable("fetching the archive", err)
+		}
+		return nil, err
 	}
 
 	f, err := ioutil.TempFile(tempDirPrefix, strings.Replace(repoName, "/", "-", -1)+".zip")
@@ -213,11 +242,34 @@ func fetchRepositoryArchive(ctx context.Context, endpoint, accessToken string, a
 	defer f.Close()
 
 	if _, err := io.Copy(f, resp.Body); err != nil {
-		return nil, err
+		return nil, retryable("fetching the archive", err)
 	}
 	return f, nil
 }
 
+// flushWriters flushes the writers that buffer output, such as the ones
+// redacting secrets.
+func flushWriters(ws ...io.Writer) {
+	for _, w := range ws {
+		if f, ok := w.(interface{ Flush() error }); ok {
+			_ = f.Flush()
+		}
+	}
+}
+
+// exitCode returns the exit code of the process that ran a step, 0 if it
+// succeeded and -1 if it failed without exiting.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitCode()
+	}
+	return -1
+}
+
 func repositoryZipArchiveURL(endpoint, repoName, rev, token string) (*url.URL, error) {
 	u, err := url.Parse(endpoint)
 	if err != nil {
@@ -0,0 +1,300 @@
+package campaigns
+
+import (
+	"archive/zip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newZipArchiveServer returns a server that serves a ZIP archive containing
+// the given files for every repository.
+func newZipArchiveServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/-/raw") {
+			http.NotFound(w, r)
+			return
+		}
+		zw := zip.NewWriter(w)
+		for name, content := range files {
+			f, err := zw.Create(name)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := f.Write([]byte(content)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Error(err)
+		}
+	}))
+}
+
+// setGitIdentity makes sure `git commit` works in runAction, even if git is
+// not configured on the machine running the tests. The returned function
+// restores the environment.
+func setGitIdentity() func() {
+	prev := map[string]*string{}
+	for _, k := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL"} {
+		if v, ok := os.LookupEnv(k); ok {
+			prev[k] = &v
+		} else {
+			prev[k] = nil
+		}
+		os.Setenv(k, "src@example.com")
+	}
+
+	return func() {
+		for k, v := range prev {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+func TestRunActionConditionsAndOutputs(t *testing.T) {
+	defer setGitIdentity()()
+	ts := newZipArchiveServer(t, map[string]string{"README.md": "# README\n"})
+	defer ts.Close()
+
+	changed, unchanged := true, false
+	steps := []*ActionStep{
+		{Type: "command", Args: []string{"sh", "-c", "echo hello"}, Output: "GREETING"},
+		{Type: "command", Args: []string{"sh", "-c", "echo $GREETING > greeting.txt"}, If: &StepCondition{OutputNotEmpty: "GREETING"}},
+		{Type: "command", Args: []string{"sh", "-c", "echo changed > changed.txt"}, If: &StepCondition{PreviousStepChangedFiles: &changed}},
+		{Type: "command", Args: []string{"sh", "-c", "echo unchanged > unchanged.txt"}, If: &StepCondition{PreviousStepChangedFiles: &unchanged}},
+		{Type: "command", Args: []string{"sh", "-c", "echo missing > missing.txt"}, If: &StepCondition{FileExists: "missing.md"}},
+		{Type: "command", Args: []string{"sh", "-c", "echo readme > readme.txt"}, If: &StepCondition{FileExists: "README.md", RepoMatches: "github.com/sourcegraph/*"}},
+	}
+
+	logger := NewActionLogger(false, false)
+	if _, err := logger.AddRepo(ActionRepo{Name: "github.com/sourcegraph/src-cli"}); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.RepoFinished("github.com/sourcegraph/src-cli", false, nil)
+
+	patch, results, err := runAction(context.Background(), NewZipWorkspaceCreator(ts.URL, "", nil), CacheDirVolumes{}, "action-test", ActionRepo{Name: "github.com/sourcegraph/src-cli", Rev: "deadbeef"}, steps, nil, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"+++ greeting.txt", "+hello", "+++ changed.txt", "+++ readme.txt"} {
+		if !strings.Contains(string(patch), want) {
+			t.Errorf("patch does not contain %q:\n%s", want, patch)
+		}
+	}
+	for _, unwanted := range []string{"unchanged.txt", "missing.txt"} {
+		if strings.Contains(string(patch), unwanted) {
+			t.Errorf("patch contains %q:\n%s", unwanted, patch)
+		}
+	}
+
+	var skipped []bool
+	for _, r := range results {
+		skipped = append(skipped, r.Skipped)
+	}
+	want := []bool{false, false, false, true, true, false}
+	if len(skipped) != len(want) {
+		t.Fatalf("wrong number of step results: have %d; want %d", len(skipped), len(want))
+	}
+	for i := range want {
+		if skipped[i] != want[i] {
+			t.Errorf("wrong skipped state for step %d: have %v; want %v", i, skipped[i], want[i])
+		}
+	}
+}
+
+func TestRunActionEnv(t *testing.T) {
+	defer setGitIdentity()()
+	ts := newZipArchiveServer(t, map[string]string{"README.md": "# README\n"})
+	defer ts.Close()
+
+	os.Setenv("SRC_TEST_HOST_VAR", "from-host")
+	defer os.Unsetenv("SRC_TEST_HOST_VAR")
+
+	action := Action{
+		Env: map[string]EnvValue{
+			"GREETING": {Value: "hello"},
+			"TOKEN":    {FromSecret: "token"},
+		},
+		Steps: []*ActionStep{{
+			Type: "command",
+			Args: []string{"sh", "-c", "echo $GREETING $TOKEN $HOST_VAR > env.txt"},
+			Env: map[string]EnvValue{
+				"GREETING": {Value: "hi"},
+				"HOST_VAR": {FromEnv: "SRC_TEST_HOST_VAR"},
+			},
+		}},
+	}
+	mergeActionEnv(action)
+
+	secrets := map[string]string{"token": "s3cr3t"}
+	if err := ValidateActionEnv(action, secrets); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateActionEnv(action, nil); err == nil {
+		t.Error("unexpected nil error for undefined secret")
+	}
+
+	logger := NewActionLogger(false, false)
+	logger.RedactSecrets(SecretValues(action, secrets))
+	if _, err := logger.AddRepo(ActionRepo{Name: "github.com/sourcegraph/src-cli"}); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.RepoFinished("github.com/sourcegraph/src-cli", false, nil)
+
+	patch, _, err := runAction(context.Background(), NewZipWorkspaceCreator(ts.URL, "", nil), CacheDirVolumes{}, "action-test", ActionRepo{Name: "github.com/sourcegraph/src-cli", Rev: "deadbeef"}, action.Steps, nil, secrets, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "+hi s3cr3t from-host"; !strings.Contains(string(patch), want) {
+		t.Errorf("patch does not contain %q:\n%s", want, patch)
+	}
+}
+
+func TestRunActionFileMatches(t *testing.T) {
+	defer setGitIdentity()()
+	// The repository's own .src-matches.json must not be touched.
+	ts := newZipArchiveServer(t, map[string]string{"a.go": "package a\n", "b.go": "package b\n", ".src-matches.json": "[]\n"})
+	defer ts.Close()
+
+	repo := ActionRepo{
+		Name: "github.com/sourcegraph/src-cli",
+		Rev:  "deadbeef",
+		FileMatches: []FileMatch{
+			{Path: "a.go", LineMatches: []LineMatch{{LineNumber: 1, Preview: "package a"}}},
+		},
+	}
+	steps, err := expandSteps([]*ActionStep{
+		{Type: "command", Args: []string{"sh", "-c", "cp \"$SRC_MATCHES_FILE\" matches.json"}},
+		{Type: "command", Args: []string{"sh", "-c", "echo '${{ range .Repository.Matches }}${{ .Path }}:${{ (index .LineMatches 0).LineNumber }}${{ end }}' > lines.txt"}},
+	}, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := NewActionLogger(false, false)
+	if _, err := logger.AddRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.RepoFinished(repo.Name, false, nil)
+
+	patch, _, err := runAction(context.Background(), NewZipWorkspaceCreator(ts.URL, "", nil), CacheDirVolumes{}, "action-test", repo, steps, nil, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"+++ matches.json", `+    "path": "a.go",`, `+        "lineNumber": 1,`, "+++ lines.txt", "+a.go:1"} {
+		if !strings.Contains(string(patch), want) {
+			t.Errorf("patch does not contain %q:\n%s", want, patch)
+		}
+	}
+	if strings.Contains(string(patch), ".src-matches.json") {
+		t.Errorf("patch changes .src-matches.json:\n%s", patch)
+	}
+}
+
+func TestCacheKeyFileMatches(t *testing.T) {
+	repo := ActionRepo{ID: "1", Name: "github.com/a/a", Rev: "deadbeef", FileMatches: []FileMatch{{Path: "a.go"}}}
+	changed := repo
+	changed.FileMatches = []FileMatch{{Path: "a.go", LineMatches: []LineMatch{{LineNumber: 2, Preview: "x"}}}}
+
+	for name, tc := range map[string]struct {
+		steps []*ActionStep
+		same  bool
+	}{
+		"not used":     {steps: []*ActionStep{{Type: "command", Args: []string{"gofmt", "-w", "."}}}, same: true},
+		"matches file": {steps: []*ActionStep{{Type: "script", Script: "jq -r '.[].path' \"$SRC_MATCHES_FILE\""}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			x := NewExecutor(Action{Steps: tc.steps}, 1, nil, ExecutorOpts{})
+			a, err := x.cacheKey(repo, tc.steps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := x.cacheKey(changed, tc.steps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ha, _ := executionCacheKeyHash(a)
+			hb, _ := executionCacheKeyHash(b)
+			if same := ha == hb; same != tc.same {
+				t.Errorf("wrong cache key equality: have %v; want %v", same, tc.same)
+			}
+		})
+	}
+}
+
+func TestRunActionStepTimeout(t *testing.T) {
+	defer setGitIdentity()()
+	ts := newZipArchiveServer(t, map[string]string{"README.md": "# README\n"})
+	defer ts.Close()
+
+	repo := ActionRepo{Name: "github.com/sourcegraph/src-cli", Rev: "deadbeef"}
+	steps := []*ActionStep{
+		{Type: "command", Args: []string{"true"}, Timeout: "10s"},
+		{Type: "command", Args: []string{"sleep", "10"}, Timeout: "100ms"},
+	}
+
+	logger := NewActionLogger(false, false)
+	if _, err := logger.AddRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.RepoFinished(repo.Name, false, nil)
+
+	_, results, err := runAction(context.Background(), NewZipWorkspaceCreator(ts.URL, "", nil), CacheDirVolumes{}, "action-test", repo, steps, nil, nil, logger)
+	if err == nil {
+		t.Fatal("unexpected nil error")
+	}
+	if have, want := timeoutReason(err), "step 1 exceeded its timeout of 100ms"; have != want {
+		t.Errorf("wrong timeout reason: have %q; want %q", have, want)
+	}
+	if len(results) != 2 || results[0].TimedOut || !results[1].TimedOut {
+		t.Errorf("wrong step results: %+v", results)
+	}
+	if d := results[1].FinishedAt.Sub(results[1].StartedAt); d > 5*time.Second {
+		t.Errorf("step was not stopped after its timeout, took %s", d)
+	}
+}
+
+func TestRunActionRepoTimeoutDuringStep(t *testing.T) {
+	defer setGitIdentity()()
+	ts := newZipArchiveServer(t, map[string]string{"README.md": "# README\n"})
+	defer ts.Close()
+
+	repo := ActionRepo{Name: "github.com/sourcegraph/src-cli", Rev: "deadbeef"}
+	steps := []*ActionStep{
+		{Type: "command", Args: []string{"sleep", "10"}, Timeout: "10s"},
+	}
+
+	logger := NewActionLogger(false, false)
+	if _, err := logger.AddRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.RepoFinished(repo.Name, false, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, results, err := runAction(ctx, NewZipWorkspaceCreator(ts.URL, "", nil), CacheDirVolumes{}, "action-test", repo, steps, nil, nil, logger)
+	if err == nil {
+		t.Fatal("unexpected nil error")
+	}
+	if _, ok := err.(*errStepTimeout); ok {
+		t.Errorf("repository timeout reported as step timeout: %s", err)
+	}
+	if len(results) != 1 || results[0].TimedOut {
+		t.Errorf("wrong step results: %+v", results)
+	}
+}
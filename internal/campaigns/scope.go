@@ -0,0 +1,171 @@
+package campaigns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ActionScope describes the repositories an action is executed in, as an
+// alternative to the single scopeQuery.
+type ActionScope struct {
+	// Queries are Sourcegraph search queries whose results are combined as
+	// given by Combine.
+	Queries []string `json:"queries,omitempty"`
+
+	// Combine is either ScopeUnion, the default, or ScopeIntersection.
+	Combine string `json:"combine,omitempty"`
+
+	// Repositories are repositories that are in scope in addition to the
+	// ones matched by the queries. Their branch or revision overrides the
+	// default branch, also for repositories matched by the queries.
+	Repositories []ScopeRepository `json:"repositories,omitempty"`
+
+	// RepositoriesFile is a file that lists more Repositories, one per line
+	// in the same format as the string form of Repositories. Relative paths
+	// are relative to the action file.
+	RepositoriesFile string `json:"repositoriesFile,omitempty"`
+}
+
+const (
+	ScopeUnion        = "union"
+	ScopeIntersection = "intersection"
+)
+
+// ScopeRepository is a repository that is explicitly in the scope of an
+// action.
+type ScopeRepository struct {
+	Name string `json:"name"`
+
+	// Branch is the branch the action is executed on and the changeset is
+	// based on, instead of the default branch.
+	Branch string `json:"branch,omitempty"`
+
+	// Rev is the revision the action is executed at, instead of the head of
+	// the branch.
+	Rev string `json:"rev,omitempty"`
+}
+
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// ParseScopeRepository parses a repository given as "name" or "name@ref",
+// where ref is a full commit SHA, which is used as Rev, or a branch. Anything
+// else, including abbreviated SHAs, is taken as a branch.
+func ParseScopeRepository(s string) (ScopeRepository, error) {
+	name, ref := strings.TrimSpace(s), ""
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref = name[:i], name[i+1:]
+		if ref == "" {
+			return ScopeRepository{}, errors.Errorf("invalid repository %q: empty revision after @", s)
+		}
+	}
+	if name == "" || strings.ContainsAny(name, " \t") {
+		return ScopeRepository{}, errors.Errorf("invalid repository %q: must be name or name@branch-or-commit", s)
+	}
+
+	repo := ScopeRepository{Name: name}
+	if commitSHAPattern.MatchString(ref) {
+		repo.Rev = ref
+	} else {
+		repo.Branch = ref
+	}
+	return repo, nil
+}
+
+// UnmarshalJSON unmarshals a repository given as a string, as parsed by
+// ParseScopeRepository, or as an object.
+func (r *ScopeRepository) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*r, err = ParseScopeRepository(s)
+		return err
+	}
+
+	type plain ScopeRepository
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+// BaseRef returns the git ref of the branch of the repository, or "" if it
+// uses the default branch.
+func (r ScopeRepository) BaseRef() string {
+	if r.Branch == "" || strings.HasPrefix(r.Branch, "refs/") {
+		return r.Branch
+	}
+	return "refs/heads/" + r.Branch
+}
+
+// ScopeQueries returns the search queries of the action: the scopeQuery or
+// the queries of the scope.
+func (a *Action) ScopeQueries() []string {
+	if a.ScopeQuery != "" {
+		return []string{a.ScopeQuery}
+	}
+	if a.Scope != nil {
+		return a.Scope.Queries
+	}
+	return nil
+}
+
+// ScopeRepositories returns the explicit repositories of the action: the
+// Repositories of its scope followed by the ones in the RepositoriesFile,
+// whose relative path is resolved against dir. If a repository is listed more
+// than once, the last entry is used.
+func (a *Action) ScopeRepositories(dir string) ([]ScopeRepository, error) {
+	if a.Scope == nil {
+		return nil, nil
+	}
+
+	repos := append([]ScopeRepository{}, a.Scope.Repositories...)
+	if a.Scope.RepositoriesFile != "" {
+		path := a.Scope.RepositoriesFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading repositories file")
+		}
+		fromFile, err := parseScopeRepositoriesFile(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "repositories file %s", path)
+		}
+		repos = append(repos, fromFile...)
+	}
+
+	index := make(map[string]int, len(repos))
+	deduped := repos[:0]
+	for _, r := range repos {
+		if i, ok := index[r.Name]; ok {
+			deduped[i] = r
+			continue
+		}
+		index[r.Name] = len(deduped)
+		deduped = append(deduped, r)
+	}
+	return deduped, nil
+}
+
+// parseScopeRepositoriesFile parses a file with one repository per line.
+// Empty lines and lines starting with # are ignored.
+func parseScopeRepositoriesFile(data []byte) ([]ScopeRepository, error) {
+	var repos []ScopeRepository
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		repo, err := ParseScopeRepository(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+		repos = append(repos, repo)
+	}
+	return repos, scanner.Err()
+}
@@ -0,0 +1,130 @@
+package campaigns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseScopeRepository(t *testing.T) {
+	const sha = "2a6c7e7a0b6c9e0ef1a27c1d0b5d6b2e8d0e1f3a"
+	tests := map[string]struct {
+		want    ScopeRepository
+		wantErr string
+	}{
+		"github.com/a/b":                {want: ScopeRepository{Name: "github.com/a/b"}},
+		"github.com/a/b@release-1.2":    {want: ScopeRepository{Name: "github.com/a/b", Branch: "release-1.2"}},
+		"github.com/a/b@" + sha:         {want: ScopeRepository{Name: "github.com/a/b", Rev: sha}},
+		"  github.com/a/b@feature/x  ":  {want: ScopeRepository{Name: "github.com/a/b", Branch: "feature/x"}},
+		"github.com/a/b@":               {wantErr: "empty revision"},
+		"@main":                         {wantErr: "must be name or name@branch-or-commit"},
+		"github.com/a/b github.com/a/c": {wantErr: "must be name or name@branch-or-commit"},
+	}
+	for s, tc := range tests {
+		t.Run(s, func(t *testing.T) {
+			have, err := ParseScopeRepository(s)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("wrong error: have %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have != tc.want {
+				t.Errorf("wrong repository: have %+v; want %+v", have, tc.want)
+			}
+		})
+	}
+}
+
+func TestActionScope(t *testing.T) {
+	dir, err := ioutil.TempDir("", "action-scope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repos := `# Repositories on release branches.
+github.com/a/b@release-1
+
+github.com/a/c
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "repos.txt"), []byte(repos), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	def := []byte(`{
+		"scope": {
+			"queries": ["repohasfile:go.mod", "lang:go"],
+			"combine": "intersection",
+			"repositories": ["github.com/a/b", {"name": "github.com/a/d", "branch": "main", "rev": "abc"}],
+			"repositoriesFile": "repos.txt"
+		},
+		"steps": [{"type": "command", "args": ["ls"]}]
+	}`)
+	if err := ValidateActionDefinition(def); err != nil {
+		t.Fatal(err)
+	}
+	var action Action
+	if err := json.Unmarshal(def, &action); err != nil {
+		t.Fatal(err)
+	}
+
+	if have, want := action.ScopeQueries(), []string{"repohasfile:go.mod", "lang:go"}; !cmp.Equal(have, want) {
+		t.Errorf("wrong queries: have %q; want %q", have, want)
+	}
+	have, err := action.ScopeRepositories(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ScopeRepository{
+		{Name: "github.com/a/b", Branch: "release-1"},
+		{Name: "github.com/a/d", Branch: "main", Rev: "abc"},
+		{Name: "github.com/a/c"},
+	}
+	if diff := cmp.Diff(want, have); diff != "" {
+		t.Errorf("wrong repositories (-want +got):\n%s", diff)
+	}
+	if have, want := have[0].BaseRef(), "refs/heads/release-1"; have != want {
+		t.Errorf("wrong base ref: have %q; want %q", have, want)
+	}
+
+	action.Scope.RepositoriesFile = "missing.txt"
+	if _, err := action.ScopeRepositories(dir); err == nil {
+		t.Error("no error for missing repositories file")
+	}
+}
+
+func TestValidateActionScope(t *testing.T) {
+	const steps = `"steps": [{"type": "command", "args": ["ls"]}]`
+	tests := map[string]struct {
+		def   string
+		valid bool
+	}{
+		"scopeQuery":              {def: `{"scopeQuery": "lang:go", ` + steps + `}`, valid: true},
+		"scope with file":         {def: `{"scope": {"repositoriesFile": "repos.txt"}, ` + steps + `}`, valid: true},
+		"scopeQuery and scope":    {def: `{"scopeQuery": "lang:go", "scope": {"queries": ["lang:go"]}, ` + steps + `}`},
+		"neither":                 {def: `{` + steps + `}`},
+		"empty scope":             {def: `{"scope": {}, ` + steps + `}`},
+		"invalid combine":         {def: `{"scope": {"queries": ["a", "b"], "combine": "xor"}, ` + steps + `}`},
+		"invalid repository":      {def: `{"scope": {"repositories": ["a@b@c"]}, ` + steps + `}`},
+		"repository without name": {def: `{"scope": {"repositories": [{"branch": "main"}]}, ` + steps + `}`},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateActionDefinition([]byte(tc.def))
+			if tc.valid && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			} else if !tc.valid && err == nil {
+				t.Error("invalid definition passed validation")
+			}
+		})
+	}
+}
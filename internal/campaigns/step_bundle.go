@@ -0,0 +1,408 @@
+package campaigns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// StepBundle is a reusable list of steps, defined in a YAML or JSON file,
+// that steps of action definitions can use with "uses", e.g. to share the
+// steps that install dependencies, run a codemod and format the code between
+// actions.
+type StepBundle struct {
+	// Params are the parameters of the bundle, which the steps that use it
+	// set in "with". The steps of the bundle refer to them as
+	// `${{ .Params.name }}`.
+	Params map[string]StepBundleParam `json:"params,omitempty"`
+
+	Steps []interface{} `json:"steps"`
+}
+
+// StepBundleParam is a parameter of a StepBundle.
+type StepBundleParam struct {
+	Description string `json:"description,omitempty"`
+
+	// Default is the value of the parameter if the step that uses the bundle
+	// doesn't set it. Parameters without a default are required.
+	Default *string `json:"default,omitempty"`
+}
+
+// usesStep is a step of an action definition or a bundle that is replaced by
+// the steps of a bundle.
+type usesStep struct {
+	// Uses is the path or the http(s) URL of the bundle. Relative paths are
+	// relative to the file that contains the step.
+	Uses string
+
+	// Checksum is the SHA-256 checksum of the bundle file, e.g.
+	// "sha256:2c26b4...". It's required for bundles fetched from URLs.
+	Checksum string
+
+	With map[string]string
+}
+
+// stepBundleError is an error in resolving the bundle used by a step.
+type stepBundleError struct {
+	step int
+	err  error
+}
+
+func (e *stepBundleError) Error() string {
+	return fmt.Sprintf("steps.%d: %s", e.step, e.err)
+}
+
+// stepOrigin is the step of the action definition that a resolved step comes
+// from.
+type stepOrigin struct {
+	step int
+
+	// bundle is the location of the bundle the step comes from, if any, and
+	// bundleStep its index in the resolved steps of the bundle.
+	bundle     string
+	bundleStep int
+}
+
+var bundleParamPattern = regexp.MustCompile(`\$\{\{\s*\.Params\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// ResolveStepBundles replaces the steps of the action definition, which must
+// be plain JSON, that use a step bundle with the steps of the bundle, in
+// which the parameters are expanded. Bundles can use other bundles. Relative
+// paths of bundles are resolved against dir.
+//
+// The resolved definition is what is validated, executed and part of the
+// cache keys, so that changing a bundle invalidates the cached results of the
+// actions that use it.
+func ResolveStepBundles(ctx context.Context, def []byte, dir string) ([]byte, error) {
+	resolved, _, err := resolveStepBundles(ctx, def, dir)
+	return resolved, err
+}
+
+// resolveStepBundles is ResolveStepBundles, which also returns the origins of
+// the resolved steps. If no step uses a bundle, def and nil origins are
+// returned.
+func resolveStepBundles(ctx context.Context, def []byte, dir string) ([]byte, []stepOrigin, error) {
+	var action map[string]interface{}
+	if err := json.Unmarshal(def, &action); err != nil {
+		// Reported by the validation of the definition.
+		return def, nil, nil
+	}
+	steps, ok := action["steps"].([]interface{})
+	if !ok || !hasUsesStep(steps) {
+		return def, nil, nil
+	}
+
+	r := &bundleResolver{ctx: ctx}
+	resolved, origins, err := r.resolveSteps(steps, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	action["steps"] = resolved
+
+	def, err = json.Marshal(action)
+	if err != nil {
+		return nil, nil, err
+	}
+	return def, origins, nil
+}
+
+func hasUsesStep(steps []interface{}) bool {
+	for _, s := range steps {
+		if m, ok := s.(map[string]interface{}); ok && m["uses"] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+type bundleResolver struct {
+	ctx context.Context
+
+	// using holds the locations of the bundles being resolved, to detect
+	// bundles that use themselves.
+	using []string
+}
+
+// resolveSteps resolves the steps of the file at base, which is the
+// directory of a local file or the URL of a remote one.
+func (r *bundleResolver) resolveSteps(steps []interface{}, base string) (resolved []interface{}, origins []stepOrigin, err error) {
+	for i, s := range steps {
+		m, ok := s.(map[string]interface{})
+		if !ok || m["uses"] == nil {
+			resolved = append(resolved, s)
+			origins = append(origins, stepOrigin{step: i})
+			continue
+		}
+
+		location, bundleSteps, err := r.resolveUses(m, base)
+		if err != nil {
+			return nil, nil, &stepBundleError{step: i, err: err}
+		}
+		for j := range bundleSteps {
+			origins = append(origins, stepOrigin{step: i, bundle: location, bundleStep: j})
+		}
+		resolved = append(resolved, bundleSteps...)
+	}
+	return resolved, origins, nil
+}
+
+// resolveUses returns the location of the bundle used by the step and its
+// resolved steps.
+func (r *bundleResolver) resolveUses(m map[string]interface{}, base string) (string, []interface{}, error) {
+	step, err := parseUsesStep(m)
+	if err != nil {
+		return "", nil, err
+	}
+
+	location := bundleLocation(base, step.Uses)
+	for _, l := range r.using {
+		if l == location {
+			return "", nil, errors.Errorf("bundle %s uses itself", location)
+		}
+	}
+
+	data, err := r.readBundle(location, step.Checksum)
+	if err != nil {
+		return "", nil, err
+	}
+	jsonData, err := yaml.YAMLToJSONStrict(data)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "unable to parse bundle %s", location)
+	}
+	var bundle StepBundle
+	if err := json.Unmarshal(jsonData, &bundle); err != nil {
+		return "", nil, errors.Wrapf(err, "invalid bundle %s", location)
+	}
+	if len(bundle.Steps) == 0 {
+		return "", nil, errors.Errorf("bundle %s has no steps", location)
+	}
+
+	params, err := bundleParams(bundle, step.With)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "bundle %s", location)
+	}
+	steps, err := expandBundleParams(bundle.Steps, params)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "bundle %s", location)
+	}
+
+	next := location
+	if !isBundleURL(location) {
+		next = filepath.Dir(location)
+	}
+	r.using = append(r.using, location)
+	resolved, _, err := r.resolveSteps(steps.([]interface{}), next)
+	r.using = r.using[:len(r.using)-1]
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "bundle %s", location)
+	}
+	return location, resolved, nil
+}
+
+func parseUsesStep(m map[string]interface{}) (usesStep, error) {
+	var step usesStep
+	for key, value := range m {
+		switch key {
+		case "uses", "checksum":
+			s, ok := value.(string)
+			if !ok {
+				return step, errors.Errorf("%s must be a string", key)
+			}
+			if key == "uses" {
+				step.Uses = s
+			} else {
+				step.Checksum = s
+			}
+
+		case "with":
+			with, ok := value.(map[string]interface{})
+			if !ok {
+				return step, errors.New("with must be an object")
+			}
+			step.With = make(map[string]string, len(with))
+			for name, v := range with {
+				s, err := bundleParamValue(v)
+				if err != nil {
+					return step, errors.Wrapf(err, "with.%s", name)
+				}
+				step.With[name] = s
+			}
+
+		default:
+			return step, errors.Errorf("steps that use a bundle can only set uses, with and checksum, not %s", key)
+		}
+	}
+	if step.Uses == "" {
+		return step, errors.New("uses must not be empty")
+	}
+	return step, nil
+}
+
+// bundleParamValue returns the scalar value given for a parameter as a
+// string.
+func bundleParamValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", errors.New("must be a string, number or boolean")
+	}
+}
+
+// bundleLocation returns the path or URL of the bundle used as uses by a file
+// at base.
+func bundleLocation(base, uses string) string {
+	if isBundleURL(uses) {
+		return uses
+	}
+	if isBundleURL(base) {
+		u, err := url.Parse(base)
+		if err != nil {
+			return uses
+		}
+		ref, err := url.Parse(filepath.ToSlash(uses))
+		if err != nil {
+			return uses
+		}
+		return u.ResolveReference(ref).String()
+	}
+	if filepath.IsAbs(uses) {
+		return uses
+	}
+	return filepath.Join(base, uses)
+}
+
+func isBundleURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// readBundle reads the bundle file at location and verifies its checksum, if
+// given.
+func (r *bundleResolver) readBundle(location, checksum string) ([]byte, error) {
+	var data []byte
+	if isBundleURL(location) {
+		if checksum == "" {
+			return nil, errors.Errorf("bundle %s must be pinned with a checksum, e.g. \"sha256:...\"", location)
+		}
+		req, err := http.NewRequest("GET", location, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(r.ctx))
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetching bundle %s", location)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("fetching bundle %s: unexpected status %s", location, resp.Status)
+		}
+		if data, err = ioutil.ReadAll(resp.Body); err != nil {
+			return nil, errors.Wrapf(err, "fetching bundle %s", location)
+		}
+	} else {
+		var err error
+		if data, err = ioutil.ReadFile(location); err != nil {
+			return nil, errors.Wrap(err, "reading bundle")
+		}
+	}
+
+	if checksum == "" {
+		return data, nil
+	}
+	if !strings.HasPrefix(checksum, "sha256:") {
+		return nil, errors.Errorf("invalid checksum %q: must start with \"sha256:\"", checksum)
+	}
+	sum := sha256.Sum256(data)
+	if have := hex.EncodeToString(sum[:]); !strings.EqualFold(have, strings.TrimPrefix(checksum, "sha256:")) {
+		return nil, errors.Errorf("checksum of bundle %s doesn't match: have sha256:%s, want %s", location, have, checksum)
+	}
+	return data, nil
+}
+
+// bundleParams returns the values of the parameters of the bundle given the
+// values set by the step that uses it.
+func bundleParams(bundle StepBundle, with map[string]string) (map[string]string, error) {
+	params := make(map[string]string, len(bundle.Params))
+	for name, value := range with {
+		if _, ok := bundle.Params[name]; !ok {
+			return nil, errors.Errorf("unknown parameter %q", name)
+		}
+		params[name] = value
+	}
+
+	var missing []string
+	for name, param := range bundle.Params {
+		if _, ok := params[name]; ok {
+			continue
+		}
+		if param.Default == nil {
+			missing = append(missing, name)
+			continue
+		}
+		params[name] = *param.Default
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, errors.Errorf("missing required parameters: %s", strings.Join(missing, ", "))
+	}
+	return params, nil
+}
+
+// expandBundleParams returns a copy of v, a value decoded from JSON, in which
+// all `${{ .Params.name }}` in strings are replaced by the parameter values.
+// Other templates are left for the execution.
+func expandBundleParams(v interface{}, params map[string]string) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		var err error
+		s := bundleParamPattern.ReplaceAllStringFunc(v, func(m string) string {
+			name := bundleParamPattern.FindStringSubmatch(m)[1]
+			value, ok := params[name]
+			if !ok && err == nil {
+				err = errors.Errorf("undeclared parameter %q", name)
+			}
+			return value
+		})
+		return s, err
+
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, e := range v {
+			var err error
+			if expanded[i], err = expandBundleParams(e, params); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+
+	case map[string]interface{}:
+		expanded := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			var err error
+			if expanded[k], err = expandBundleParams(e, params); err != nil {
+				return nil, err
+			}
+		}
+		return expanded, nil
+
+	default:
+		return v, nil
+	}
+}
@@ -0,0 +1,183 @@
+package campaigns
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResolveStepBundles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "step-bundles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const prettierBundle = `
+params:
+  pattern:
+    default: "**/*.js"
+steps:
+  - type: docker
+    image: node:12
+    args: ["npx", "prettier", "--write", "${{ .Params.pattern }}"]
+`
+	remoteBundle := `
+params:
+  version:
+    description: The Node.js version.
+steps:
+  - type: docker
+    image: node:${{ .Params.version }}
+    args: ["yarn", "install"]
+    cacheDirs: ["/usr/local/share/.cache/yarn"]
+  - uses: prettier.yml
+    checksum: sha256:` + sha256Hex(prettierBundle) + `
+    with:
+      pattern: ${{ .Params.version }}/**/*.ts
+`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bundles/v1/node.yml":
+			w.Write([]byte(remoteBundle))
+		case "/bundles/v1/prettier.yml":
+			w.Write([]byte(prettierBundle))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	writeBundle := func(name, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeBundle("bundles/prettier.yml", prettierBundle)
+	writeBundle("bundles/self.yml", `{"steps": [{"uses": "self.yml"}]}`)
+
+	tests := map[string]struct {
+		steps   string
+		want    string
+		wantErr string
+	}{
+		"no bundles": {
+			steps: `[{"type": "command", "args": ["ls"]}]`,
+			want:  `[{"type": "command", "args": ["ls"]}]`,
+		},
+		"local bundle": {
+			steps: `[{"type": "command", "args": ["ls"]}, {"uses": "bundles/prettier.yml"}]`,
+			want: `[
+				{"type": "command", "args": ["ls"]},
+				{"type": "docker", "image": "node:12", "args": ["npx", "prettier", "--write", "**/*.js"]}
+			]`,
+		},
+		"remote bundle using a relative bundle": {
+			steps: `[{"uses": "` + ts.URL + `/bundles/v1/node.yml", "checksum": "sha256:` + sha256Hex(remoteBundle) + `", "with": {"version": 14}}]`,
+			want: `[
+				{"type": "docker", "image": "node:14", "args": ["yarn", "install"], "cacheDirs": ["/usr/local/share/.cache/yarn"]},
+				{"type": "docker", "image": "node:12", "args": ["npx", "prettier", "--write", "14/**/*.ts"]}
+			]`,
+		},
+		"remote bundle without checksum": {
+			steps:   `[{"type": "command", "args": ["ls"]}, {"uses": "` + ts.URL + `/bundles/v1/node.yml", "with": {"version": "14"}}]`,
+			wantErr: "steps.1: bundle " + ts.URL + "/bundles/v1/node.yml must be pinned with a checksum",
+		},
+		"checksum mismatch": {
+			steps:   `[{"uses": "bundles/prettier.yml", "checksum": "sha256:` + sha256Hex("other") + `"}]`,
+			wantErr: "steps.0: checksum of bundle " + filepath.Join(dir, "bundles/prettier.yml") + " doesn't match",
+		},
+		"missing parameter": {
+			steps:   `[{"uses": "` + ts.URL + `/bundles/v1/node.yml", "checksum": "sha256:` + sha256Hex(remoteBundle) + `"}]`,
+			wantErr: "missing required parameters: version",
+		},
+		"unknown parameter": {
+			steps:   `[{"uses": "bundles/prettier.yml", "with": {"patern": "*.ts"}}]`,
+			wantErr: `unknown parameter "patern"`,
+		},
+		"other fields": {
+			steps:   `[{"uses": "bundles/prettier.yml", "type": "docker"}]`,
+			wantErr: "steps that use a bundle can only set uses, with and checksum, not type",
+		},
+		"cycle": {
+			steps:   `[{"uses": "bundles/self.yml"}]`,
+			wantErr: "uses itself",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			def := `{"scopeQuery": "x", "steps": ` + tc.steps + `}`
+			resolved, err := ResolveStepBundles(context.Background(), []byte(def), dir)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("wrong error: have %v; want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var have, want map[string]interface{}
+			if err := json.Unmarshal(resolved, &have); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(`{"scopeQuery": "x", "steps": `+tc.want+`}`), &want); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, have); diff != "" {
+				t.Errorf("wrong resolved definition (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResolveStepBundlesActionID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "step-bundles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	actionID := func(bundle string) string {
+		if err := ioutil.WriteFile(filepath.Join(dir, "bundle.yml"), []byte(bundle), 0600); err != nil {
+			t.Fatal(err)
+		}
+		def, err := ResolveStepBundles(context.Background(), []byte(`{"steps": [{"uses": "bundle.yml"}]}`), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var action Action
+		if err := json.Unmarshal(def, &action); err != nil {
+			t.Fatal(err)
+		}
+		id, err := ActionID(action)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	before := actionID(`{"steps": [{"type": "command", "args": ["ls"]}]}`)
+	if after := actionID(`{"steps": [{"type": "command", "args": ["ls", "-l"]}]}`); before == after {
+		t.Errorf("changing the bundle didn't change the action ID %s", before)
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
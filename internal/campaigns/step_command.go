@@ -0,0 +1,156 @@
+package campaigns
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// commandRunner runs "command" steps, which execute the command given in the
+// step's args on the machine on which src is executed.
+type commandRunner struct{}
+
+func (commandRunner) Validate(step *ActionStep) error {
+	if len(step.Args) == 0 {
+		return errors.New("args is required")
+	}
+	if step.Image != "" {
+		return errors.New("image is not supported")
+	}
+	if step.Script != "" {
+		return errors.New("script is not supported")
+	}
+	if step.ContainerOpts != (ContainerOpts{}) {
+		return errors.New("cpus, memory, network and user are only supported by container steps")
+	}
+	return nil
+}
+
+func (commandRunner) Prepare(ctx context.Context, step *ActionStep, retry RetryPolicy, logger *ActionLogger) error {
+	return nil
+}
+
+func (commandRunner) check(ctx context.Context, step *ActionStep) []stepProblem {
+	if len(step.Args) == 0 {
+		return nil
+	}
+	return checkStepBinary(step.Args[0], "args.0")
+}
+
+func (commandRunner) Run(ctx context.Context, run StepRun) error {
+	return runCommand(ctx, run, run.Step.Args)
+}
+
+// scriptRunner runs "script" steps, which write the step's script to a
+// temporary file and execute it on the machine on which src is executed. The
+// step's args are passed to the script as arguments.
+type scriptRunner struct{}
+
+func (scriptRunner) Validate(step *ActionStep) error {
+	if step.Script == "" {
+		return errors.New("script is required")
+	}
+	if step.Image != "" {
+		return errors.New("image is not supported")
+	}
+	if step.ContainerOpts != (ContainerOpts{}) {
+		return errors.New("cpus, memory, network and user are only supported by container steps")
+	}
+	return nil
+}
+
+func (scriptRunner) Prepare(ctx context.Context, step *ActionStep, retry RetryPolicy, logger *ActionLogger) error {
+	return nil
+}
+
+func (scriptRunner) check(ctx context.Context, step *ActionStep) []stepProblem {
+	return checkStepBinary(scriptInterpreter(step.Script), "script")
+}
+
+// scriptInterpreter returns the interpreter of the script given in its
+// shebang line, or sh if it has none. If the script is executed with env,
+// e.g. "#!/usr/bin/env bash", the interpreter is the program env executes.
+func scriptInterpreter(script string) string {
+	if !strings.HasPrefix(script, "#!") {
+		return "sh"
+	}
+	line := strings.SplitN(script[2:], "\n", 2)[0]
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "sh"
+	}
+	if filepath.Base(fields[0]) == "env" {
+		// Skip the options of env, e.g. -S, and the variables it sets.
+		for _, arg := range fields[1:] {
+			if !strings.HasPrefix(arg, "-") && !strings.Contains(arg, "=") {
+				return arg
+			}
+		}
+	}
+	return fields[0]
+}
+
+func (scriptRunner) Run(ctx context.Context, run StepRun) error {
+	// The script is written outside of the work dir, so that it doesn't end
+	// up in the patch.
+	f, err := ioutil.TempFile(tempDirPrefix, run.TempPrefix+"-script")
+	if err != nil {
+		return errors.Wrap(err, "Creating a script file failed")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(run.Step.Script); err != nil {
+		f.Close()
+		return errors.Wrap(err, "Writing the script file failed")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "Writing the script file failed")
+	}
+
+	// Scripts with a shebang line are executed with the given interpreter,
+	// all others with sh.
+	args := []string{"sh", f.Name()}
+	if strings.HasPrefix(run.Step.Script, "#!") {
+		if err := os.Chmod(f.Name(), 0700); err != nil {
+			return err
+		}
+		args = []string{f.Name()}
+	}
+	return runCommand(ctx, run, append(args, run.Step.Args...))
+}
+
+// runCommand runs args on the host with the work dir as working directory.
+func runCommand(ctx context.Context, run StepRun, args []string) error {
+	logger := run.Logger
+	logger.CommandStepStarted(run.RepoName, run.Index, args)
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = run.WorkDir
+	cmd.Env = append(os.Environ(), run.Env...)
+	if run.MatchesFile != "" {
+		cmd.Env = append(cmd.Env, matchesFileEnv+"="+run.MatchesFile)
+	}
+	cmd.Stdout = run.Stdout
+
+	if logStdout, logStderr, ok := logger.RepoStdoutStderr(run.RepoName); ok {
+		cmd.Stdout = io.MultiWriter(logStdout, run.Stdout)
+		cmd.Stderr = logStderr
+		defer flushWriters(logStdout, logStderr)
+	}
+
+	if err := cmd.Run(); err != nil {
+		// Timeouts are reported by runAction.
+		if ctx.Err() != context.DeadlineExceeded {
+			logger.CommandStepErrored(run.RepoName, run.Index, err)
+		}
+		return errors.Wrap(err, "run command")
+	}
+	logger.CommandStepDone(run.RepoName, run.Index)
+	return nil
+}
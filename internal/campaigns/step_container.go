@@ -0,0 +1,315 @@
+package campaigns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ContainerOpts are the resource limits, network and user of the containers of
+// "docker" and "podman" steps. Empty fields use the defaults of the container
+// runtime.
+type ContainerOpts struct {
+	// CPUs is the number of CPUs the container can use, e.g. 1.5.
+	CPUs float64 `json:"cpus,omitempty"`
+
+	// Memory is the maximum amount of memory the container can use, e.g.
+	// "512m" or "2g".
+	Memory string `json:"memory,omitempty"`
+
+	// Network is the network the container is connected to: "none",
+	// "bridge" or "host".
+	Network string `json:"network,omitempty"`
+
+	// User is the user, and optionally the group, that the command in the
+	// container runs as, e.g. "1000:1000" or "nobody".
+	User string `json:"user,omitempty"`
+}
+
+var (
+	containerMemoryPattern = regexp.MustCompile(`^[0-9]+[bkmgBKMG]?$`)
+	containerNetworks      = []string{"none", "bridge", "host"}
+)
+
+// Validate checks that the options are understood by the container runtime.
+func (o ContainerOpts) Validate() error {
+	if o.CPUs < 0 {
+		return errors.Errorf("invalid cpus %v: must not be negative", o.CPUs)
+	}
+	if o.Memory != "" && !containerMemoryPattern.MatchString(o.Memory) {
+		return errors.Errorf("invalid memory %q: must be a number with an optional unit of b, k, m or g", o.Memory)
+	}
+	if o.Network != "" {
+		valid := false
+		for _, n := range containerNetworks {
+			valid = valid || o.Network == n
+		}
+		if !valid {
+			return errors.Errorf("invalid network %q: must be one of %s", o.Network, strings.Join(containerNetworks, ", "))
+		}
+	}
+	return nil
+}
+
+// runArgs returns the arguments of `docker run` that apply the options.
+func (o ContainerOpts) runArgs() []string {
+	var args []string
+	if o.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(o.CPUs, 'f', -1, 64))
+	}
+	if o.Memory != "" {
+		args = append(args, "--memory", o.Memory)
+	}
+	if o.Network != "" {
+		args = append(args, "--network", o.Network)
+	}
+	if o.User != "" {
+		args = append(args, "--user", o.User)
+	}
+	return args
+}
+
+// ApplyContainerDefaults sets the options of all container steps of the
+// action that the steps don't set themselves to the given defaults, so that
+// they are part of the cache key.
+func ApplyContainerDefaults(action Action, defaults ContainerOpts) {
+	for _, step := range action.Steps {
+		runner, ok := stepRunners[step.Type]
+		if !ok {
+			continue
+		}
+		if _, ok := runner.(containerRunner); !ok {
+			continue
+		}
+		if step.CPUs == 0 {
+			step.CPUs = defaults.CPUs
+		}
+		if step.Memory == "" {
+			step.Memory = defaults.Memory
+		}
+		if step.Network == "" {
+			step.Network = defaults.Network
+		}
+		if step.User == "" {
+			step.User = defaults.User
+		}
+	}
+}
+
+// containerRunner runs "docker" and "podman" steps, which run a container of
+// the step's image with the repository mounted in at /work, using the Docker
+// compatible CLI binary.
+type containerRunner struct {
+	binary string
+}
+
+func (r containerRunner) Validate(step *ActionStep) error {
+	if step.Image == "" {
+		return errors.New("image is required")
+	}
+	if step.Script != "" {
+		return errors.New("script is not supported")
+	}
+	return step.ContainerOpts.Validate()
+}
+
+func (r containerRunner) Prepare(ctx context.Context, step *ActionStep, retry RetryPolicy, logger *ActionLogger) error {
+	// Images that depend on the repository are pulled when the action is
+	// executed in it.
+	if isTemplate(step.Image) {
+		return nil
+	}
+
+	// Set digests for images so we don't cache action runs in 2 different
+	// images with the same tag.
+	digest, err := pullImage(ctx, r, step.Image, retry, logger)
+	if err != nil {
+		return err
+	}
+	step.ImageContentDigest = digest
+	return nil
+}
+
+func (r containerRunner) Run(ctx context.Context, run StepRun) error {
+	logger, step := run.Logger, run.Step
+	logger.ContainerStepStarted(run.RepoName, run.Index, r.binary, step.Image)
+
+	cidFile, err := ioutil.TempFile(tempDirPrefix, run.TempPrefix+"-container-id")
+	if err != nil {
+		return errors.Wrap(err, "Creating a CID file failed")
+	}
+	_ = os.Remove(cidFile.Name()) // docker exits if this file exists upon `docker run` starting
+	defer func() {
+		cid, err := ioutil.ReadFile(cidFile.Name())
+		_ = os.Remove(cidFile.Name())
+		if err == nil {
+			// Don't use ctx, because the container also needs to be
+			// removed if ctx timed out or was canceled.
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = exec.CommandContext(ctx, r.binary, "rm", "-f", "--", string(cid)).Run()
+		}
+	}()
+
+	const workDir = "/work"
+	cmd := exec.CommandContext(ctx, r.binary, "run",
+		"--rm",
+		"--cidfile", cidFile.Name(),
+		"--workdir", workDir,
+		"--mount", fmt.Sprintf("type=bind,source=%s,target=%s", run.WorkDir, workDir),
+	)
+	cmd.Args = append(cmd.Args, step.ContainerOpts.runArgs()...)
+	for _, cacheDir := range step.CacheDirs {
+		hostDir, err := run.Volumes.hostDir(step.Image, run.RepoName, run.Rev, cacheDir)
+		if err != nil {
+			return err
+		}
+		cmd.Args = append(cmd.Args, "--mount", fmt.Sprintf("type=bind,source=%s,target=%s", hostDir, cacheDir))
+	}
+	// Only pass the names of the variables to docker, which takes the
+	// values from its own environment, so that secrets don't show up in
+	// the process list.
+	cmd.Env = append(os.Environ(), run.Env...)
+	for _, e := range run.Env {
+		cmd.Args = append(cmd.Args, "--env", strings.SplitN(e, "=", 2)[0])
+	}
+	if run.MatchesFile != "" {
+		cmd.Args = append(cmd.Args,
+			"--mount", fmt.Sprintf("type=bind,source=%s,target=%s,readonly", run.MatchesFile, containerMatchesFile),
+			"--env", matchesFileEnv+"="+containerMatchesFile,
+		)
+	}
+	cmd.Args = append(cmd.Args, "--", step.Image)
+	cmd.Args = append(cmd.Args, step.Args...)
+	cmd.Dir = run.WorkDir
+	cmd.Stdout = run.Stdout
+
+	if logStdout, logStderr, ok := logger.RepoStdoutStderr(run.RepoName); ok {
+		cmd.Stdout = io.MultiWriter(logStdout, run.Stdout)
+		cmd.Stderr = logStderr
+		defer flushWriters(logStdout, logStderr)
+	}
+
+	t0 := time.Now()
+	err = cmd.Run()
+	elapsed := time.Since(t0).Round(time.Millisecond)
+	if err != nil {
+		// Timeouts are reported by runAction.
+		if ctx.Err() != context.DeadlineExceeded {
+			logger.ContainerStepErrored(run.RepoName, run.Index, err, elapsed)
+		}
+		err = errors.Wrapf(err, "Running container for image %q failed", step.Image)
+		if isContainerRuntimeError(err) {
+			return retryable("running "+r.binary, err)
+		}
+		return err
+	}
+	logger.ContainerStepDone(run.RepoName, run.Index, elapsed)
+	return nil
+}
+
+// check checks that the container runtime is installed and that the image of
+// the step exists locally or in its registry.
+func (r containerRunner) check(ctx context.Context, step *ActionStep) []stepProblem {
+	if _, err := exec.LookPath(r.binary); err != nil {
+		return []stepProblem{{severity: LintError, path: "type", message: err.Error()}}
+	}
+	if step.Image == "" || isTemplate(step.Image) {
+		return nil
+	}
+
+	out, err := exec.CommandContext(ctx, r.binary, "image", "inspect", "--format", "{{.Id}}", "--", step.Image).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if !isNoSuchImage(out) {
+		return []stepProblem{{severity: LintWarning, path: "image", message: fmt.Sprintf("error inspecting %s image %q: %s", r.binary, step.Image, bytes.TrimSpace(out))}}
+	}
+
+	// Check whether the image can be pulled without pulling it.
+	out, err = exec.CommandContext(ctx, r.binary, "manifest", "inspect", step.Image).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if isNoSuchManifest(out) {
+		return []stepProblem{{severity: LintError, path: "image", message: fmt.Sprintf("%s image %q doesn't exist locally or in its registry", r.binary, step.Image)}}
+	}
+	return []stepProblem{{severity: LintWarning, path: "image", message: fmt.Sprintf("%s image %q doesn't exist locally and its registry couldn't be checked: %s", r.binary, step.Image, bytes.TrimSpace(out))}}
+}
+
+// imageContentDigest gets the content digest for the image. Note that this
+// is different from the "distribution digest" (which is what you can use to specify
+// an image to `docker run`, as in `my/image@sha256:xxx`). We need to use the
+// content digest because the distribution digest is only computed for images that
+// have been pulled from or pushed to a registry. See
+// https://windsock.io/explaining-docker-image-ids/ under "A Final Twist" for a good
+// explanation.
+func (r containerRunner) imageContentDigest(ctx context.Context, image string, logger *ActionLogger) (string, error) {
+	// TODO!(sqs): is image id the right thing to use here? it is NOT the
+	// digest. but the digest is not calculated for all images (unless they are
+	// pulled/pushed from/to a registry), see
+	// https://github.com/moby/moby/issues/32016.
+	out, err := exec.CommandContext(ctx, r.binary, "image", "inspect", "--format", "{{.Id}}", "--", image).CombinedOutput()
+	if err != nil {
+		if !isNoSuchImage(out) {
+			return "", fmt.Errorf("error inspecting %s image %q: %s", r.binary, image, bytes.TrimSpace(out))
+		}
+		logger.Infof("Pulling %s image %q...\n", r.binary, image)
+		pullCmd := exec.CommandContext(ctx, r.binary, "image", "pull", image)
+		prefix := fmt.Sprintf("%s image pull %s", r.binary, image)
+		pullCmd.Stdout = logger.InfoPipe(prefix)
+		pullCmd.Stderr = logger.ErrorPipe(prefix)
+
+		err = pullCmd.Start()
+		if err != nil {
+			return "", fmt.Errorf("error pulling %s image %q: %s", r.binary, image, err)
+		}
+		err = pullCmd.Wait()
+		if err != nil {
+			return "", retryable("pulling the "+r.binary+" image", fmt.Errorf("error pulling %s image %q: %s", r.binary, image, err))
+		}
+	}
+	out, err = exec.CommandContext(ctx, r.binary, "image", "inspect", "--format", "{{.Id}}", "--", image).CombinedOutput()
+	// This time, the image MUST be present, so the issue must be something else.
+	if err != nil {
+		return "", fmt.Errorf("error inspecting %s image %q: %s", r.binary, image, bytes.TrimSpace(out))
+	}
+	id := string(bytes.TrimSpace(out))
+	if id == "" {
+		return "", fmt.Errorf("unexpected empty %s image content ID for %q", r.binary, image)
+	}
+	return id, nil
+}
+
+// isNoSuchImage returns whether the output of `image inspect` says that the
+// image doesn't exist locally. Docker and podman word this differently.
+func isNoSuchImage(out []byte) bool {
+	s := strings.ToLower(string(out))
+	return strings.Contains(s, "no such image") || strings.Contains(s, "image not known")
+}
+
+// isNoSuchManifest returns whether the output of `manifest inspect` says that
+// the image doesn't exist in its registry.
+func isNoSuchManifest(out []byte) bool {
+	s := strings.ToLower(string(out))
+	return strings.Contains(s, "no such manifest") || strings.Contains(s, "manifest unknown")
+}
+
+// isContainerRuntimeError returns whether `docker run` or `podman run` failed
+// itself, for example because the Docker daemon couldn't be reached, as
+// opposed to the command in the container exiting with a non-zero exit code.
+func isContainerRuntimeError(err error) bool {
+	var ee *exec.ExitError
+	// See https://docs.docker.com/engine/reference/run/#exit-status
+	return errors.As(err, &ee) && ee.ExitCode() == 125
+}
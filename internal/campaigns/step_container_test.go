@@ -0,0 +1,50 @@
+package campaigns
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestContainerOpts(t *testing.T) {
+	action := Action{Steps: []*ActionStep{
+		{Type: "docker", Image: "alpine", ContainerOpts: ContainerOpts{Network: "bridge"}},
+		{Type: "podman", Image: "alpine", ContainerOpts: ContainerOpts{CPUs: 0.5}},
+		{Type: "command", Args: []string{"ls"}},
+	}}
+	ApplyContainerDefaults(action, ContainerOpts{CPUs: 2, Memory: "1g", Network: "none", User: "1000:1000"})
+
+	want := [][]string{
+		{"--cpus", "2", "--memory", "1g", "--network", "bridge", "--user", "1000:1000"},
+		{"--cpus", "0.5", "--memory", "1g", "--network", "none", "--user", "1000:1000"},
+		nil,
+	}
+	for i, step := range action.Steps {
+		if diff := cmp.Diff(want[i], step.ContainerOpts.runArgs()); diff != "" {
+			t.Errorf("wrong run args for step %d (-want +got):\n%s", i, diff)
+		}
+	}
+
+	for _, tc := range []struct {
+		opts    ContainerOpts
+		wantErr string
+	}{
+		{opts: ContainerOpts{Memory: "512m", Network: "host"}},
+		{opts: ContainerOpts{Memory: "lots"}, wantErr: "invalid memory"},
+		{opts: ContainerOpts{Network: "internet"}, wantErr: "invalid network"},
+		{opts: ContainerOpts{CPUs: -1}, wantErr: "invalid cpus"},
+	} {
+		err := tc.opts.Validate()
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("unexpected error for %+v: %s", tc.opts, err)
+		} else if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("wrong error for %+v: have %v; want %q", tc.opts, err, tc.wantErr)
+		}
+	}
+
+	err := ValidateActionDefinition([]byte(`{"scopeQuery": "x", "steps": [{"type": "command", "args": ["ls"], "network": "none"}]}`))
+	if err == nil || !strings.Contains(err.Error(), "only supported by container steps") {
+		t.Errorf("wrong error for network of command step: %v", err)
+	}
+}
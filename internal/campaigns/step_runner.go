@@ -0,0 +1,113 @@
+package campaigns
+
+import (
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StepRunner runs the steps of an action that have a specific type.
+type StepRunner interface {
+	// Validate checks that the fields of the step that the runner requires
+	// are set and that it doesn't set fields the runner doesn't support.
+	Validate(step *ActionStep) error
+
+	// Prepare is called once for every step of the runner's type before the
+	// action is executed in any repository, e.g. to pull images.
+	Prepare(ctx context.Context, step *ActionStep, retry RetryPolicy, logger *ActionLogger) error
+
+	// Run runs the step in the repository checked out in run.WorkDir.
+	Run(ctx context.Context, run StepRun) error
+}
+
+// StepRun describes the execution of a single step in a repository.
+type StepRun struct {
+	// Index is the index of the step in the action.
+	Index int
+	Step  *ActionStep
+
+	RepoName string
+	Rev      string
+
+	// WorkDir is the directory in which the repository is checked out.
+	WorkDir string
+
+	// MatchesFile is the file outside of WorkDir that lists the files and
+	// lines that matched the scope query. Its path is passed to the step in
+	// the SRC_MATCHES_FILE environment variable.
+	MatchesFile string
+
+	// TempPrefix is the prefix for temporary files created for the step.
+	TempPrefix string
+
+	// Env holds the environment variables, in "NAME=value" form, that are
+	// added to the environment of the step.
+	Env []string
+
+	// Stdout receives the standard output of the step, in addition to the
+	// logger.
+	Stdout io.Writer
+
+	// Volumes holds the host directories for the cacheDirs of the step.
+	Volumes CacheDirVolumes
+
+	Logger *ActionLogger
+}
+
+// imageRunner is implemented by StepRunners that run steps in container
+// images, whose content digest is part of the cache key.
+type imageRunner interface {
+	imageContentDigest(ctx context.Context, image string, logger *ActionLogger) (string, error)
+}
+
+var stepRunners = map[string]StepRunner{
+	"command": commandRunner{},
+	"script":  scriptRunner{},
+	"docker":  containerRunner{binary: "docker"},
+	"podman":  containerRunner{binary: "podman"},
+}
+
+// RegisterStepRunner makes runner run the steps with the given type. It must
+// be called before any action is validated or executed, e.g. in an init
+// function.
+func RegisterStepRunner(typ string, runner StepRunner) {
+	stepRunners[typ] = runner
+}
+
+// StepTypes returns the step types that have a registered StepRunner, sorted
+// by name.
+func StepTypes() []string {
+	types := make([]string, 0, len(stepRunners))
+	for typ := range stepRunners {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func stepRunner(typ string) (StepRunner, error) {
+	runner, ok := stepRunners[typ]
+	if !ok {
+		return nil, errors.Errorf("unrecognized run type %q", typ)
+	}
+	return runner, nil
+}
+
+// pullImage returns the content digest of the image, retrying the pull of
+// the image according to the retry policy.
+func pullImage(ctx context.Context, runner imageRunner, image string, retry RetryPolicy, logger *ActionLogger) (string, error) {
+	var digest string
+	_, err := retry.do(ctx, func(int) (err error) {
+		digest, err = runner.imageContentDigest(ctx, image, logger)
+		return err
+	}, func(attempt int, delay time.Duration, err error) {
+		logger.Warnf("Pulling image %q failed, retrying in %s (attempt %d of %d): %s\n", image, delay, attempt, retry.MaxAttempts, err)
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to get image content digest")
+	}
+	return digest, nil
+}
@@ -0,0 +1,122 @@
+package campaigns
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+type testStepRunner struct{ commandRunner }
+
+func TestValidateActionDefinitionStepTypes(t *testing.T) {
+	RegisterStepRunner("test", testStepRunner{})
+	defer delete(stepRunners, "test")
+
+	for _, tc := range []struct {
+		name    string
+		def     string
+		wantErr string
+	}{
+		{
+			name: "command",
+			def:  `{"scopeQuery": "x", "steps": [{"type": "command", "args": ["ls"]}]}`,
+		},
+		{
+			name: "script",
+			def:  `{"scopeQuery": "x", "steps": [{"type": "script", "script": "ls\nls"}]}`,
+		},
+		{
+			name: "podman",
+			def:  `{"scopeQuery": "x", "steps": [{"type": "podman", "image": "alpine"}]}`,
+		},
+		{
+			name: "registered runner",
+			def:  `{"scopeQuery": "x", "steps": [{"type": "test", "args": ["ls"]}]}`,
+		},
+		{
+			name:    "unknown type",
+			def:     `{"scopeQuery": "x", "steps": [{"type": "vm", "args": ["ls"]}]}`,
+			wantErr: "steps.0.type: steps.0.type must be one of the following",
+		},
+		{
+			name:    "missing image",
+			def:     `{"scopeQuery": "x", "steps": [{"type": "docker", "args": ["ls"]}]}`,
+			wantErr: "steps.0: image is required",
+		},
+		{
+			name:    "image for command",
+			def:     `{"scopeQuery": "x", "steps": [{"type": "command", "args": ["ls"]}, {"type": "command", "args": ["ls"], "image": "alpine"}]}`,
+			wantErr: "steps.1: image is not supported",
+		},
+		{
+			name:    "invalid timeout",
+			def:     `{"scopeQuery": "x", "steps": [{"type": "command", "args": ["ls"], "timeout": "0s"}]}`,
+			wantErr: "steps.0: invalid timeout",
+		},
+		{
+			name:    "missing script",
+			def:     `{"scopeQuery": "x", "steps": [{"type": "script", "args": ["ls"]}]}`,
+			wantErr: "steps.0: script is required",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateActionDefinition([]byte(tc.def))
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("wrong error: have %v; want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunActionScript(t *testing.T) {
+	defer setGitIdentity()()
+	ts := newZipArchiveServer(t, map[string]string{"README.md": "# README\n"})
+	defer ts.Close()
+
+	repo := ActionRepo{Name: "github.com/sourcegraph/src-cli", Rev: "deadbeef"}
+	steps := []*ActionStep{
+		{Type: "script", Script: "set -e\necho \"$1\" > first.txt\necho ${{ .Repository.Name }} > name.txt\n", Args: []string{"hello"}},
+		{Type: "script", Script: "#!/bin/sh\necho shebang > shebang.txt\n"},
+	}
+	steps, err := expandSteps(steps, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := NewActionLogger(false, false)
+	if _, err := logger.AddRepo(repo); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.RepoFinished(repo.Name, false, nil)
+
+	patch, _, err := runAction(context.Background(), NewZipWorkspaceCreator(ts.URL, "", nil), CacheDirVolumes{}, "action-test", repo, steps, nil, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"+hello", "+github.com/sourcegraph/src-cli", "+shebang"} {
+		if !strings.Contains(string(patch), want) {
+			t.Errorf("patch does not contain %q:\n%s", want, patch)
+		}
+	}
+}
+
+func TestScriptInterpreter(t *testing.T) {
+	for script, want := range map[string]string{
+		"echo hello\n":                            "sh",
+		"#!/bin/bash\necho hello\n":               "/bin/bash",
+		"#!/usr/bin/env bash\necho hello\n":       "bash",
+		"#!/usr/bin/env -S python3 -u\nprint()\n": "python3",
+		"#!/usr/bin/env LANG=C perl\nprint\n":     "perl",
+		"#! /usr/bin/env\n":                       "/usr/bin/env",
+	} {
+		if have := scriptInterpreter(script); have != want {
+			t.Errorf("wrong interpreter for %q: have %q; want %q", script, have, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package campaigns
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	templateLeftDelim  = "${{"
+	templateRightDelim = "}}"
+)
+
+// stepTemplateData is the data that templates in the arguments and image of
+// an action step are executed with, e.g. `${{ .Repository.Name }}`.
+type stepTemplateData struct {
+	Repository repoTemplateData
+}
+
+type repoTemplateData struct {
+	ID      string
+	Name    string
+	Rev     string
+	BaseRef string
+
+	// FileMatches holds the paths of the files that matched the scope
+	// query in the repository.
+	FileMatches []string
+
+	// Matches holds the files that matched the scope query, including the
+	// lines that matched in them.
+	Matches []FileMatch
+}
+
+var stepTemplateFuncs = template.FuncMap{
+	"join":    strings.Join,
+	"replace": strings.Replace,
+	"split":   strings.Split,
+}
+
+func newStepTemplateData(repo ActionRepo) stepTemplateData {
+	data := stepTemplateData{Repository: repoTemplateData{
+		ID:          repo.ID,
+		Name:        repo.Name,
+		Rev:         repo.Rev,
+		BaseRef:     repo.BaseRef,
+		FileMatches: make([]string, 0, len(repo.FileMatches)),
+		Matches:     repo.FileMatches,
+	}}
+	for _, m := range repo.FileMatches {
+		data.Repository.FileMatches = append(data.Repository.FileMatches, m.Path)
+	}
+	return data
+}
+
+// isTemplate returns whether s contains a template that needs to be expanded.
+func isTemplate(s string) bool {
+	return strings.Contains(s, templateLeftDelim)
+}
+
+func parseTemplate(s string) (*template.Template, error) {
+	t, err := template.New("").
+		Delims(templateLeftDelim, templateRightDelim).
+		Option("missingkey=error").
+		Funcs(stepTemplateFuncs).
+		Parse(s)
+	return t, errors.Wrapf(err, "parsing template %q", s)
+}
+
+func expandTemplate(s string, data stepTemplateData) (string, error) {
+	if !isTemplate(s) {
+		return s, nil
+	}
+
+	t, err := parseTemplate(s)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", errors.Wrapf(err, "expanding template %q", s)
+	}
+	return buf.String(), nil
+}
+
+// validateStepTemplates checks that the templates in the arguments, the image
+// and the script of the steps can be parsed.
+func validateStepTemplates(steps []*ActionStep) error {
+	for i, step := range steps {
+		for _, s := range append([]string{step.Image, step.Script}, step.Args...) {
+			if !isTemplate(s) {
+				continue
+			}
+			if _, err := parseTemplate(s); err != nil {
+				return errors.Wrapf(err, "step %d", i)
+			}
+		}
+	}
+	return nil
+}
+
+// expandSteps returns copies of the steps in which the templates in the
+// arguments, the image and the script are expanded for the given repository.
+func expandSteps(steps []*ActionStep, repo ActionRepo) ([]*ActionStep, error) {
+	data := newStepTemplateData(repo)
+
+	expanded := make([]*ActionStep, 0, len(steps))
+	for i, step := range steps {
+		s := *step
+
+		image, err := expandTemplate(step.Image, data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "step %d", i)
+		}
+		s.Image = image
+
+		script, err := expandTemplate(step.Script, data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "step %d", i)
+		}
+		s.Script = script
+
+		if len(step.Args) > 0 {
+			s.Args = make([]string, len(step.Args))
+			for j, arg := range step.Args {
+				if s.Args[j], err = expandTemplate(arg, data); err != nil {
+					return nil, errors.Wrapf(err, "step %d", i)
+				}
+			}
+		}
+
+		expanded = append(expanded, &s)
+	}
+	return expanded, nil
+}
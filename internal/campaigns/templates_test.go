@@ -0,0 +1,46 @@
+package campaigns
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpandSteps(t *testing.T) {
+	repo := ActionRepo{
+		ID:          "UmVwb3NpdG9yeTox",
+		Name:        "github.com/sourcegraph/src-cli",
+		Rev:         "deadbeef",
+		BaseRef:     "refs/heads/master",
+		FileMatches: []FileMatch{{Path: "CHANGELOG.md"}, {Path: "docs/CHANGELOG.md"}},
+	}
+	steps := []*ActionStep{
+		{Type: "command", Args: []string{"sh", "-c", "echo ${{ .Repository.Name }}@${{ .Repository.Rev }} >> ${{ join .Repository.FileMatches \" \" }}"}},
+		{Type: "docker", Image: "alpine:${{ replace .Repository.BaseRef \"refs/heads/\" \"\" -1 }}", Args: []string{"${{ .Repository.ID }}", "static"}},
+	}
+
+	expanded, err := expandSteps(steps, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*ActionStep{
+		{Type: "command", Args: []string{"sh", "-c", "echo github.com/sourcegraph/src-cli@deadbeef >> CHANGELOG.md docs/CHANGELOG.md"}},
+		{Type: "docker", Image: "alpine:master", Args: []string{"UmVwb3NpdG9yeTox", "static"}},
+	}
+	if diff := cmp.Diff(want, expanded); diff != "" {
+		t.Errorf("wrong expanded steps (-want +got):\n%s", diff)
+	}
+
+	// The steps of the action must not be modified.
+	if steps[1].Args[0] != "${{ .Repository.ID }}" {
+		t.Errorf("step was modified: %+v", steps[1])
+	}
+
+	if _, err := expandSteps([]*ActionStep{{Args: []string{"${{ .Repository.Nope }}"}}}, repo); err == nil {
+		t.Error("unexpected nil error for unknown field")
+	}
+	if err := validateStepTemplates([]*ActionStep{{Args: []string{"${{ .Repository.Name"}}}); err == nil {
+		t.Error("unexpected nil error for unterminated template")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !darwin && !linux && !windows
+// +build !darwin,!linux,!windows
+
+package campaigns
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var errTerminalNotSupported = errors.New("the dashboard is not supported on this platform")
+
+func setupTerminal(in, out *os.File) (restore func() error, err error) {
+	return nil, errTerminalNotSupported
+}
+
+func readTerminal(in *os.File, p []byte) (int, error) {
+	return 0, errTerminalNotSupported
+}
+
+func terminalSize(out *os.File) (width, height int, err error) {
+	return 0, 0, errTerminalNotSupported
+}
@@ -0,0 +1,49 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package campaigns
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// setupTerminal puts the terminal in cbreak mode: input is available without
+// waiting for a newline and isn't echoed, but Ctrl-C still interrupts the
+// process. Reads time out after 100ms, so that readTerminal never blocks
+// for long.
+func setupTerminal(in, out *os.File) (restore func() error, err error) {
+	fd := int(in.Fd())
+	orig, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	t := *orig
+	t.Lflag &^= unix.ICANON | unix.ECHO
+	t.Cc[unix.VMIN] = 0
+	t.Cc[unix.VTIME] = 1
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &t); err != nil {
+		return nil, err
+	}
+	return func() error { return unix.IoctlSetTermios(fd, ioctlSetTermios, orig) }, nil
+}
+
+// readTerminal reads the input of the terminal, returning 0 bytes if there
+// was none for 100ms.
+func readTerminal(in *os.File, p []byte) (int, error) {
+	n, err := unix.Read(int(in.Fd()), p)
+	if err == unix.EINTR || err == unix.EAGAIN {
+		return 0, nil
+	}
+	return n, err
+}
+
+func terminalSize(out *os.File) (width, height int, err error) {
+	ws, err := unix.IoctlGetWinsize(int(out.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
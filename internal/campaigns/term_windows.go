@@ -0,0 +1,62 @@
+package campaigns
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// setupTerminal disables the line input and echo of the console and enables
+// the processing of ANSI escape sequences in its output. Ctrl-C still
+// interrupts the process.
+func setupTerminal(in, out *os.File) (restore func() error, err error) {
+	inHandle, outHandle := windows.Handle(in.Fd()), windows.Handle(out.Fd())
+
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(inHandle, &inMode); err != nil {
+		return nil, err
+	}
+	if err := windows.GetConsoleMode(outHandle, &outMode); err != nil {
+		return nil, err
+	}
+
+	if err := windows.SetConsoleMode(inHandle, inMode&^(windows.ENABLE_LINE_INPUT|windows.ENABLE_ECHO_INPUT)|windows.ENABLE_VIRTUAL_TERMINAL_INPUT); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(outHandle, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		_ = windows.SetConsoleMode(inHandle, inMode)
+		return nil, err
+	}
+
+	return func() error {
+		if err := windows.SetConsoleMode(inHandle, inMode); err != nil {
+			return err
+		}
+		return windows.SetConsoleMode(outHandle, outMode)
+	}, nil
+}
+
+// readTerminal reads the input of the console, returning 0 bytes if there
+// was none for 100ms.
+func readTerminal(in *os.File, p []byte) (int, error) {
+	handle := windows.Handle(in.Fd())
+	event, err := windows.WaitForSingleObject(handle, 100)
+	if err != nil {
+		return 0, err
+	}
+	if event == uint32(windows.WAIT_TIMEOUT) {
+		return 0, nil
+	}
+
+	var n uint32
+	err = windows.ReadFile(handle, p, &n, nil)
+	return int(n), err
+}
+
+func terminalSize(out *os.File) (width, height int, err error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(out.Fd()), &info); err != nil {
+		return 0, 0, err
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
@@ -0,0 +1,225 @@
+package campaigns
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// WorkspaceCreator creates the workspaces in which an action is executed.
+type WorkspaceCreator interface {
+	// Create creates a workspace that contains the files of the repository
+	// at repo.Rev. prefix is used for the names of temporary files.
+	Create(ctx context.Context, repo ActionRepo, prefix string) (Workspace, error)
+}
+
+// Workspace is a directory with a git repository whose HEAD is a commit of
+// the files of a repository and whose work tree and index are clean, so that
+// the changes made by an action can be computed with `git diff`.
+type Workspace interface {
+	Dir() string
+	Remove() error
+}
+
+type workspace struct {
+	dir    string
+	remove func() error
+}
+
+func (w *workspace) Dir() string   { return w.dir }
+func (w *workspace) Remove() error { return w.remove() }
+
+func runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "'git %s' failed: %s", strings.Join(args, " "), out)
+	}
+	return out, nil
+}
+
+// NewZipWorkspaceCreator returns a WorkspaceCreator that downloads a ZIP
+// archive of every repository from Sourcegraph and commits its contents to a
+// new git repository.
+func NewZipWorkspaceCreator(endpoint, accessToken string, additionalHeaders map[string]string) WorkspaceCreator {
+	return &zipWorkspaceCreator{
+		endpoint:          endpoint,
+		accessToken:       accessToken,
+		additionalHeaders: additionalHeaders,
+	}
+}
+
+type zipWorkspaceCreator struct {
+	endpoint          string
+	accessToken       string
+	additionalHeaders map[string]string
+}
+
+func (c *zipWorkspaceCreator) Create(ctx context.Context, repo ActionRepo, prefix string) (Workspace, error) {
+	zipFile, err := fetchRepositoryArchive(ctx, c.endpoint, c.accessToken, c.additionalHeaders, repo.Name, repo.Rev)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching ZIP archive failed")
+	}
+	defer os.Remove(zipFile.Name())
+
+	volumeDir, err := unzipToTempDir(ctx, zipFile.Name(), prefix)
+	if err != nil {
+		return nil, retryable("unzipping the archive", errors.Wrap(err, "Unzipping the ZIP archive failed"))
+	}
+	ws := &workspace{dir: volumeDir, remove: func() error { return os.RemoveAll(volumeDir) }}
+
+	if _, err := runGit(ctx, volumeDir, "init"); err != nil {
+		ws.Remove()
+		return nil, errors.Wrap(err, "git init failed")
+	}
+	// --force because we want previously "gitignored" files in the repository
+	if _, err := runGit(ctx, volumeDir, "add", "--force", "--all"); err != nil {
+		ws.Remove()
+		return nil, errors.Wrap(err, "git add failed")
+	}
+	if _, err := runGit(ctx, volumeDir, "commit", "--quiet", "--all", "-m", "src-action-exec"); err != nil {
+		ws.Remove()
+		return nil, errors.Wrap(err, "git commit failed")
+	}
+	return ws, nil
+}
+
+// localWorkspaceCreator creates workspaces for local repositories, see
+// LocalRepos.
+type localWorkspaceCreator struct{}
+
+func (localWorkspaceCreator) Create(ctx context.Context, repo ActionRepo, prefix string) (Workspace, error) {
+	volumeDir, err := cloneLocalRepository(ctx, repo.LocalPath, repo.Rev, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cloning the local repository failed")
+	}
+	return &workspace{dir: volumeDir, remove: func() error { return os.RemoveAll(volumeDir) }}, nil
+}
+
+// NewMirrorWorkspaceCreator returns a WorkspaceCreator that keeps a bare
+// mirror of every repository in dir, which is fetched incrementally, and
+// creates a git worktree at the revision for every execution. remote is a
+// template, like the arguments of steps, for the URL the mirror is fetched
+// from, e.g. "https://${{ .Repository.Name }}.git".
+func NewMirrorWorkspaceCreator(dir, remote string) (WorkspaceCreator, error) {
+	if _, err := parseTemplate(remote); err != nil {
+		return nil, errors.Wrap(err, "invalid remote URL template")
+	}
+	return &mirrorWorkspaceCreator{
+		dir:    dir,
+		remote: remote,
+		locks:  map[string]*sync.Mutex{},
+	}, nil
+}
+
+type mirrorWorkspaceCreator struct {
+	dir    string
+	remote string
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // by mirror directory
+}
+
+// lock serializes the operations on a mirror.
+func (c *mirrorWorkspaceCreator) lock(mirror string) func() {
+	c.mu.Lock()
+	l, ok := c.locks[mirror]
+	if !ok {
+		l = &sync.Mutex{}
+		c.locks[mirror] = l
+	}
+	c.mu.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+func (c *mirrorWorkspaceCreator) Create(ctx context.Context, repo ActionRepo, prefix string) (Workspace, error) {
+	mirror := filepath.Join(c.dir, strings.NewReplacer("/", "-", ":", "-").Replace(repo.Name)+".git")
+	unlock := c.lock(mirror)
+	defer unlock()
+
+	if err := c.update(ctx, repo, mirror); err != nil {
+		return nil, err
+	}
+
+	volumeDir, err := ioutil.TempDir(tempDirPrefix, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := runGit(ctx, mirror, "worktree", "add", "--quiet", "--detach", volumeDir, repo.Rev); err != nil {
+		os.RemoveAll(volumeDir)
+		return nil, errors.Wrap(err, "Creating the worktree failed")
+	}
+
+	return &workspace{dir: volumeDir, remove: func() error {
+		unlock := c.lock(mirror)
+		defer unlock()
+
+		// Don't use ctx, because the worktree also needs to be removed if
+		// ctx timed out or was canceled.
+		_, err := runGit(context.Background(), mirror, "worktree", "remove", "--force", volumeDir)
+		if rerr := os.RemoveAll(volumeDir); err == nil {
+			err = rerr
+		}
+		return err
+	}}, nil
+}
+
+// update creates the mirror of the repository if it doesn't exist yet and
+// fetches it if it doesn't contain repo.Rev.
+func (c *mirrorWorkspaceCreator) update(ctx context.Context, repo ActionRepo, mirror string) error {
+	remote, err := expandTemplate(c.remote, newStepTemplateData(repo))
+	if err != nil {
+		return errors.Wrap(err, "invalid remote URL template")
+	}
+
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		if err := os.MkdirAll(c.dir, 0700); err != nil {
+			return err
+		}
+		if _, err := runGit(ctx, "", "init", "--quiet", "--bare", mirror); err != nil {
+			return errors.Wrap(err, "Creating the mirror failed")
+		}
+		if _, err := runGit(ctx, mirror, "remote", "add", "origin", remote); err != nil {
+			return errors.Wrap(err, "Creating the mirror failed")
+		}
+	} else if err != nil {
+		return err
+	} else if _, err := runGit(ctx, mirror, "remote", "set-url", "origin", remote); err != nil {
+		return err
+	}
+
+	// Remove the worktrees of executions that didn't clean up after
+	// themselves, e.g. because src was killed.
+	if _, err := runGit(ctx, mirror, "worktree", "prune"); err != nil {
+		return err
+	}
+
+	hasRev := func() bool {
+		_, err := runGit(ctx, mirror, "cat-file", "-e", repo.Rev+"^{commit}")
+		return err == nil
+	}
+	if hasRev() {
+		return nil
+	}
+
+	if _, err := runGit(ctx, mirror, "fetch", "--quiet", "--prune", "origin", "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"); err != nil {
+		return retryable("fetching the mirror", errors.Wrap(err, "Fetching the mirror failed"))
+	}
+	if hasRev() {
+		return nil
+	}
+	// The revision might not be reachable from any branch or tag anymore.
+	if _, err := runGit(ctx, mirror, "fetch", "--quiet", "origin", repo.Rev); err != nil {
+		return errors.Wrapf(err, "Fetching revision %s failed", repo.Rev)
+	}
+	return nil
+}
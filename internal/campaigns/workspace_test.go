@@ -0,0 +1,102 @@
+package campaigns
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMirrorWorkspaceCreator(t *testing.T) {
+	defer setGitIdentity()()
+
+	dir, err := ioutil.TempDir("", "src-workspaces")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"README.md":      "# README\n",
+		"docs/intro.txt": "this is the intro\n",
+	}
+	ts := newZipArchiveServer(t, files)
+	defer ts.Close()
+
+	// The "code host" the mirror is fetched from.
+	origin := filepath.Join(dir, "origin")
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = origin
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s", strings.Join(args, " "), out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(filepath.Join(origin, "docs"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(origin, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "--quiet")
+	git("add", "--all")
+	git("commit", "--quiet", "-m", "initial")
+
+	mirrors, err := NewMirrorWorkspaceCreator(filepath.Join(dir, "mirrors"), filepath.Join(dir, "${{ .Repository.Name }}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []*ActionStep{{Type: "command", Args: []string{"sh", "-c", "sed -i.bak s/intro/introduction/ docs/intro.txt && rm docs/intro.txt.bak && echo '## Usage' >> README.md && echo new > new.txt"}}}
+	run := func(workspaces WorkspaceCreator, repo ActionRepo) string {
+		t.Helper()
+		logger := NewActionLogger(false, false)
+		if _, err := logger.AddRepo(repo); err != nil {
+			t.Fatal(err)
+		}
+		defer logger.RepoFinished(repo.Name, false, nil)
+
+		patch, _, err := runAction(context.Background(), workspaces, CacheDirVolumes{}, "action-test", repo, steps, nil, nil, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(patch)
+	}
+
+	repo := ActionRepo{Name: "origin", Rev: git("rev-parse", "HEAD")}
+	fromZip := run(NewZipWorkspaceCreator(ts.URL, "", nil), repo)
+	fromMirror := run(mirrors, repo)
+	if !strings.Contains(fromZip, "+++ new.txt") {
+		t.Fatalf("patch does not contain new file:\n%s", fromZip)
+	}
+	if fromZip != fromMirror {
+		t.Errorf("patches differ:\nzip:\n%s\nmirror:\n%s", fromZip, fromMirror)
+	}
+
+	// New commits are fetched into the existing mirror.
+	if err := ioutil.WriteFile(filepath.Join(origin, "README.md"), []byte("# Changed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	git("commit", "--quiet", "--all", "-m", "change")
+	repo.Rev = git("rev-parse", "HEAD")
+	if patch := run(mirrors, repo); !strings.Contains(patch, " # Changed\n+## Usage\n") {
+		t.Errorf("patch not computed at new revision:\n%s", patch)
+	}
+
+	// Worktrees are removed after the execution.
+	out, err := exec.Command("git", "-C", filepath.Join(dir, "mirrors", "origin.git"), "worktree", "list").CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(strings.Split(strings.TrimSpace(string(out)), "\n")); n != 1 {
+		t.Errorf("worktrees were not removed:\n%s", out)
+	}
+}
@@ -12,9 +12,9 @@ const ActionSchemaJSON = `{
   "type": "object",
   "additionalProperties": false,
   "required": [
-    "scopeQuery",
     "steps"
   ],
+  "oneOf": [{ "required": ["scopeQuery"] }, { "required": ["scope"] }],
   "properties": {
     "$schema": {
       "description": "URL of the JSON Schema for an Action Definition.",
@@ -26,53 +26,297 @@ const ActionSchemaJSON = `{
       "type": "string",
       "minLength": 1
     },
+    "scope": {
+      "description": "The repositories over which to run the action, as an alternative to \"scopeQuery\": the results of several search queries and explicitly listed repositories, which can be pinned to a branch or revision. Use 'src actions scope-query' to see which repositories are in scope.",
+      "type": "object",
+      "additionalProperties": false,
+      "anyOf": [{ "required": ["queries"] }, { "required": ["repositories"] }, { "required": ["repositoriesFile"] }],
+      "properties": {
+        "queries": {
+          "description": "Sourcegraph search queries whose results are combined as given by \"combine\".",
+          "type": "array",
+          "minItems": 1,
+          "items": {
+            "type": "string",
+            "minLength": 1
+          }
+        },
+        "combine": {
+          "description": "Whether the repositories matched by any (\"union\", the default) or by all (\"intersection\") of the queries are in scope.",
+          "type": "string",
+          "enum": ["union", "intersection"]
+        },
+        "repositories": {
+          "description": "Repositories that are in scope in addition to the ones matched by the queries. Their branch or revision also applies if they are matched by the queries.",
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/scopeRepository"
+          }
+        },
+        "repositoriesFile": {
+          "description": "A file that lists more repositories, one per line in the string form of \"repositories\". Empty lines and lines starting with # are ignored. Relative paths are relative to the action file.",
+          "type": "string",
+          "minLength": 1
+        }
+      }
+    },
+    "env": {
+      "description": "Environment variables that are set for every step of the action.",
+      "$ref": "#/definitions/env"
+    },
     "steps": {
       "description": "A list of action steps to execute in each repository.",
       "type": "array",
       "minItems": 1,
       "items": {
         "type": "object",
-        "required": ["type"],
+        "oneOf": [{ "required": ["type"] }, { "required": ["uses"] }],
         "additionalProperties": false,
         "properties": {
           "type": {
-            "description": "Can be either \"command\", which executes the step in the native environment (OS) of the machine where 'src actions exec' is executed, or \"docker\" which runs a container with the repository contents mounted in at ` + "`" + `/work` + "`" + `. Note that local images (not from a Docker registry) must be built manually prior to executing.",
+            "description": "The runtime of the step: \"command\" executes \"args\" in the native environment (OS) of the machine where 'src actions exec' is executed, \"script\" executes \"script\" there, and \"docker\" and \"podman\" run a container of \"image\" with the repository contents mounted in at ` + "`" + `/work` + "`" + `. Note that local images (not from a registry) must be built manually prior to executing.",
             "type": "string",
-            "enum": ["command", "docker"]
+            "minLength": 1
           },
           "args": {
-            "description": "The command and its argument to execute if \"type\" is \"command\", or a list of arguments to be passed to the Docker container if \"type\" is \"docker\".",
+            "description": "The command and its argument to execute if \"type\" is \"command\", a list of arguments to be passed to the container if \"type\" is \"docker\" or \"podman\", or the arguments of the script if \"type\" is \"script\". Arguments can contain templates enclosed in ${{ and }}, such as ${{ .Repository.Name }}, which are expanded for every repository.",
             "type": "array",
             "minItems": 1,
             "items": {
               "type": "string"
             }
           },
+          "script": {
+            "description": "The shell script to execute if \"type\" is \"script\". It's executed with sh, unless it starts with a shebang line. \"args\" are passed to it as arguments. Can contain templates enclosed in ${{ and }}, which are expanded for every repository.",
+            "type": "string",
+            "minLength": 1
+          },
           "image": {
-            "description": "The Docker image handle for running the container executing this step. Just like when running ` + "`" + `docker run` + "`" + `, ` + "`" + `args` + "`" + ` here override the default ` + "`" + `CMD` + "`" + ` to be executed.",
+            "description": "The container image for running the container executing this step if \"type\" is \"docker\" or \"podman\". Just like when running ` + "`" + `docker run` + "`" + `, ` + "`" + `args` + "`" + ` here override the default ` + "`" + `CMD` + "`" + ` to be executed. Can contain templates enclosed in ${{ and }}, which are expanded for every repository.",
+            "type": "string",
+            "minLength": 1
+          },
+          "timeout": {
+            "description": "The maximum duration of the step, e.g. \"30s\" or \"5m\". A step that exceeds it is stopped and the execution in the repository fails with a timeout. The -timeout flag of 'src actions exec' limits the duration of all steps in a repository.",
+            "type": "string",
+            "pattern": "^([0-9]+(\\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$"
+          },
+          "cpus": {
+            "description": "The number of CPUs the container of a \"docker\" or \"podman\" step can use, e.g. 1.5. Defaults to the value of the -container-cpus flag of 'src actions exec'.",
+            "type": "number",
+            "exclusiveMinimum": 0
+          },
+          "memory": {
+            "description": "The maximum amount of memory the container of a \"docker\" or \"podman\" step can use, e.g. \"512m\" or \"2g\". Defaults to the value of the -container-memory flag of 'src actions exec'.",
+            "type": "string",
+            "pattern": "^[0-9]+[bkmgBKMG]?$"
+          },
+          "network": {
+            "description": "The network the container of a \"docker\" or \"podman\" step is connected to. Use \"none\" to run untrusted code without network access. Defaults to the value of the -container-network flag of 'src actions exec'.",
+            "type": "string",
+            "enum": ["none", "bridge", "host"]
+          },
+          "user": {
+            "description": "The user, and optionally the group, that the container of a \"docker\" or \"podman\" step runs as, e.g. \"1000:1000\". Defaults to the value of the -container-user flag of 'src actions exec'.",
             "type": "string",
             "minLength": 1
           },
           "cacheDirs": {
-            "description": "Names of directories to create in a temporary location and mount into each \"docker\" step container under the specified name.",
+            "description": "Names of directories to create in a temporary location and mount into each \"docker\" and \"podman\" step container under the specified name.",
             "type": "array",
             "items": {
               "type": "string"
             }
+          },
+          "env": {
+            "description": "Environment variables that are set for this step, in addition to the ones set for the whole action.",
+            "$ref": "#/definitions/env"
+          },
+          "if": {
+            "description": "The conditions under which the step is executed. All of the given conditions must be met, otherwise the step is skipped.",
+            "type": "object",
+            "additionalProperties": false,
+            "minProperties": 1,
+            "properties": {
+              "previousStepChangedFiles": {
+                "description": "Execute the step only if the previous step changed files in the repository (true) or didn't change any (false).",
+                "type": "boolean"
+              },
+              "fileExists": {
+                "description": "Execute the step only if this path, relative to the repository root, exists.",
+                "type": "string",
+                "minLength": 1
+              },
+              "repoMatches": {
+                "description": "Execute the step only if the repository name matches this glob pattern, e.g. \"github.com/sourcegraph/*\".",
+                "type": "string",
+                "minLength": 1
+              },
+              "outputNotEmpty": {
+                "description": "Execute the step only if the output with this name, captured by a previous step, is not empty.",
+                "type": "string",
+                "minLength": 1
+              }
+            }
+          },
+          "output": {
+            "description": "Capture the standard output of the step under this name. Subsequent steps get the output in an environment variable of the same name.",
+            "type": "string",
+            "pattern": "^[A-Za-z_][A-Za-z0-9_]*$"
+          },
+          "uses": {
+            "description": "The path or http(s) URL of a step bundle, a YAML or JSON file with \"params\" and \"steps\" that replace this step. Relative paths are relative to the file that contains the step. Steps that use a bundle can only set \"uses\", \"with\" and \"checksum\".",
+            "type": "string"
+          },
+          "with": {
+            "description": "The values of the parameters of the step bundle in \"uses\". The steps of the bundle refer to them as ${{ .Params.name }}.",
+            "type": "object",
+            "additionalProperties": { "type": ["string", "number", "boolean"] }
+          },
+          "checksum": {
+            "description": "The SHA-256 checksum of the step bundle file in \"uses\", e.g. \"sha256:2c26b4...\". Required for bundles fetched from URLs.",
+            "type": "string",
+            "pattern": "^sha256:[0-9a-fA-F]{64}$"
           }
+        }
+      }
+    },
+    "changeset": {
+      "description": "The changeset that is created from the patch produced in every repository. All fields can contain templates enclosed in ${{ and }}, such as ${{ .Repository.Name }}, which are expanded for every repository.",
+      "type": "object",
+      "additionalProperties": false,
+      "required": ["title"],
+      "properties": {
+        "title": {
+          "description": "The title of the changeset.",
+          "type": "string",
+          "minLength": 1
+        },
+        "body": {
+          "description": "The body of the changeset.",
+          "type": "string"
+        },
+        "commitMessage": {
+          "description": "The message of the commit that contains the patch. Defaults to the title.",
+          "type": "string",
+          "minLength": 1
         },
+        "author": {
+          "description": "The author of the commit that contains the patch.",
+          "type": "object",
+          "additionalProperties": false,
+          "required": ["name", "email"],
+          "properties": {
+            "name": {
+              "type": "string",
+              "minLength": 1
+            },
+            "email": {
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        }
+      }
+    },
+    "patchPolicy": {
+      "description": "Restrictions on the patches the action produces. The execution in a repository whose patch violates them fails with an error.",
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "include": {
+          "description": "Glob patterns of paths relative to the repository root, e.g. \"src/**/*.go\". Only changes to matching files are kept in the patch.",
+          "type": "array",
+          "items": {
+            "type": "string",
+            "minLength": 1
+          }
+        },
+        "exclude": {
+          "description": "Glob patterns of paths relative to the repository root, e.g. \"vendor/**\". Changes to matching files are removed from the patch.",
+          "type": "array",
+          "items": {
+            "type": "string",
+            "minLength": 1
+          }
+        },
+        "maxSize": {
+          "description": "The maximum size of a patch, e.g. \"500KB\" or \"1MB\".",
+          "type": "string",
+          "minLength": 1
+        },
+        "allowBinary": {
+          "description": "Whether patches can change binary files. Defaults to true.",
+          "type": "boolean"
+        }
+      }
+    }
+  },
+  "definitions": {
+    "scopeRepository": {
+      "oneOf": [
+        {
+          "description": "The name of the repository, optionally followed by @ and a branch or a full commit SHA, e.g. \"github.com/sourcegraph/src-cli@release-3.18\". Abbreviated commit SHAs are not supported.",
+          "type": "string",
+          "pattern": "^[^@\\s]+(@[^@\\s]+)?$"
+        },
+        {
+          "type": "object",
+          "additionalProperties": false,
+          "required": ["name"],
+          "properties": {
+            "name": {
+              "description": "The name of the repository, e.g. \"github.com/sourcegraph/src-cli\".",
+              "type": "string",
+              "minLength": 1
+            },
+            "branch": {
+              "description": "The branch the action is executed on and the changeset is based on, instead of the default branch.",
+              "type": "string",
+              "minLength": 1
+            },
+            "rev": {
+              "description": "The revision the action is executed at, instead of the head of the branch.",
+              "type": "string",
+              "minLength": 1
+            }
+          }
+        }
+      ]
+    },
+    "env": {
+      "type": "object",
+      "propertyNames": {
+        "pattern": "^[A-Za-z_][A-Za-z0-9_]*$"
+      },
+      "additionalProperties": {
         "oneOf": [
           {
-            "required": ["args"],
+            "description": "The literal value of the environment variable.",
+            "type": "string"
+          },
+          {
+            "type": "object",
+            "additionalProperties": false,
+            "required": ["fromEnv"],
             "properties": {
-              "type": { "const": "command" },
-              "image": { "type": "null" }
+              "fromEnv": {
+                "description": "The name of an environment variable of 'src' whose value is used.",
+                "type": "string",
+                "minLength": 1
+              }
             }
           },
           {
-            "required": ["image"],
+            "type": "object",
+            "additionalProperties": false,
+            "required": ["fromSecret"],
             "properties": {
-              "type": { "const": "docker" }
+              "fromSecret": {
+                "description": "The name of a secret in the file given with -secrets whose value is used. The value is redacted from the output and log files.",
+                "type": "string",
+                "minLength": 1
+              }
             }
           }
         ]